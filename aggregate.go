@@ -0,0 +1,176 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strconv"
+)
+
+// 使用指定字段构造查询语句, 去掉 ORDER BY 与 LIMIT
+func (q *SQ) fieldSql(field string) (string, error) {
+	c := *q
+	c.field = field
+	c.order = ""
+	c.limit = ""
+	return c.execSql()
+}
+
+// 查询第一行第一列的值, 没有记录时返回 sql.ErrNoRows
+func (q *SQ) scalar(query string, args []interface{}) (sql.NullString, error) {
+	var ret sql.NullString
+	ctx := q.context()
+	if q.cache {
+		err := q.db.cachedQuery(q.cacheTTL, q.cacheGroup, query, args, &ret, func() (interface{}, error) {
+			var v sql.NullString
+			err := q.db.readRow(ctx, query, args).Scan(&v)
+			return v, err
+		})
+		return ret, err
+	}
+	err := q.db.readRow(ctx, query, args).Scan(&ret)
+	return ret, err
+}
+
+// 查询聚合函数的值, 空集合的结果为NULL
+func (q *SQ) aggregate(fn, col string, args []interface{}) (sql.NullString, error) {
+	query, err := q.fieldSql(fn + "(" + col + ")")
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	ret, err := q.scalar(query, args)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sql.NullString{}, nil
+	}
+	return ret, err
+}
+
+// 统计记录数, 含 GROUP BY 或 DISTINCT 的查询统计分组数
+func (q *SQ) Count(args ...interface{}) (int64, error) {
+	query, err := q.countSql()
+	if err != nil {
+		return 0, err
+	}
+	ret, err := q.scalar(query, args)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(ret.String, 10, 64)
+}
+
+// 是否存在符合条件的记录
+func (q *SQ) Exists(args ...interface{}) (bool, error) {
+	c := *q
+	c.field = "1"
+	c.order = ""
+	c.Limit(1)
+	query, err := c.execSql()
+	if err != nil {
+		return false, err
+	}
+	_, err = q.scalar(query, args)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// 求和, 没有记录时返回0
+func (q *SQ) Sum(col string, args ...interface{}) (float64, error) {
+	ret, err := q.aggregate("SUM", col, args)
+	if err != nil || !ret.Valid {
+		return 0, err
+	}
+	return strconv.ParseFloat(ret.String, 64)
+}
+
+// 求平均值, 没有记录时 Valid 为false
+func (q *SQ) Avg(col string, args ...interface{}) (sql.NullFloat64, error) {
+	ret, err := q.aggregate("AVG", col, args)
+	if err != nil || !ret.Valid {
+		return sql.NullFloat64{}, err
+	}
+	f, err := strconv.ParseFloat(ret.String, 64)
+	if err != nil {
+		return sql.NullFloat64{}, err
+	}
+	return sql.NullFloat64{Float64: f, Valid: true}, nil
+}
+
+// 求最小值, 没有记录时 Valid 为false
+func (q *SQ) Min(col string, args ...interface{}) (sql.NullString, error) {
+	return q.aggregate("MIN", col, args)
+}
+
+// 求最大值, 没有记录时 Valid 为false
+func (q *SQ) Max(col string, args ...interface{}) (sql.NullString, error) {
+	return q.aggregate("MAX", col, args)
+}
+
+// 查询第一行第一列的整数值, 值为NULL时返回0, 没有记录时返回 sql.ErrNoRows
+func (q *SQ) ScalarInt64(args ...interface{}) (int64, error) {
+	query, err := q.execSql()
+	if err != nil {
+		return 0, err
+	}
+	ret, err := q.scalar(query, args)
+	if err != nil || !ret.Valid {
+		return 0, err
+	}
+	return strconv.ParseInt(ret.String, 10, 64)
+}
+
+// 查询第一行第一列的字符串值, 值为NULL时返回空字符串, 没有记录时返回 sql.ErrNoRows
+func (q *SQ) ScalarString(args ...interface{}) (string, error) {
+	query, err := q.execSql()
+	if err != nil {
+		return "", err
+	}
+	ret, err := q.scalar(query, args)
+	return ret.String, err
+}
+
+// 查询一列的值到切片, dest 为切片指针, 如 *[]int64、*[]string, 列值可能为NULL时使用 *[]sql.NullString 等
+// 保留 ORDER BY 与 LIMIT
+func (q *SQ) Pluck(col string, dest interface{}, args ...interface{}) error {
+	tp := reflect.TypeOf(dest)
+	if tp == nil || tp.Kind() != reflect.Ptr || tp.Elem().Kind() != reflect.Slice {
+		return errors.New("is not slice pointer")
+	}
+	c := *q
+	c.field = col
+	query, err := c.execSql()
+	if err != nil {
+		return err
+	}
+
+	ctx := q.context()
+	load := func() (interface{}, error) {
+		var list reflect.Value
+		err := q.db.queryScan(ctx, query, args, func(rows *sql.Rows) (int64, error) {
+			// 从库连接丢失改由主库执行时会再次调用
+			list = reflect.MakeSlice(tp.Elem(), 0, 0)
+			for rows.Next() {
+				v := reflect.New(tp.Elem().Elem())
+				if err := rows.Scan(v.Interface()); err != nil {
+					return int64(list.Len()), err
+				}
+				list = reflect.Append(list, v.Elem())
+			}
+			return int64(list.Len()), rows.Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+		return list.Interface(), nil
+	}
+	if q.cache {
+		return q.db.cachedQuery(q.cacheTTL, q.cacheGroup, query, args, dest, load)
+	}
+	list, err := load()
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(dest).Elem().Set(reflect.ValueOf(list))
+	return nil
+}
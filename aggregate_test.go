@@ -0,0 +1,197 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 按语句返回单列结果, 没有对应语句时返回空结果
+func scalarServer(srv *testServer, results map[string][]driver.Value) {
+	srv.handle = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		var rows [][]driver.Value
+		for _, v := range results[query] {
+			rows = append(rows, []driver.Value{v})
+		}
+		return []string{"v"}, rows, nil
+	}
+}
+
+func TestCountExists(t *testing.T) {
+	d, srv := newTestDB(t)
+	scalarServer(srv, map[string][]driver.Value{
+		"SELECT COUNT(*) FROM user WHERE age > ?":                              {int64(42)},
+		"SELECT COUNT(*) FROM (SELECT dept FROM user GROUP BY dept) AS _count": {int64(3)},
+		"SELECT 1 FROM user WHERE age > ? LIMIT 1":                             {int64(1)},
+	})
+
+	n, err := Select("id").DB(d).Table("user").Where("age > ?").Order("id").Limit(5).Count(18)
+	if err != nil || n != 42 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+	if !reflect.DeepEqual(srv.args(0), []driver.Value{int64(18)}) {
+		t.Fatalf("args = %v", srv.args(0))
+	}
+	if n, err = Select("dept").DB(d).Table("user").Group("dept").Count(); err != nil || n != 3 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+
+	ok, err := Select("id, name").DB(d).Table("user").Where("age > ?").Order("id").Exists(18)
+	if err != nil || !ok {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+	if ok, err = Select("id").DB(d).Table("user").Where("age < ?").Exists(18); err != nil || ok {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+
+	srv.reset()
+	srv.fail(errTestDeadlock)
+	if _, err = Select("id").DB(d).Table("user").Exists(); !IsDeadlock(err) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	d, srv := newTestDB(t)
+	scalarServer(srv, map[string][]driver.Value{
+		"SELECT SUM(amount) FROM orders WHERE uid = ?": {[]byte("12.5")},
+		"SELECT AVG(amount) FROM orders WHERE uid = ?": {[]byte("6.25")},
+		"SELECT MIN(amount) FROM orders WHERE uid = ?": {[]byte("2")},
+		"SELECT MAX(amount) FROM orders WHERE uid = ?": {[]byte("10.5")},
+		"SELECT SUM(amount) FROM orders WHERE uid = 0": {nil},
+		"SELECT AVG(amount) FROM orders WHERE uid = 0": {nil},
+		"SELECT MAX(amount) FROM orders WHERE uid = 0": {nil},
+	})
+	q := Select("*").DB(d).Table("orders").Where("uid = ?").Order("id DESC").Limit(10)
+
+	if sum, err := q.Sum("amount", 1); err != nil || sum != 12.5 {
+		t.Fatalf("Sum = %v, %v", sum, err)
+	}
+	if avg, err := q.Avg("amount", 1); err != nil || avg != (sql.NullFloat64{Float64: 6.25, Valid: true}) {
+		t.Fatalf("Avg = %v, %v", avg, err)
+	}
+	if min, err := q.Min("amount", 1); err != nil || min.String != "2" || !min.Valid {
+		t.Fatalf("Min = %v, %v", min, err)
+	}
+	if max, err := q.Max("amount", 1); err != nil || max.String != "10.5" {
+		t.Fatalf("Max = %v, %v", max, err)
+	}
+	// 聚合不影响原语句
+	if s, _ := q.ToSql(); s != "SELECT * FROM orders WHERE uid = ? ORDER BY id DESC LIMIT 10" {
+		t.Fatalf("ToSql = %q", s)
+	}
+
+	// 空集合的聚合结果为NULL
+	empty := Select("*").DB(d).Table("orders").Where("uid = 0")
+	if sum, err := empty.Sum("amount"); err != nil || sum != 0 {
+		t.Fatalf("Sum = %v, %v", sum, err)
+	}
+	if avg, err := empty.Avg("amount"); err != nil || avg.Valid {
+		t.Fatalf("Avg = %v, %v", avg, err)
+	}
+	if max, err := empty.Max("amount"); err != nil || max.Valid {
+		t.Fatalf("Max = %v, %v", max, err)
+	}
+	// 没有返回行时不返回 sql.ErrNoRows
+	if min, err := empty.Min("amount"); err != nil || min.Valid {
+		t.Fatalf("Min = %v, %v", min, err)
+	}
+}
+
+func TestScalar(t *testing.T) {
+	d, srv := newTestDB(t)
+	scalarServer(srv, map[string][]driver.Value{
+		"SELECT MAX(id) FROM user":                {int64(7)},
+		"SELECT name FROM user WHERE id = ?":      {[]byte("tom")},
+		"SELECT name FROM user WHERE id = 0":      {nil},
+		"SELECT parent_id FROM user WHERE id = 0": {nil},
+	})
+
+	if n, err := Select("MAX(id)").DB(d).Table("user").ScalarInt64(); err != nil || n != 7 {
+		t.Fatalf("ScalarInt64 = %d, %v", n, err)
+	}
+	if s, err := Select("name").DB(d).Table("user").Where("id = ?").ScalarString(1); err != nil || s != "tom" {
+		t.Fatalf("ScalarString = %q, %v", s, err)
+	}
+	if s, err := Select("name").DB(d).Table("user").Where("id = 0").ScalarString(); err != nil || s != "" {
+		t.Fatalf("ScalarString = %q, %v", s, err)
+	}
+	if n, err := Select("parent_id").DB(d).Table("user").Where("id = 0").ScalarInt64(); err != nil || n != 0 {
+		t.Fatalf("ScalarInt64 = %d, %v", n, err)
+	}
+	if _, err := Select("name").DB(d).Table("user").Where("id = 2").ScalarString(); err != sql.ErrNoRows {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := Select("name").DB(d).Table("user").Where("id = 2").ScalarInt64(); err != sql.ErrNoRows {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestPluck(t *testing.T) {
+	d, srv := newTestDB(t)
+	scalarServer(srv, map[string][]driver.Value{
+		"SELECT id FROM user WHERE age > ? ORDER BY id LIMIT 3": {int64(1), int64(2), int64(3)},
+		"SELECT name FROM user":                                 {[]byte("a"), nil},
+	})
+
+	var ids []int64
+	if err := Select("*").DB(d).Table("user").Where("age > ?").Order("id").Limit(3).Pluck("id", &ids, 18); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("ids = %v", ids)
+	}
+
+	var names []sql.NullString
+	if err := Select("*").DB(d).Table("user").Pluck("name", &names); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []sql.NullString{{String: "a", Valid: true}, {}}) {
+		t.Fatalf("names = %v", names)
+	}
+
+	// 没有记录时为空切片
+	if err := Select("*").DB(d).Table("user").Where("id = 0").Pluck("id", &ids); err != nil || ids == nil || len(ids) != 0 {
+		t.Fatalf("ids = %v, %v", ids, err)
+	}
+	if err := Select("*").DB(d).Table("user").Pluck("id", ids); err == nil {
+		t.Fatal("non pointer should fail")
+	}
+	var s []string
+	if err := Select("*").DB(d).Table("user").Pluck("name", &s); err == nil {
+		t.Fatal("scanning NULL into string should fail")
+	}
+}
+
+func TestAggregateCached(t *testing.T) {
+	d, srv := newTestDB(t)
+	scalarServer(srv, map[string][]driver.Value{
+		"SELECT COUNT(*) FROM user": {int64(5)},
+		"SELECT id FROM user":       {int64(1), int64(2)},
+	})
+
+	for i := 0; i < 2; i++ {
+		n, err := Select("id").DB(d).Table("user").Cache(time.Minute).Count()
+		if err != nil || n != 5 {
+			t.Fatalf("Count = %d, %v", n, err)
+		}
+		var ids []int64
+		if err = Select("id").DB(d).Table("user").Cache(time.Minute).Pluck("id", &ids); err != nil || !reflect.DeepEqual(ids, []int64{1, 2}) {
+			t.Fatalf("ids = %v, %v", ids, err)
+		}
+	}
+	if n := len(srv.queries()); n != 2 {
+		t.Fatalf("executed %d queries, want 2", n)
+	}
+
+	// 缓存结果可以被修改而不影响后续读取
+	var ids []int64
+	Select("id").DB(d).Table("user").Cache(time.Minute).Pluck("id", &ids)
+	ids[0] = 9
+	Select("id").DB(d).Table("user").Cache(time.Minute).Pluck("id", &ids)
+	if ids[0] != 1 {
+		t.Fatalf("cached ids = %v", ids)
+	}
+}
@@ -0,0 +1,358 @@
+package db
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// 默认缓存分组
+const defaultCacheGroup = "default"
+
+// Cache 默认的最大条目数, 可以使用 Cache.SetMaxEntries 修改
+const defaultCacheMaxEntries = 100000
+
+// 缓存条目
+type cacheEntry struct {
+	group  string
+	key    string
+	value  interface{}
+	expire time.Time // 过期时间, 零值表示不过期
+	elem   *list.Element
+	tags   []string
+}
+
+// 是否已过期
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && !now.Before(e.expire)
+}
+
+// 正在加载的缓存, 用于合并同一个键的并发加载
+type cacheCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// 缓存统计
+type CacheStats struct {
+	Entries   int64 // 当前的条目数
+	Hits      int64 // 命中次数
+	Misses    int64 // 未命中次数(包含已过期)
+	Sets      int64 // 设置次数
+	Evictions int64 // 超过最大条目数时被淘汰的条目数
+	Expired   int64 // 过期被删除的条目数
+}
+
+// 缓存数据对象定义, 可以并发使用
+// 超过最大条目数时淘汰最久未使用的条目; 过期的条目在读取或淘汰时删除
+type cache struct {
+	lock       sync.Mutex
+	data       map[string]map[string]*cacheEntry
+	groupTTL   map[string]time.Duration
+	lru        *list.List // 最近使用的条目在前
+	maxEntries int
+	loading    map[string]*cacheCall
+	tagged     map[string]map[*cacheEntry]struct{} // 按标签索引的条目
+	stats      CacheStats
+}
+
+func (this *cache) Init() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.data = map[string]map[string]*cacheEntry{defaultCacheGroup: {}}
+	this.groupTTL = make(map[string]time.Duration)
+	this.lru = list.New()
+	this.loading = make(map[string]*cacheCall)
+	this.tagged = make(map[string]map[*cacheEntry]struct{})
+	this.stats = CacheStats{}
+}
+
+// 获取分组名称
+func cacheGroup(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return defaultCacheGroup
+}
+
+// 设置最大条目数, 0表示不限制; 当前条目数超过时立即淘汰
+func (this *cache) SetMaxEntries(n int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.maxEntries = n
+	this.evict()
+}
+
+// 设置分组的默认过期时间, 对之后未指定过期时间的设置生效, 0表示不过期
+func (this *cache) SetGroupTTL(group string, ttl time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if ttl > 0 {
+		this.groupTTL[group] = ttl
+	} else {
+		delete(this.groupTTL, group)
+	}
+}
+
+// 设置缓存, 使用分组的默认过期时间
+func (this *cache) Set(key string, value interface{}, args ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	group := cacheGroup(args)
+	this.set(group, key, value, this.groupTTL[group])
+}
+
+// 设置缓存并指定过期时间, 0表示不过期
+func (this *cache) SetTTL(key string, value interface{}, ttl time.Duration, args ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.set(cacheGroup(args), key, value, ttl)
+}
+
+// 设置带标签的缓存, 可以通过 delTag 删除同一标签的所有条目; ttl 为0时使用分组的默认过期时间
+func (this *cache) setTagged(group, key string, value interface{}, ttl time.Duration, tags []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if ttl <= 0 {
+		ttl = this.groupTTL[group]
+	}
+	e := this.set(group, key, value, ttl)
+	if e.elem == nil {
+		// 已被淘汰
+		return
+	}
+	this.untag(e)
+	e.tags = tags
+	for _, tag := range tags {
+		m, ok := this.tagged[tag]
+		if !ok {
+			m = make(map[*cacheEntry]struct{})
+			this.tagged[tag] = m
+		}
+		m[e] = struct{}{}
+	}
+}
+
+// 删除标签下的所有条目
+func (this *cache) delTag(tag string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for e := range this.tagged[tag] {
+		this.remove(e)
+	}
+	delete(this.tagged, tag)
+}
+
+// 解除条目的标签, 调用时需持有锁
+func (this *cache) untag(e *cacheEntry) {
+	for _, tag := range e.tags {
+		if m, ok := this.tagged[tag]; ok {
+			delete(m, e)
+			if len(m) == 0 {
+				delete(this.tagged, tag)
+			}
+		}
+	}
+	e.tags = nil
+}
+
+// 设置缓存并返回条目, 调用时需持有锁
+func (this *cache) set(group, key string, value interface{}, ttl time.Duration) *cacheEntry {
+	g, exist := this.data[group]
+	if !exist {
+		g = make(map[string]*cacheEntry)
+		this.data[group] = g
+	}
+	e, ok := g[key]
+	if ok {
+		this.lru.MoveToFront(e.elem)
+		this.untag(e)
+	} else {
+		e = &cacheEntry{group: group, key: key}
+		e.elem = this.lru.PushFront(e)
+		g[key] = e
+	}
+	e.value = value
+	e.expire = time.Time{}
+	if ttl > 0 {
+		e.expire = time.Now().Add(ttl)
+	}
+	this.stats.Sets++
+	this.evict()
+	return e
+}
+
+// 淘汰超出最大条目数的条目, 优先删除已过期的条目, 调用时需持有锁
+func (this *cache) evict() {
+	if this.maxEntries <= 0 || this.lru.Len() <= this.maxEntries {
+		return
+	}
+	now := time.Now()
+	for elem := this.lru.Back(); elem != nil && this.lru.Len() > this.maxEntries; {
+		prev := elem.Prev()
+		if e := elem.Value.(*cacheEntry); e.expired(now) {
+			this.remove(e)
+			this.stats.Expired++
+		}
+		elem = prev
+	}
+	for this.lru.Len() > this.maxEntries {
+		this.remove(this.lru.Back().Value.(*cacheEntry))
+		this.stats.Evictions++
+	}
+}
+
+// 删除条目, 调用时需持有锁
+func (this *cache) remove(e *cacheEntry) {
+	this.lru.Remove(e.elem)
+	e.elem = nil
+	this.untag(e)
+	if g, exist := this.data[e.group]; exist {
+		delete(g, e.key)
+	}
+}
+
+// 获取缓存数据, 不存在或已过期时返回nil
+func (this *cache) Get(key string, args ...string) interface{} {
+	v, _ := this.Lookup(key, args...)
+	return v
+}
+
+// 获取缓存数据, 并返回是否命中
+func (this *cache) Lookup(key string, args ...string) (interface{}, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.lookup(cacheGroup(args), key)
+}
+
+// 获取缓存数据, 调用时需持有锁
+func (this *cache) lookup(group, key string) (interface{}, bool) {
+	if g, exist := this.data[group]; exist {
+		if e, ok := g[key]; ok {
+			if e.expired(time.Now()) {
+				this.remove(e)
+				this.stats.Expired++
+			} else {
+				this.lru.MoveToFront(e.elem)
+				this.stats.Hits++
+				return e.value, true
+			}
+		}
+	}
+	this.stats.Misses++
+	return nil, false
+}
+
+// 获取缓存数据, 未命中时调用loader加载并以分组的默认过期时间保存
+// 同一个键的并发加载只会调用一次loader; loader返回错误时不保存
+func (this *cache) GetOrSet(key string, loader func() (interface{}, error), args ...string) (interface{}, error) {
+	group := cacheGroup(args)
+	this.lock.Lock()
+	if v, ok := this.lookup(group, key); ok {
+		this.lock.Unlock()
+		return v, nil
+	}
+	id := group + "\x00" + key
+	if call, ok := this.loading[id]; ok {
+		this.lock.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	this.loading[id] = call
+	this.lock.Unlock()
+
+	loaded := false
+	defer func() {
+		// loader 发生panic时不保存, 等待者得到错误, 当前goroutine继续panic
+		var r interface{}
+		if !loaded {
+			r = recover()
+			call.value, call.err = nil, loaderPanic(r)
+		}
+		this.lock.Lock()
+		delete(this.loading, id)
+		if loaded && call.err == nil {
+			this.set(group, key, call.value, this.groupTTL[group])
+		}
+		this.lock.Unlock()
+		close(call.done)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	call.value, call.err = loader()
+	loaded = true
+	return call.value, call.err
+}
+
+// 获取缓存数据的剩余过期时间, 不过期时为0; 第二个返回值表示是否存在
+func (this *cache) TTL(key string, args ...string) (time.Duration, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if g, exist := this.data[cacheGroup(args)]; exist {
+		if e, ok := g[key]; ok {
+			if e.expire.IsZero() {
+				return 0, true
+			}
+			if d := time.Until(e.expire); d > 0 {
+				return d, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// 删除缓存数据
+func (this *cache) Del(key string, args ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if g, exist := this.data[cacheGroup(args)]; exist {
+		if e, ok := g[key]; ok {
+			this.remove(e)
+		}
+	}
+}
+
+// 删除分组中的所有缓存数据
+func (this *cache) DelGroup(group string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if g, exist := this.data[group]; exist {
+		for _, e := range g {
+			this.lru.Remove(e.elem)
+			e.elem = nil
+			this.untag(e)
+		}
+		if group == defaultCacheGroup {
+			this.data[group] = make(map[string]*cacheEntry)
+		} else {
+			delete(this.data, group)
+		}
+	}
+}
+
+// 删除所有已过期的缓存数据
+func (this *cache) DeleteExpired() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := time.Now()
+	for elem := this.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if e := elem.Value.(*cacheEntry); e.expired(now) {
+			this.remove(e)
+			this.stats.Expired++
+		}
+		elem = next
+	}
+}
+
+// 获取缓存统计
+func (this *cache) Stats() CacheStats {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	stats := this.stats
+	stats.Entries = int64(this.lru.Len())
+	return stats
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 缓存后端接口, 查询缓存通过该接口读写数据
+// 多个实例共享缓存时使用外部缓存服务(如 RedisBackend), 默认使用进程内的 Cache
+type CacheBackend interface {
+	// 获取缓存数据, 第二个返回值表示是否命中
+	Get(group, key string) ([]byte, bool, error)
+	// 设置缓存数据, ttl 小于等于0时使用后端的默认过期时间
+	Set(group, key string, value []byte, ttl time.Duration) error
+	// 删除缓存数据
+	Del(group, key string) error
+	// 获取缓存数据的剩余过期时间, 不过期时为0; 第二个返回值表示是否存在
+	TTL(group, key string) (time.Duration, bool, error)
+	// 删除分组中的所有缓存数据
+	DelGroup(group string) error
+}
+
+// 支持标签的缓存后端, 查询缓存以表令牌作为标签, 表的令牌更换时删除使用旧令牌的缓存数据
+// 不支持标签的后端依靠过期时间释放这些数据
+type taggedBackend interface {
+	// 设置带标签的缓存数据
+	SetTagged(group, key string, value []byte, ttl time.Duration, tags []string) error
+	// 删除标签下的所有缓存数据
+	DelTag(tag string) error
+}
+
+// 进程内缓存后端
+type memoryBackend struct {
+	c *cache
+}
+
+// 获取使用该缓存的缓存后端, 未指定过期时间时使用分组的默认过期时间
+func (this *cache) Backend() CacheBackend {
+	return memoryBackend{c: this}
+}
+
+func (b memoryBackend) Get(group, key string) ([]byte, bool, error) {
+	v, ok := b.c.Lookup(key, group)
+	if !ok {
+		return nil, false, nil
+	}
+	data, ok := v.([]byte)
+	return data, ok, nil
+}
+
+func (b memoryBackend) Set(group, key string, value []byte, ttl time.Duration) error {
+	if ttl > 0 {
+		b.c.SetTTL(key, value, ttl, group)
+	} else {
+		b.c.Set(key, value, group)
+	}
+	return nil
+}
+
+func (b memoryBackend) SetTagged(group, key string, value []byte, ttl time.Duration, tags []string) error {
+	b.c.setTagged(group, key, value, ttl, tags)
+	return nil
+}
+
+func (b memoryBackend) DelTag(tag string) error {
+	b.c.delTag(tag)
+	return nil
+}
+
+func (b memoryBackend) Del(group, key string) error {
+	b.c.Del(key, group)
+	return nil
+}
+
+func (b memoryBackend) TTL(group, key string) (time.Duration, bool, error) {
+	d, ok := b.c.TTL(key, group)
+	return d, ok, nil
+}
+
+func (b memoryBackend) DelGroup(group string) error {
+	b.c.DelGroup(group)
+	return nil
+}
+
+var (
+	cacheBackend     CacheBackend
+	cacheBackendLock sync.RWMutex
+	// 是否使用了查询缓存, 未使用时写入语句不需要使表的缓存失效
+	queryCacheUsed int32
+)
+
+// 设置查询缓存使用的缓存后端, 为nil时恢复使用 Cache
+// 设置后端后写入语句会使表的缓存失效, 即使本进程没有执行缓存查询, 以便其他实例共享的缓存失效
+func SetCacheBackend(b CacheBackend) {
+	cacheBackendLock.Lock()
+	cacheBackend = b
+	cacheBackendLock.Unlock()
+	if b != nil {
+		atomic.StoreInt32(&queryCacheUsed, 1)
+	}
+}
+
+// 是否使用了查询缓存
+func queryCacheInUse() bool {
+	return atomic.LoadInt32(&queryCacheUsed) == 1
+}
+
+// 获取查询缓存使用的缓存后端
+func GetCacheBackend() CacheBackend {
+	cacheBackendLock.RLock()
+	b := cacheBackend
+	cacheBackendLock.RUnlock()
+	if b == nil {
+		return Cache.Backend()
+	}
+	return b
+}
+
+// 合并同一个键的并发加载
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// 执行fn, 同一个键正在执行时等待其结果
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.lock.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*cacheCall)
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.lock.Unlock()
+
+	defer func() {
+		// fn 发生panic时等待者得到错误, 当前goroutine继续panic
+		r := recover()
+		if r != nil {
+			call.value, call.err = nil, loaderPanic(r)
+		}
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		close(call.done)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
+
+// 加载缓存时发生panic的错误
+func loaderPanic(r interface{}) error {
+	return fmt.Errorf("cache loader panic: %v", r)
+}
+
+// 生成随机的缓存令牌
+func newCacheToken() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,296 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redis 返回的错误
+type RedisError string
+
+func (e RedisError) Error() string {
+	return string(e)
+}
+
+// 使用 Redis RESP 协议的缓存后端, 多个实例可以共享缓存
+// 删除分组时按键前缀扫描, 分组名称中不应包含冒号, 否则会删除以该分组名称开头的其他分组
+type RedisBackend struct {
+	Addr     string        // 服务地址, 如 127.0.0.1:6379
+	Password string        // 密码, 为空时不认证
+	DB       int           // 数据库编号
+	Prefix   string        // 键前缀
+	Timeout  time.Duration // 连接及读写超时时间
+	MaxIdle  int           // 最大空闲连接数
+	lock     sync.Mutex
+	idle     []*redisConn
+}
+
+// 获取一个 Redis 缓存后端
+func NewRedisBackend(addr string) *RedisBackend {
+	return &RedisBackend{
+		Addr:    addr,
+		Prefix:  "db:",
+		Timeout: 3 * time.Second,
+		MaxIdle: 8,
+	}
+}
+
+// Redis 连接
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// 获取一个连接, 没有空闲连接时新建连接
+func (b *RedisBackend) conn() (*redisConn, error) {
+	b.lock.Lock()
+	if n := len(b.idle); n > 0 {
+		c := b.idle[n-1]
+		b.idle = b.idle[:n-1]
+		b.lock.Unlock()
+		return c, nil
+	}
+	b.lock.Unlock()
+
+	conn, err := net.DialTimeout("tcp", b.Addr, b.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	var cmds [][]string
+	if b.Password != "" {
+		cmds = append(cmds, []string{"AUTH", b.Password})
+	}
+	if b.DB != 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(b.DB)})
+	}
+	if len(cmds) > 0 {
+		if _, err = b.exec(c, cmds...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// 归还连接, 网络错误时关闭连接
+func (b *RedisBackend) release(c *redisConn, err error) {
+	if err != nil {
+		var re RedisError
+		if !errors.As(err, &re) {
+			c.conn.Close()
+			return
+		}
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.idle) >= b.MaxIdle {
+		c.conn.Close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+// 关闭所有空闲连接
+func (b *RedisBackend) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, c := range b.idle {
+		c.conn.Close()
+	}
+	b.idle = nil
+	return nil
+}
+
+// 以管道方式执行多条命令, 返回每条命令的结果, 任意一条命令出错时返回第一个错误
+func (b *RedisBackend) do(cmds ...[]string) ([]interface{}, error) {
+	c, err := b.conn()
+	if err != nil {
+		return nil, err
+	}
+	ret, err := b.exec(c, cmds...)
+	b.release(c, err)
+	return ret, err
+}
+
+// 在连接上执行命令
+func (b *RedisBackend) exec(c *redisConn, cmds ...[]string) ([]interface{}, error) {
+	if b.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(b.Timeout))
+	}
+	for _, cmd := range cmds {
+		c.w.WriteString("*" + strconv.Itoa(len(cmd)) + "\r\n")
+		for _, arg := range cmd {
+			c.w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+			c.w.WriteString(arg)
+			c.w.WriteString("\r\n")
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	var first error
+	ret := make([]interface{}, len(cmds))
+	for i := range cmds {
+		v, err := readReply(c.r)
+		if re, ok := err.(RedisError); ok {
+			if first == nil {
+				first = re
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = v
+	}
+	return ret, first
+}
+
+// 读取一个回复, 批量字符串返回 []byte, 空值返回nil
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, RedisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = readReply(r); err != nil {
+				if _, ok := err.(RedisError); !ok {
+					return nil, err
+				}
+				arr[i] = err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
+
+// 缓存数据的键
+func (b *RedisBackend) key(group, key string) string {
+	return b.Prefix + group + ":" + key
+}
+
+// 匹配分组中所有键的模式, 转义前缀及分组名称中的通配符
+func (b *RedisBackend) groupPattern(group string) string {
+	s := strings.Builder{}
+	for _, c := range b.Prefix + group + ":" {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			s.WriteByte('\\')
+		}
+		s.WriteRune(c)
+	}
+	s.WriteByte('*')
+	return s.String()
+}
+
+func (b *RedisBackend) Get(group, key string) ([]byte, bool, error) {
+	ret, err := b.do([]string{"GET", b.key(group, key)})
+	if err != nil {
+		return nil, false, err
+	}
+	data, ok := ret[0].([]byte)
+	return data, ok, nil
+}
+
+func (b *RedisBackend) Set(group, key string, value []byte, ttl time.Duration) error {
+	set := []string{"SET", b.key(group, key), string(value)}
+	if ttl > 0 {
+		ms := int64(ttl / time.Millisecond)
+		if ms <= 0 {
+			ms = 1
+		}
+		set = append(set, "PX", strconv.FormatInt(ms, 10))
+	}
+	_, err := b.do(set)
+	return err
+}
+
+func (b *RedisBackend) Del(group, key string) error {
+	_, err := b.do([]string{"DEL", b.key(group, key)})
+	return err
+}
+
+func (b *RedisBackend) TTL(group, key string) (time.Duration, bool, error) {
+	ret, err := b.do([]string{"PTTL", b.key(group, key)})
+	if err != nil {
+		return 0, false, err
+	}
+	ms, _ := ret[0].(int64)
+	switch {
+	case ms == -2:
+		return 0, false, nil
+	case ms < 0:
+		return 0, true, nil
+	}
+	return time.Duration(ms) * time.Millisecond, true, nil
+}
+
+func (b *RedisBackend) DelGroup(group string) error {
+	pattern := b.groupPattern(group)
+	cursor := "0"
+	for {
+		ret, err := b.do([]string{"SCAN", cursor, "MATCH", pattern, "COUNT", "500"})
+		if err != nil {
+			return err
+		}
+		reply, _ := ret[0].([]interface{})
+		if len(reply) != 2 {
+			return fmt.Errorf("redis: invalid SCAN reply %v", ret[0])
+		}
+		next, _ := reply[0].([]byte)
+		members, _ := reply[1].([]interface{})
+		del := make([]string, 1, len(members)+1)
+		del[0] = "DEL"
+		for _, m := range members {
+			if k, ok := m.([]byte); ok {
+				del = append(del, string(k))
+			}
+		}
+		if len(del) > 1 {
+			if _, err = b.do(del); err != nil {
+				return err
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
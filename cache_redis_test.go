@@ -0,0 +1,437 @@
+package db
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 进程内的 RESP 服务, 实现缓存后端用到的命令
+type testRedis struct {
+	addr     string
+	password string
+	ln       net.Listener
+	lock     sync.Mutex
+	data     map[string]string
+	expire   map[string]time.Time
+	cmds     []string
+	garbage  bool // 下一个回复返回非法内容
+}
+
+func newTestRedis(t *testing.T, password string) *testRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testRedis{
+		addr:     ln.Addr().String(),
+		password: password,
+		ln:       ln,
+		data:     make(map[string]string),
+		expire:   make(map[string]time.Time),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *testRedis) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	authed := s.password == ""
+	for {
+		v, err := readReply(r)
+		if err != nil {
+			return
+		}
+		arr, _ := v.([]interface{})
+		args := make([]string, len(arr))
+		for i, a := range arr {
+			b, _ := a.([]byte)
+			args[i] = string(b)
+		}
+		if len(args) == 0 {
+			return
+		}
+		s.lock.Lock()
+		s.cmds = append(s.cmds, strings.Join(args, " "))
+		var out string
+		switch {
+		case s.garbage:
+			s.garbage = false
+			out = "?\r\n"
+		case args[0] == "AUTH":
+			if args[1] == s.password {
+				authed = true
+				out = "+OK\r\n"
+			} else {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		default:
+			out = s.exec(args)
+		}
+		s.lock.Unlock()
+		if _, err = c.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *testRedis) alive(key string) bool {
+	if _, ok := s.data[key]; !ok {
+		return false
+	}
+	if at, ok := s.expire[key]; ok && !time.Now().Before(at) {
+		delete(s.data, key)
+		delete(s.expire, key)
+		return false
+	}
+	return true
+}
+
+func bulk(v string) string {
+	return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+}
+
+func (s *testRedis) exec(args []string) string {
+	switch args[0] {
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		if !s.alive(args[1]) {
+			return "$-1\r\n"
+		}
+		return bulk(s.data[args[1]])
+	case "SET":
+		s.data[args[1]] = args[2]
+		delete(s.expire, args[1])
+		if len(args) == 5 && args[3] == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			s.expire[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if s.alive(k) {
+				n++
+			}
+			delete(s.data, k)
+			delete(s.expire, k)
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "PTTL":
+		if !s.alive(args[1]) {
+			return ":-2\r\n"
+		}
+		at, ok := s.expire[args[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return ":" + strconv.FormatInt(int64(time.Until(at)/time.Millisecond), 10) + "\r\n"
+	case "SCAN":
+		// 每次返回两个键, 游标为下一个键, 扫描期间删除已返回的键不影响后续结果
+		prefix, ok := scanPrefix(args[3])
+		if !ok {
+			return "-ERR unsupported pattern\r\n"
+		}
+		from := ""
+		if args[1] != "0" {
+			b, _ := hex.DecodeString(args[1])
+			from = string(b)
+		}
+		var keys []string
+		for k := range s.data {
+			if strings.HasPrefix(k, prefix) && k >= from && s.alive(k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		next := "0"
+		if len(keys) > 2 {
+			next = hex.EncodeToString([]byte(keys[2]))
+			keys = keys[:2]
+		}
+		out := "*2\r\n" + bulk(next) + "*" + strconv.Itoa(len(keys)) + "\r\n"
+		for _, k := range keys {
+			out += bulk(k)
+		}
+		return out
+	}
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+// 将以 * 结尾的模式还原为前缀
+func scanPrefix(pattern string) (string, bool) {
+	if !strings.HasSuffix(pattern, "*") || strings.HasSuffix(pattern, "\\*") {
+		return "", false
+	}
+	s := strings.Builder{}
+	pattern = pattern[:len(pattern)-1]
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '\\':
+			i++
+			if i < len(pattern) {
+				s.WriteByte(pattern[i])
+			}
+			continue
+		case '*', '?', '[', ']':
+			return "", false
+		}
+		s.WriteByte(c)
+	}
+	return s.String(), true
+}
+
+func (s *testRedis) commands() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+func (s *testRedis) size() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.data)
+}
+
+func TestRedisBackendGetSet(t *testing.T) {
+	s := newTestRedis(t, "")
+	b := NewRedisBackend(s.addr)
+	defer b.Close()
+
+	if _, ok, err := b.Get("g", "k"); err != nil || ok {
+		t.Fatalf("Get missing = %v, %v", ok, err)
+	}
+	value := []byte("v\r\n$3\r\nx")
+	if err := b.Set("g", "k", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	data, ok, err := b.Get("g", "k")
+	if err != nil || !ok || string(data) != string(value) {
+		t.Fatalf("Get = %q, %v, %v", data, ok, err)
+	}
+	if _, ok, _ := b.Get("other", "k"); ok {
+		t.Fatal("groups should not share keys")
+	}
+	for _, cmd := range s.commands() {
+		if strings.HasPrefix(cmd, "SADD") {
+			t.Fatalf("unexpected command %q", cmd)
+		}
+	}
+	if s.size() != 1 {
+		t.Fatalf("redis holds %d keys, want 1", s.size())
+	}
+}
+
+func TestRedisBackendTTL(t *testing.T) {
+	s := newTestRedis(t, "")
+	b := NewRedisBackend(s.addr)
+	defer b.Close()
+
+	if _, ok, err := b.TTL("g", "none"); err != nil || ok {
+		t.Fatalf("TTL missing = %v, %v", ok, err)
+	}
+	b.Set("g", "forever", []byte("1"), 0)
+	if d, ok, err := b.TTL("g", "forever"); err != nil || !ok || d != 0 {
+		t.Fatalf("TTL forever = %v, %v, %v", d, ok, err)
+	}
+	b.Set("g", "minute", []byte("1"), time.Minute)
+	if d, ok, err := b.TTL("g", "minute"); err != nil || !ok || d <= 50*time.Second || d > time.Minute {
+		t.Fatalf("TTL minute = %v, %v, %v", d, ok, err)
+	}
+
+	// 不足1毫秒的过期时间按1毫秒设置
+	b.Set("g", "short", []byte("1"), time.Microsecond)
+	cmds := s.commands()
+	if last := cmds[len(cmds)-1]; last != "SET db:g:short 1 PX 1" {
+		t.Fatalf("last command = %q", last)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := b.Get("g", "short"); ok {
+		t.Fatal("expired key should miss")
+	}
+}
+
+func TestRedisBackendDel(t *testing.T) {
+	s := newTestRedis(t, "")
+	b := NewRedisBackend(s.addr)
+	defer b.Close()
+
+	b.Set("g", "a", []byte("1"), 0)
+	b.Set("g", "b", []byte("2"), 0)
+	if err := b.Del("g", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := b.Get("g", "a"); ok {
+		t.Fatal("deleted key should miss")
+	}
+	if _, ok, _ := b.Get("g", "b"); !ok {
+		t.Fatal("other key should remain")
+	}
+}
+
+func TestRedisBackendDelGroup(t *testing.T) {
+	s := newTestRedis(t, "")
+	b := NewRedisBackend(s.addr)
+	b.Prefix = "app*:"
+	defer b.Close()
+
+	for i := 0; i < 5; i++ {
+		b.Set("g", strconv.Itoa(i), []byte("1"), 0)
+	}
+	b.Set("gx", "k", []byte("1"), 0)
+	b.Set("h", "k", []byte("1"), 0)
+	s.lock.Lock()
+	s.data["appx:g:k"] = "not ours"
+	s.lock.Unlock()
+
+	if err := b.DelGroup("g"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok, _ := b.Get("g", strconv.Itoa(i)); ok {
+			t.Fatalf("key %d should be deleted", i)
+		}
+	}
+	if _, ok, _ := b.Get("gx", "k"); !ok {
+		t.Fatal("group gx should remain")
+	}
+	if _, ok, _ := b.Get("h", "k"); !ok {
+		t.Fatal("group h should remain")
+	}
+	if s.size() != 3 {
+		t.Fatalf("redis holds %d keys, want 3", s.size())
+	}
+
+	scans := 0
+	for _, cmd := range s.commands() {
+		if strings.HasPrefix(cmd, "SCAN") {
+			scans++
+			if !strings.Contains(cmd, `MATCH app\*:g:* `) {
+				t.Fatalf("SCAN pattern not escaped: %q", cmd)
+			}
+		}
+	}
+	if scans != 3 {
+		t.Fatalf("SCAN called %d times, want 3", scans)
+	}
+
+	// 空分组
+	if err := b.DelGroup("empty"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisBackendAuth(t *testing.T) {
+	s := newTestRedis(t, "secret")
+	b := NewRedisBackend(s.addr)
+	b.DB = 2
+	defer b.Close()
+
+	var re RedisError
+	if err := b.Set("g", "k", []byte("1"), 0); !errors.As(err, &re) || !strings.HasPrefix(string(re), "NOAUTH") {
+		t.Fatalf("Set without password = %v", err)
+	}
+	b.Close()
+	b.Password = "wrong"
+	if _, _, err := b.Get("g", "k"); !errors.As(err, &re) || !strings.HasPrefix(string(re), "WRONGPASS") {
+		t.Fatalf("Get with wrong password = %v", err)
+	}
+	b.Close()
+	b.Password = "secret"
+	if err := b.Set("g", "k", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, cmd := range s.commands() {
+		if cmd == "SELECT 2" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("SELECT not sent")
+	}
+}
+
+func TestRedisBackendErrors(t *testing.T) {
+	s := newTestRedis(t, "")
+	b := NewRedisBackend(s.addr)
+	defer b.Close()
+
+	// 非法回复关闭连接
+	s.lock.Lock()
+	s.garbage = true
+	s.lock.Unlock()
+	if _, _, err := b.Get("g", "k"); err == nil || !strings.Contains(err.Error(), "invalid reply") {
+		t.Fatalf("Get with invalid reply = %v", err)
+	}
+	if len(b.idle) != 0 {
+		t.Fatal("broken connection should not be reused")
+	}
+	if err := b.Set("g", "k", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.idle) != 1 {
+		t.Fatalf("idle connections = %d, want 1", len(b.idle))
+	}
+
+	// 服务端错误保留连接
+	var re RedisError
+	if _, err := b.do([]string{"NOPE"}); !errors.As(err, &re) {
+		t.Fatalf("unknown command = %v", err)
+	}
+	if len(b.idle) != 1 {
+		t.Fatalf("idle connections = %d, want 1", len(b.idle))
+	}
+
+	// 连接失败
+	s.ln.Close()
+	b.Close()
+	b.Timeout = time.Second
+	if _, _, err := b.Get("g", "k"); err == nil {
+		t.Fatal("Get should fail when redis is down")
+	}
+	if err := b.DelGroup("g"); err == nil {
+		t.Fatal("DelGroup should fail when redis is down")
+	}
+}
+
+func TestRedisBackendQueryCache(t *testing.T) {
+	s := newTestRedis(t, "")
+	b := NewRedisBackend(s.addr)
+	defer b.Close()
+	SetCacheBackend(b)
+	defer SetCacheBackend(nil)
+
+	if err := b.Set(tableTokenGroup, "ns\x00t", []byte("tok"), 0); err != nil {
+		t.Fatal(err)
+	}
+	db := &Database{CacheNamespace: "ns"}
+	if token := db.tableToken(b, "t"); token != "tok" {
+		t.Fatalf("tableToken = %q", token)
+	}
+	db.InvalidateTables("t")
+	if token := db.tableToken(b, "t"); token == "tok" {
+		t.Fatal("InvalidateTables should replace the token")
+	}
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCache() *cache {
+	c := &cache{}
+	c.Init()
+	return c
+}
+
+func TestCacheGroups(t *testing.T) {
+	c := newTestCache()
+	c.Set("a", 1)
+	c.Set("a", 2, "user")
+	c.Set("b", 3, "user")
+
+	if v := c.Get("a"); v != 1 {
+		t.Fatalf("default group a = %v", v)
+	}
+	if v := c.Get("a", "user"); v != 2 {
+		t.Fatalf("user group a = %v", v)
+	}
+	c.Del("a", "user")
+	if _, ok := c.Lookup("a", "user"); ok {
+		t.Fatal("deleted key should miss")
+	}
+	if c.Get("a") != 1 {
+		t.Fatal("Del should only affect its group")
+	}
+
+	c.Set("a", 2, "user")
+	c.DelGroup("user")
+	if _, ok := c.Lookup("b", "user"); ok {
+		t.Fatal("DelGroup should delete the group")
+	}
+	c.DelGroup(defaultCacheGroup)
+	if _, ok := c.Lookup("a"); ok {
+		t.Fatal("DelGroup should delete the default group")
+	}
+	c.Set("c", 4)
+	if c.Get("c") != 4 || c.Stats().Entries != 1 {
+		t.Fatal("default group should be usable after DelGroup")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := newTestCache()
+	c.SetTTL("a", 1, 20*time.Millisecond)
+	c.Set("b", 2)
+	c.SetGroupTTL("g", 20*time.Millisecond)
+	c.Set("c", 3, "g")
+
+	if d, ok := c.TTL("a"); !ok || d <= 0 || d > 20*time.Millisecond {
+		t.Fatalf("TTL(a) = %v, %v", d, ok)
+	}
+	if d, ok := c.TTL("b"); !ok || d != 0 {
+		t.Fatalf("TTL(b) = %v, %v", d, ok)
+	}
+	if _, ok := c.TTL("c", "g"); !ok {
+		t.Fatal("c should exist")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Lookup("a"); ok {
+		t.Fatal("expired key should miss")
+	}
+	if _, ok := c.TTL("c", "g"); ok {
+		t.Fatal("group TTL should apply")
+	}
+	c.DeleteExpired()
+	if s := c.Stats(); s.Entries != 1 || s.Expired != 2 {
+		t.Fatalf("stats = %+v", s)
+	}
+
+	// 取消分组的过期时间后新的设置不再过期
+	c.SetGroupTTL("g", 0)
+	c.Set("c", 3, "g")
+	if d, ok := c.TTL("c", "g"); !ok || d != 0 {
+		t.Fatalf("TTL(c) = %v, %v", d, ok)
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	c := newTestCache()
+	c.SetMaxEntries(2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+	if _, ok := c.Lookup("b"); ok {
+		t.Fatal("least recently used entry should be evicted")
+	}
+	if c.Get("a") != 1 || c.Get("c") != 3 {
+		t.Fatal("recently used entries should be kept")
+	}
+
+	// 优先淘汰已过期的条目
+	c.SetMaxEntries(3)
+	c.SetTTL("x", 0, time.Nanosecond, "g")
+	c.Get("a")
+	time.Sleep(time.Millisecond)
+	c.Set("d", 4)
+	if _, ok := c.Lookup("c"); !ok {
+		t.Fatal("expired entry should be evicted first")
+	}
+
+	c.SetMaxEntries(1)
+	if s := c.Stats(); s.Entries != 1 || s.Evictions != 3 || s.Expired != 1 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestCacheTags(t *testing.T) {
+	c := newTestCache()
+	c.setTagged("g", "a", 1, 0, []string{"x", "y"})
+	c.setTagged("g", "b", 2, 0, []string{"y"})
+	c.Set("c", 3, "g")
+	c.delTag("x")
+	if _, ok := c.Lookup("a", "g"); ok {
+		t.Fatal("tagged entry should be deleted")
+	}
+	if c.Get("b", "g") != 2 || c.Get("c", "g") != 3 {
+		t.Fatal("other entries should be kept")
+	}
+
+	// 覆盖设置时去掉原来的标签
+	c.Set("b", 4, "g")
+	c.delTag("y")
+	if c.Get("b", "g") != 4 {
+		t.Fatal("untagged entry should be kept")
+	}
+	c.setTagged("g", "d", 5, 0, []string{"z"})
+	c.DelGroup("g")
+	if len(c.tagged) != 0 {
+		t.Fatalf("tags = %v", c.tagged)
+	}
+	if Cache.maxEntries != defaultCacheMaxEntries {
+		t.Fatalf("default max entries = %d", Cache.maxEntries)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := newTestCache()
+	c.Set("a", 1)
+	c.Set("a", 2)
+	c.Get("a")
+	c.Get("b")
+	if s := c.Stats(); s != (CacheStats{Entries: 1, Hits: 1, Misses: 1, Sets: 2}) {
+		t.Fatalf("stats = %+v", s)
+	}
+	c.Init()
+	if s := c.Stats(); s != (CacheStats{}) {
+		t.Fatalf("stats after Init = %+v", s)
+	}
+}
+
+func TestCacheGetOrSet(t *testing.T) {
+	c := newTestCache()
+	var calls int32
+	start := make(chan struct{})
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-start
+		return "v", nil
+	}
+
+	// 同一个键的并发加载只调用一次
+	var wg sync.WaitGroup
+	results := make([]interface{}, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = c.GetOrSet("k", loader)
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(start)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("loader called %d times", n)
+	}
+	for _, v := range results {
+		if v != "v" {
+			t.Fatalf("results = %v", results)
+		}
+	}
+	if c.Get("k") != "v" {
+		t.Fatal("loaded value should be cached")
+	}
+
+	// 加载失败时不保存
+	failed := errors.New("load failed")
+	if _, err := c.GetOrSet("e", func() (interface{}, error) { return nil, failed }, "g"); err != failed {
+		t.Fatalf("err = %v", err)
+	}
+	if _, ok := c.Lookup("e", "g"); ok {
+		t.Fatal("failed load should not be cached")
+	}
+}
+
+func TestCacheGetOrSetPanic(t *testing.T) {
+	c := newTestCache()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		c.GetOrSet("k", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err := c.GetOrSet("k", func() (interface{}, error) { return "other", nil })
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	// 等待者得到错误, 加载者继续panic, 不保存结果
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("err = %v", err)
+	}
+	if r := <-panicked; r != "boom" {
+		t.Fatalf("recovered %v", r)
+	}
+	if v, ok := c.Lookup("k"); ok {
+		t.Fatalf("Lookup = %v, %v", v, ok)
+	}
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 从库的选择方式
+type Balance int
+
+const (
+	BalanceRoundRobin   Balance = iota // 轮询
+	BalanceLeastLatency                // 选择平均耗时最短的从库
+)
+
+// 主从集群
+// 主库的 Select*/QueryStruct*/Query2Map* 查询及 SQ 构造的 SELECT 语句发送到从库, 其他语句及事务发送到主库;
+// 从库的语句使用从库对象的钩子及日志设置; 从库连续出现连接错误时暂时移出轮询, 期间查询由其他从库或主库执行
+type Cluster struct {
+	Primary     *Database
+	Balance     Balance       // 从库的选择方式
+	EjectAfter  int           // 连续出现连接错误多少次后移出轮询, 默认为3
+	EjectFor    time.Duration // 移出轮询的时长, 默认为30秒
+	PinWindow   time.Duration // 会话写入后查询发送到主库的时长, 默认为1秒, 参见 Session
+	WaitGTID    bool          // 会话写入后是否在从库上等待GTID追上主库, 代替 PinWindow
+	GTIDTimeout time.Duration // 等待GTID的超时时间, 默认为1秒
+	MaxLag      time.Duration // 最大复制延迟, 超过时从库不参与查询, 0表示不限制; 延迟由 MeasureLag 测量
+	replicas    []*replica
+	next        uint32
+	lock        sync.RWMutex
+}
+
+// 从库
+type replica struct {
+	db           *Database
+	latency      time.Duration // 平均耗时(指数加权移动平均)
+	fails        int           // 连续连接错误次数
+	ejectedUntil time.Time     // 移出轮询的截止时间
+	lag          time.Duration // 复制延迟, 未知时为-1
+}
+
+// 从库状态
+type ReplicaStatus struct {
+	DB       *Database
+	Latency  time.Duration // 平均耗时
+	Failures int           // 连续连接错误次数
+	Ejected  bool          // 是否已移出轮询
+	Lag      time.Duration // 复制延迟, 未知时为-1, 未测量时为0
+}
+
+// 创建主从集群, 主库对象的查询将按集群的设置路由
+func NewCluster(primary *Database, replicas ...*Database) *Cluster {
+	c := &Cluster{
+		Primary:    primary,
+		EjectAfter: 3,
+		EjectFor:   30 * time.Second,
+		PinWindow:  time.Second,
+	}
+	for _, db := range replicas {
+		c.replicas = append(c.replicas, &replica{db: db})
+	}
+	primary.cluster = c
+	return c
+}
+
+// 添加从库
+func (c *Cluster) AddReplica(db *Database) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.replicas = append(c.replicas, &replica{db: db})
+}
+
+// 获取从库状态
+func (c *Cluster) Replicas() []ReplicaStatus {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := time.Now()
+	ret := make([]ReplicaStatus, len(c.replicas))
+	for i, r := range c.replicas {
+		ret[i] = ReplicaStatus{
+			DB:       r.db,
+			Latency:  r.latency,
+			Failures: r.fails,
+			Ejected:  now.Before(r.ejectedUntil),
+			Lag:      r.lag,
+		}
+	}
+	return ret
+}
+
+// 选择一个从库, 没有可用的从库时返回nil
+func (c *Cluster) pick() *replica {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := time.Now()
+	var ready []*replica
+	for _, r := range c.replicas {
+		if now.Before(r.ejectedUntil) {
+			continue
+		}
+		if c.MaxLag > 0 && (r.lag < 0 || r.lag > c.MaxLag) {
+			continue
+		}
+		ready = append(ready, r)
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+	if c.Balance == BalanceLeastLatency {
+		best := ready[0]
+		for _, r := range ready[1:] {
+			if r.latency < best.latency {
+				best = r
+			}
+		}
+		return best
+	}
+	n := atomic.AddUint32(&c.next, 1)
+	return ready[int(n-1)%len(ready)]
+}
+
+// 记录从库的执行结果
+func (c *Cluster) observe(r *replica, d time.Duration, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if r.latency == 0 {
+		r.latency = d
+	} else {
+		r.latency = (r.latency*4 + d) / 5
+	}
+	if err == nil || !IsConnectionLost(err) {
+		r.fails = 0
+		return
+	}
+	r.fails++
+	ejectAfter := c.EjectAfter
+	if ejectAfter <= 0 {
+		ejectAfter = 3
+	}
+	if r.fails >= ejectAfter {
+		ejectFor := c.EjectFor
+		if ejectFor <= 0 {
+			ejectFor = 30 * time.Second
+		}
+		r.ejectedUntil = time.Now().Add(ejectFor)
+		r.fails = 0
+		DefaultLogger.Log(LevelWarn, "replica ejected", "error", err, "duration", ejectFor)
+	}
+}
+
+// 在从库上执行查询, 从库连接丢失时改由主库执行
+func (c *Cluster) read(ctx context.Context, fn func(db *Database) error) error {
+	r := c.route(ctx)
+	if r == nil {
+		return fn(c.Primary)
+	}
+	start := time.Now()
+	err := fn(r.db)
+	c.observe(r, time.Since(start), err)
+	if err != nil && IsConnectionLost(err) {
+		return fn(c.Primary)
+	}
+	return err
+}
+
+// 查询记录集, 设置了集群时在从库上执行, 从库连接丢失时改由主库执行
+func (this *Database) readQuery(ctx context.Context, query string, args []interface{}) (*sql.Rows, error) {
+	if this.cluster == nil {
+		return this.QueryContext(ctx, query, args...)
+	}
+	var rows *sql.Rows
+	err := this.cluster.read(ctx, func(db *Database) (err error) {
+		rows, err = db.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// 查询单条记录, 设置了集群时在从库上执行, 从库连接丢失时改由主库执行
+func (this *Database) readRow(ctx context.Context, query string, args []interface{}) *Row {
+	if this.cluster == nil {
+		return this.QueryRowContext(ctx, query, args...)
+	}
+	var row *Row
+	this.cluster.read(ctx, func(db *Database) error {
+		row = db.QueryRowContext(ctx, query, args...)
+		return row.Err()
+	})
+	return row
+}
+
+type forcePrimaryKey struct{}
+
+// 将上下文标记为强制使用主库, 使用该上下文的查询不会发送到从库
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+// 上下文是否被标记为强制使用主库
+func isForcePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(forcePrimaryKey{}).(bool)
+	return v
+}
+
+// 强制使用主库执行查询
+func (q *SQ) ForcePrimary(yes ...bool) *SQ {
+	if len(yes) == 1 && !yes[0] {
+		q.forcePrimary = false
+	} else {
+		q.forcePrimary = true
+	}
+	return q
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// 创建一主两从的测试集群
+func newTestCluster(t *testing.T) (*Cluster, *testServer, *testServer, *testServer) {
+	p, ps := newTestDB(t)
+	r1, rs1 := newTestDB(t)
+	r2, rs2 := newTestDB(t)
+	return NewCluster(p, r1, r2), ps, rs1, rs2
+}
+
+func TestClusterRouting(t *testing.T) {
+	c, ps, rs1, rs2 := newTestCluster(t)
+	d := c.Primary
+
+	for i := 0; i < 4; i++ {
+		if _, err := d.Select("SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rs1.queries()) != 2 || len(rs2.queries()) != 2 || len(ps.queries()) != 0 {
+		t.Fatal("queries should be balanced between replicas")
+	}
+
+	// 写入及事务发送到主库
+	d.Exec("UPDATE t SET a = 1")
+	d.Transaction(func(tx *Tx) error {
+		rows, err := tx.Query("SELECT a FROM t")
+		if err == nil {
+			rows.Close()
+		}
+		return err
+	})
+	if n := len(ps.queries()); n != 4 {
+		t.Fatalf("primary executed %d statements, want 4", n)
+	}
+
+	// SQ 构造的查询
+	Select("a").DB(d).Table("t").Query()
+	if n := len(rs1.queries()) + len(rs2.queries()); n != 5 {
+		t.Fatalf("replicas executed %d queries, want 5", n)
+	}
+	Select("a").DB(d).Table("t").ForcePrimary().Query()
+	d.SelectContext(ForcePrimary(context.Background()), "SELECT 2")
+	if n := len(ps.queries()); n != 6 {
+		t.Fatalf("primary executed %d statements, want 6", n)
+	}
+}
+
+func TestClusterEjection(t *testing.T) {
+	p, ps := newTestDB(t)
+	r, rs := newTestDB(t)
+	c := NewCluster(p, r)
+	c.EjectAfter = 2
+	useDefaultLogger(t, &testLogger{level: LevelError})
+
+	// 从库连接丢失时改由主库执行
+	rs.fail(errTestLost, errTestLost)
+	for i := 0; i < 2; i++ {
+		if _, err := p.Select("SELECT 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ps.queries()) != 2 {
+		t.Fatal("lost queries should fall back to the primary")
+	}
+	st := c.Replicas()
+	if len(st) != 1 || !st[0].Ejected || st[0].Failures != 0 {
+		t.Fatalf("status = %+v", st)
+	}
+	p.Select("SELECT 1")
+	if len(rs.queries()) != 2 || len(ps.queries()) != 3 {
+		t.Fatal("ejected replica should not be used")
+	}
+
+	// 其他错误不计入连续连接错误
+	c.replicas[0].ejectedUntil = time.Time{}
+	rs.fail(errTestLost, errTestDeadlock, errTestLost)
+	for i := 0; i < 3; i++ {
+		p.Select("SELECT 1")
+	}
+	if st = c.Replicas(); st[0].Ejected || st[0].Failures != 1 {
+		t.Fatalf("status = %+v", st)
+	}
+}
+
+func TestClusterBuilderFailover(t *testing.T) {
+	p, ps := newTestDB(t)
+	r, rs := newTestDB(t)
+	c := NewCluster(p, r)
+	c.EjectAfter = 4
+	useDefaultLogger(t, &testLogger{level: LevelError})
+	ps.result([]string{"n"}, []driver.Value{int64(5)})
+
+	// SQ 的单行查询、记录集查询及聚合查询同样在从库连接丢失时改由主库执行
+	rs.fail(errTestLost, errTestLost, errTestLost, errTestLost)
+	var n int64
+	if err := Select("n").DB(p).Table("t").QueryRow().Scan(&n); err != nil || n != 5 {
+		t.Fatalf("QueryRow = %d, %v", n, err)
+	}
+	rows, err := Select("n").DB(p).Table("t").QueryAllRow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if n, err = Select("n").DB(p).Table("t").Count(); err != nil || n != 5 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+	if n, err = Select("n").DB(p).Table("t").ScalarInt64(); err != nil || n != 5 {
+		t.Fatalf("ScalarInt64 = %d, %v", n, err)
+	}
+	if len(ps.queries()) != 4 {
+		t.Fatalf("primary executed %q", ps.queries())
+	}
+	if st := c.Replicas(); !st[0].Ejected {
+		t.Fatalf("status = %+v", st)
+	}
+}
+
+func TestClusterLeastLatency(t *testing.T) {
+	c, _, rs1, rs2 := newTestCluster(t)
+	c.Balance = BalanceLeastLatency
+	c.observe(c.replicas[0], 10*time.Millisecond, nil)
+	c.observe(c.replicas[1], time.Millisecond, nil)
+
+	c.Primary.Select("SELECT 1")
+	if len(rs1.queries()) != 0 || len(rs2.queries()) != 1 {
+		t.Fatal("the fastest replica should be used")
+	}
+	if l := c.Replicas()[1].Latency; l <= 0 {
+		t.Fatalf("latency = %v", l)
+	}
+}
+
+func TestClusterMaxLag(t *testing.T) {
+	c, ps, rs1, rs2 := newTestCluster(t)
+	c.MaxLag = time.Second
+	c.replicas[0].lag = 2 * time.Second
+	c.replicas[1].lag = -1
+
+	c.Primary.Select("SELECT 1")
+	if len(ps.queries()) != 1 {
+		t.Fatal("lagging or unknown replicas should not be used")
+	}
+	c.replicas[1].lag = 10 * time.Millisecond
+	c.Primary.Select("SELECT 1")
+	c.Primary.Select("SELECT 1")
+	if len(rs1.queries()) != 0 || len(rs2.queries()) != 2 {
+		t.Fatal("replica within MaxLag should be used")
+	}
+
+	r3, rs3 := newTestDB(t)
+	c.MaxLag = 0
+	c.AddReplica(r3)
+	for i := 0; i < 3; i++ {
+		c.Primary.Select("SELECT 1")
+	}
+	if len(c.Replicas()) != 3 || len(rs3.queries()) != 1 {
+		t.Fatal("added replica should be used")
+	}
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 数据库连接配置, 目前只支持 MySQL 驱动
+// 可以从环境变量(LoadConfigEnv)或 key=value 格式的文件(LoadConfigFile)中读取, 键名为字段名的小写下划线形式, 如 max_open_conns
+type Config struct {
+	Driver          string        // 驱动名称, 默认为 mysql
+	Host            string        // 主机地址, 默认为 127.0.0.1
+	Port            int           // 端口, 默认为 3306
+	User            string        // 用户名
+	Password        string        // 密码, String() 中不会输出
+	Database        string        // 数据库名
+	Charset         string        // 字符集, 默认为 utf8mb4
+	Collation       string        // 排序规则
+	Timezone        string        // 时区, 如 Asia/Shanghai, 对应驱动的 loc 参数, 需要同时开启 ParseTime
+	ParseTime       bool          // 是否将 DATE/DATETIME 列解析为 time.Time, 对应驱动的 parseTime 参数
+	TLS             string        // TLS 配置: true、false、skip-verify、preferred 或已注册的配置名称
+	Timeout         time.Duration // 连接超时时间
+	ReadTimeout     time.Duration // 读超时时间
+	WriteTimeout    time.Duration // 写超时时间
+	MaxOpenConns    int           // 最大连接数, 0表示不限制
+	MaxIdleConns    int           // 最大空闲连接数, 0表示使用 database/sql 的默认值
+	ConnMaxLifetime time.Duration // 连接的最长使用时间, 0表示不限制
+	ConnMaxIdleTime time.Duration // 连接的最长空闲时间, 0表示不限制
+	Ping            bool          // 打开后是否检查连接
+}
+
+// 获取默认配置
+func DefaultConfig() *Config {
+	return &Config{
+		Driver:  "mysql",
+		Host:    "127.0.0.1",
+		Port:    3306,
+		Charset: "utf8mb4",
+	}
+}
+
+// 配置的键名
+var configKeys = []string{
+	"driver", "host", "port", "user", "password", "database", "charset", "collation", "timezone", "parse_time", "tls",
+	"timeout", "read_timeout", "write_timeout", "max_open_conns", "max_idle_conns", "conn_max_lifetime", "conn_max_idle_time", "ping",
+}
+
+// 按键名设置配置项
+func (c *Config) set(key, value string) error {
+	var err error
+	switch key {
+	case "driver":
+		c.Driver = value
+	case "host":
+		c.Host = value
+	case "port":
+		c.Port, err = strconv.Atoi(value)
+	case "user":
+		c.User = value
+	case "password":
+		c.Password = value
+	case "database":
+		c.Database = value
+	case "charset":
+		c.Charset = value
+	case "collation":
+		c.Collation = value
+	case "timezone":
+		c.Timezone = value
+	case "parse_time":
+		c.ParseTime, err = strconv.ParseBool(value)
+	case "tls":
+		c.TLS = value
+	case "timeout":
+		c.Timeout, err = time.ParseDuration(value)
+	case "read_timeout":
+		c.ReadTimeout, err = time.ParseDuration(value)
+	case "write_timeout":
+		c.WriteTimeout, err = time.ParseDuration(value)
+	case "max_open_conns":
+		c.MaxOpenConns, err = strconv.Atoi(value)
+	case "max_idle_conns":
+		c.MaxIdleConns, err = strconv.Atoi(value)
+	case "conn_max_lifetime":
+		c.ConnMaxLifetime, err = time.ParseDuration(value)
+	case "conn_max_idle_time":
+		c.ConnMaxIdleTime, err = time.ParseDuration(value)
+	case "ping":
+		c.Ping, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config %s: %v", key, err)
+	}
+	return nil
+}
+
+// 从环境变量中读取配置, 未设置的项使用默认配置
+// 环境变量名为 前缀_键名 的大写形式, 如前缀为 DB 时读取 DB_HOST、DB_MAX_OPEN_CONNS
+func LoadConfigEnv(prefix string) (*Config, error) {
+	c := DefaultConfig()
+	for _, key := range configKeys {
+		name := strings.ToUpper(key)
+		if prefix != "" {
+			name = strings.ToUpper(prefix) + "_" + name
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			if err := c.set(key, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// 从 key=value 格式的文件中读取配置, 未设置的项使用默认配置
+// 空行及以 # 开头的行将被忽略, 值两端的空白及引号会被去掉
+func LoadConfigFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := DefaultConfig()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: missing '='", path, n)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if err := c.set(key, value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 检查配置是否有效
+func (c *Config) Validate() error {
+	var errs []string
+	if c.Driver != "mysql" {
+		errs = append(errs, fmt.Sprintf("unsupported driver %q", c.Driver))
+	}
+	if c.Host == "" {
+		errs = append(errs, "host is required")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("invalid port %d", c.Port))
+	}
+	if c.User == "" {
+		errs = append(errs, "user is required")
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid timezone %q", c.Timezone))
+		}
+		// 驱动只在 parseTime 开启时使用 loc
+		if !c.ParseTime {
+			errs = append(errs, "timezone requires parse_time")
+		}
+	}
+	if c.Timeout < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.ConnMaxLifetime < 0 || c.ConnMaxIdleTime < 0 {
+		errs = append(errs, "durations cannot be negative")
+	}
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		errs = append(errs, "pool sizes cannot be negative")
+	}
+	if c.MaxOpenConns > 0 && c.MaxIdleConns > c.MaxOpenConns {
+		errs = append(errs, "max_idle_conns cannot be greater than max_open_conns")
+	}
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// 获取驱动使用的连接字符串
+func (c *Config) DSN() string {
+	return c.dsn(c.Password)
+}
+
+// 生成连接字符串
+func (c *Config) dsn(password string) string {
+	s := strings.Builder{}
+	s.WriteString(c.User)
+	if password != "" {
+		s.WriteString(":")
+		s.WriteString(password)
+	}
+	s.WriteString("@tcp(")
+	s.WriteString(net.JoinHostPort(c.Host, strconv.Itoa(c.Port)))
+	s.WriteString(")/")
+	s.WriteString(c.Database)
+
+	params := url.Values{}
+	if c.Charset != "" {
+		params.Set("charset", c.Charset)
+	}
+	if c.Collation != "" {
+		params.Set("collation", c.Collation)
+	}
+	if c.ParseTime {
+		params.Set("parseTime", "true")
+	}
+	if c.Timezone != "" {
+		params.Set("loc", c.Timezone)
+	}
+	if c.TLS != "" {
+		params.Set("tls", c.TLS)
+	}
+	if c.Timeout > 0 {
+		params.Set("timeout", c.Timeout.String())
+	}
+	if c.ReadTimeout > 0 {
+		params.Set("readTimeout", c.ReadTimeout.String())
+	}
+	if c.WriteTimeout > 0 {
+		params.Set("writeTimeout", c.WriteTimeout.String())
+	}
+	if len(params) > 0 {
+		s.WriteString("?")
+		s.WriteString(params.Encode())
+	}
+	return s.String()
+}
+
+// 输出隐藏了密码的连接字符串
+func (c *Config) String() string {
+	if c.Password == "" {
+		return c.dsn("")
+	}
+	return c.dsn("******")
+}
+
+// 获取打开数据库的选项
+func (c *Config) Options() *OpenOptions {
+	return &OpenOptions{
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		ConnMaxLifetime: c.ConnMaxLifetime,
+		ConnMaxIdleTime: c.ConnMaxIdleTime,
+		Ping:            c.Ping,
+	}
+}
+
+// 按配置打开数据库并以name注册, 连接池参数按配置设置
+func OpenConfig(name string, c *Config) (*Database, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return Open(name, c.Driver, c.DSN(), c.Options())
+}
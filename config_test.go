@@ -0,0 +1,141 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 设置环境变量, 测试结束时恢复
+func setEnv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	setEnv(t, "APPDB_HOST", "db.local")
+	setEnv(t, "APPDB_USER", "app")
+	setEnv(t, "APPDB_MAX_OPEN_CONNS", "20")
+	setEnv(t, "APPDB_CONN_MAX_LIFETIME", "5m")
+	setEnv(t, "APPDB_PING", "true")
+
+	c, err := LoadConfigEnv("appdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Host != "db.local" || c.User != "app" || c.MaxOpenConns != 20 || c.ConnMaxLifetime != 5*time.Minute || !c.Ping {
+		t.Fatalf("config = %+v", c)
+	}
+	// 未设置的项使用默认配置
+	if c.Driver != "mysql" || c.Port != 3306 || c.Charset != "utf8mb4" {
+		t.Fatalf("config = %+v", c)
+	}
+
+	setEnv(t, "APPDB_PORT", "x")
+	if _, err = LoadConfigEnv("appdb"); err == nil || !strings.Contains(err.Error(), "invalid config port") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.conf")
+	content := `# 数据库配置
+host = 10.0.0.1
+PORT=3307
+user = "app"
+password = 'p=w#d'
+
+timezone = Asia/Shanghai
+parse_time = true
+read_timeout = 3s
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Host != "10.0.0.1" || c.Port != 3307 || c.User != "app" || c.Password != "p=w#d" || c.Timezone != "Asia/Shanghai" || !c.ParseTime || c.ReadTimeout != 3*time.Second {
+		t.Fatalf("config = %+v", c)
+	}
+
+	cases := map[string]string{
+		"host 10.0.0.1\n":       "db.conf:1: missing '='",
+		"\nunknown = 1\n":       `db.conf:2: unknown config key "unknown"`,
+		"timeout = 3 seconds\n": "db.conf:1: invalid config timeout",
+	}
+	for content, want := range cases {
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err = LoadConfigFile(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadConfigFile(%q) = %v, want %q", content, err, want)
+		}
+	}
+	if _, err = LoadConfigFile(filepath.Join(dir, "missing.conf")); !os.IsNotExist(err) {
+		t.Fatalf("missing file = %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	c := DefaultConfig()
+	c.User = "app"
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = &Config{Driver: "pgx", Port: 70000, Timezone: "Mars/Base", Timeout: -time.Second, MaxOpenConns: 2, MaxIdleConns: 3}
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("invalid config should fail")
+	}
+	for _, want := range []string{`unsupported driver "pgx"`, "host is required", "invalid port 70000", "user is required", `invalid timezone "Mars/Base"`, "timezone requires parse_time", "durations cannot be negative", "max_idle_conns cannot be greater"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("err %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestConfigDSN(t *testing.T) {
+	c := DefaultConfig()
+	c.User, c.Password, c.Database = "app", "secret", "shop"
+	c.Timezone, c.ParseTime, c.TLS, c.Timeout = "Asia/Shanghai", true, "true", 5*time.Second
+	want := "app:secret@tcp(127.0.0.1:3306)/shop?charset=utf8mb4&loc=Asia%2FShanghai&parseTime=true&timeout=5s&tls=true"
+	if dsn := c.DSN(); dsn != want {
+		t.Fatalf("DSN = %q", dsn)
+	}
+	// 输出时隐藏密码
+	if s := c.String(); strings.Contains(s, "secret") || !strings.HasPrefix(s, "app:******@") {
+		t.Fatalf("String = %q", s)
+	}
+
+	c = &Config{User: "app", Host: "::1", Port: 3306}
+	if dsn := c.DSN(); dsn != "app@tcp([::1]:3306)/" {
+		t.Fatalf("DSN = %q", dsn)
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	c := &Config{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: time.Hour, ConnMaxIdleTime: time.Minute, Ping: true}
+	want := OpenOptions{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: time.Hour, ConnMaxIdleTime: time.Minute, Ping: true}
+	if opts := c.Options(); *opts != want {
+		t.Fatalf("Options = %+v", opts)
+	}
+
+	isolateRegistry(t)
+	if _, err := OpenConfig("main", &Config{Driver: "mysql"}); err == nil || !strings.Contains(err.Error(), "invalid config") {
+		t.Fatalf("OpenConfig = %v", err)
+	}
+	if Use("main") != nil {
+		t.Fatal("invalid config should not be registered")
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 // 数据库工具包
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -13,127 +14,192 @@ import (
 
 // 数据容器抽象对象定义
 type Database struct {
-	Type string // 用来给SqlBuilder进行一些特殊的判断 (空值或mysql 皆表示这是一个MySQL实例)
-	DB   *sql.DB
+	Type           string // 用来给SqlBuilder进行一些特殊的判断 (空值或mysql 皆表示这是一个MySQL实例)
+	DB             *sql.DB
+	Retry          *RetryPolicy  // 重试策略, 为nil时不重试
+	Logger         Logger        // 语句日志, 为nil时只输出慢查询及Debug语句(使用DefaultLogger)
+	SlowThreshold  time.Duration // 慢查询阈值, 为0时不记录慢查询
+	LogRawArgs     bool          // 日志中是否输出原始参数, 默认只输出脱敏后的参数类型
+	CacheNamespace string        // 查询缓存键的命名空间, 多个实例共享缓存后端时需设置为相同的值, 为空时只在本对象内有效
+	Tracer         Tracer        // 追踪器, 为nil时不创建跨度
+	SQLComment     bool          // 是否按 sqlcommenter 格式在语句末尾添加 traceparent 及 WithSQLComment 设置的标签
+	hooks          []Hook
+	hookLock       sync.RWMutex
+	queue          *queueList
+	queueOptions   QueueOptions
+	queueLock      sync.Mutex
+	cluster        *Cluster // 所属的主从集群, 仅主库对象设置
+	metrics        *Metrics // 指标, 由 EnableMetrics 开启
 }
 
 const dbTag = "db"
 
-// SQL异步执行队列定义
-type queueList struct {
-	list     []*QueueItem //队列列表
-	sleeping chan bool
-	loop     chan bool
-	lock     sync.RWMutex
-	quit     chan bool
-	quited   bool
-}
+var (
+	lastError error
+	Cache     *cache
+	Obj       *Database // 未注册任何数据库时 SQ 构造的语句使用的数据库对象, 建议使用 Open/Register
+	Local     *Database // Deprecated: 使用 Register/Use 管理多个数据库
+)
 
-// SQL异步执行队列子元素定义
-type QueueItem struct {
-	DB     *Database     //数据库对象
-	Query  string        //SQL语句字符串
-	Params []interface{} //参数列表
+func init() {
+	Cache = &cache{}
+	Cache.Init()
+	Cache.SetMaxEntries(defaultCacheMaxEntries)
 }
 
-// 缓存数据对象定义
-type cache struct {
-	data map[string]map[string]interface{}
+// 关闭数据库连接
+// 先关闭SQL队列并等待队列中的语句执行完成, 需要限制等待时间时先调用 QueueShutdown
+func (this *Database) Close() {
+	this.closeQueue()
+	this.DB.Close()
 }
 
-func (this *cache) Init() {
-	this.data["default"] = make(map[string]interface{})
+// 获取最后发生的错误字符串
+func LastErr() string {
+	lastErrLock.RLock()
+	defer lastErrLock.RUnlock()
+	if lastError != nil {
+		return lastError.Error()
+	}
+	return ""
 }
 
-// 设置缓存
-func (this *cache) Set(key string, value interface{}, args ...string) {
-	var group string
-	if len(args) > 0 {
-		group = args[0]
-		if _, exist := this.data[group]; !exist {
-			this.data[group] = make(map[string]interface{})
+// 执行语句
+func (this *Database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return this.ExecContext(context.Background(), query, args...)
+}
+
+// 执行语句, 遇到可重试的错误时按重试策略重新执行
+func (this *Database) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var ret sql.Result
+	err := this.run(ctx, KindExec, query, args, func(stmt *Statement) error {
+		err := this.Retry.do(ctx, isIdempotent(ctx), func() (err error) {
+			ret, err = this.DB.ExecContext(ctx, stmt.Query, stmt.Args...)
+			return wrapError(err, stmt.Query, stmt.Args)
+		})
+		if err == nil {
+			stmt.RowsAffected, _ = ret.RowsAffected()
+			stmt.LastInsertID, _ = ret.LastInsertId()
 		}
-	} else {
-		group = "default"
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	this.data[group][key] = value
+	this.markWrite(ctx)
+	return ret, nil
 }
 
-// 获取缓存数据
-func (this *cache) Get(key string, args ...string) interface{} {
-	var group string
-	if len(args) > 0 {
-		group = args[0]
-	} else {
-		group = "default"
-	}
-	if g, exist := this.data[group]; exist {
-		if v, ok := g[key]; ok {
-			return v
-		}
+// 执行幂等语句, 连接丢失时也会按重试策略重新执行
+func (this *Database) ExecIdempotent(query string, args ...interface{}) (sql.Result, error) {
+	return this.ExecContext(Idempotent(context.Background()), query, args...)
+}
+
+// 查询记录集
+func (this *Database) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return this.QueryContext(context.Background(), query, args...)
+}
+
+// 查询记录集, 查询总是被视为幂等的
+// 由于结果集由调用者读取, 钩子中的耗时不包含读取结果集的时间, 返回行数未知
+func (this *Database) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := this.run(ctx, KindQuery, query, args, func(stmt *Statement) error {
+		return this.Retry.do(ctx, true, func() (err error) {
+			rows, err = this.DB.QueryContext(ctx, stmt.Query, stmt.Args...)
+			return wrapError(err, stmt.Query, stmt.Args)
+		})
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return rows, nil
 }
 
-// 删除缓存数据
-func (this *cache) Del(key string, args ...string) {
-	var group string
-	if len(args) > 0 {
-		group = args[0]
-	} else {
-		group = "default"
+// 查询并使用fn读取结果集, fn 返回读取的行数
+// 设置了主从集群时在从库上执行
+func (this *Database) queryScan(ctx context.Context, query string, args []interface{}, fn func(rows *sql.Rows) (int64, error)) error {
+	if this.cluster != nil && !isForcePrimary(ctx) {
+		return this.cluster.read(ctx, func(db *Database) error {
+			return db.queryScan(ForcePrimary(ctx), query, args, fn)
+		})
 	}
-	if g, exist := this.data[group]; exist {
-		if _, ok := g[key]; ok {
-			delete(this.data[group], key)
+	return this.run(ctx, KindQuery, query, args, func(stmt *Statement) error {
+		var rows *sql.Rows
+		err := this.Retry.do(ctx, true, func() (err error) {
+			rows, err = this.DB.QueryContext(ctx, stmt.Query, stmt.Args...)
+			return wrapError(err, stmt.Query, stmt.Args)
+		})
+		if err != nil {
+			return err
 		}
-	}
+		defer rows.Close()
+		stmt.Rows, err = fn(rows)
+		return err
+	})
 }
 
-var (
-	lastError error
-	Cache     *cache
-	queue     *queueList
-	Obj       *Database
-	Local     *Database
-)
-
-func init() {
-	Cache = &cache{data: make(map[string]map[string]interface{})}
-	Cache.Init()
-	queue = &queueList{}
-	go queue.Start()
+// 单条记录的查询结果, 用法同 sql.Row
+// 语句被钩子否决时 Scan 返回 *VetoError; 执行错误包装为 *Error, 没有记录时仍返回 sql.ErrNoRows
+type Row struct {
+	row   *sql.Row
+	err   error
+	query string
+	args  []interface{}
 }
 
-// 关闭数据库连接
-func (this *Database) Close() {
-	this.DB.Close()
+// 将记录复制到 dest, 没有记录时返回 sql.ErrNoRows
+func (r *Row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.wrap(r.row.Scan(dest...))
 }
 
-// 获取最后发生的错误字符串
-func LastErr() string {
-	if lastError != nil {
-		return lastError.Error()
+// 查询错误, 同 sql.Row.Err
+func (r *Row) Err() error {
+	if r.err != nil {
+		return r.err
 	}
-	return ""
+	return r.wrap(r.row.Err())
 }
 
-// 执行语句
-func (this *Database) Exec(query string, args ...interface{}) (sql.Result, error) {
-	return this.DB.Exec(query, args...)
+// 包装查询错误, sql.ErrNoRows 保持不变以便调用者直接比较
+func (r *Row) wrap(err error) error {
+	if err == nil || err == sql.ErrNoRows {
+		return err
+	}
+	return wrapError(err, r.query, r.args)
 }
 
 // 查询单条记录
-func (this *Database) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	return this.DB.Query(query, args...)
+func (this *Database) QueryRow(query string, args ...interface{}) *Row {
+	return this.QueryRowContext(context.Background(), query, args...)
 }
 
 // 查询单条记录
-func (this *Database) QueryRow(query string, args ...interface{}) *sql.Row {
-	return this.DB.QueryRow(query, args...)
+// 错误在调用 Scan 时才会返回, 因此钩子中无法获得执行错误
+func (this *Database) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	var row *sql.Row
+	err := this.run(ctx, KindQueryRow, query, args, func(stmt *Statement) error {
+		row = this.DB.QueryRowContext(ctx, stmt.Query, stmt.Args...)
+		return nil
+	})
+	if err != nil {
+		setLastErr(err)
+		return &Row{err: err}
+	}
+	return &Row{row: row, query: query, args: args}
 }
 
+// QueryStruct 查询单个实体
+// obj 为接收数据的实体指针
 func (this *Database) QueryStruct(obj interface{}, sql string, args ...interface{}) error {
+	return this.QueryStructContext(context.Background(), obj, sql, args...)
+}
+
+// QueryStructContext 查询单个实体
+func (this *Database) QueryStructContext(ctx context.Context, obj interface{}, sql string, args ...interface{}) error {
 	var (
 		tagMap  map[string]int
 		tp, tps reflect.Type
@@ -161,7 +227,7 @@ func (this *Database) QueryStruct(obj interface{}, sql string, args ...interface
 		}
 	}
 	// 执行查询
-	ret, err = this.queryAndReflectOne(sql, tagMap, tps, args...)
+	ret, err = this.queryAndReflectOne(ctx, sql, tagMap, tps, args...)
 	if nil != err {
 		return err
 	}
@@ -173,6 +239,11 @@ func (this *Database) QueryStruct(obj interface{}, sql string, args ...interface
 // QueryStructs 查询实体集合
 // obj 为接收数据的实体指针
 func (this *Database) QueryStructs(obj interface{}, sql string, args ...interface{}) error {
+	return this.QueryStructsContext(context.Background(), obj, sql, args...)
+}
+
+// QueryStructsContext 查询实体集合
+func (this *Database) QueryStructsContext(ctx context.Context, obj interface{}, sql string, args ...interface{}) error {
 	var (
 		tagMap  map[string]int
 		tp, tps reflect.Type
@@ -206,7 +277,7 @@ func (this *Database) QueryStructs(obj interface{}, sql string, args ...interfac
 	}
 
 	// 执行查询
-	ret, err = this.queryAndReflect(sql, tagMap, tp, args...)
+	ret, err = this.queryAndReflect(ctx, sql, tagMap, tp, args...)
 	if nil != err {
 		return err
 	}
@@ -219,12 +290,16 @@ func (this *Database) QueryStructs(obj interface{}, sql string, args ...interfac
 
 // 不建议使用 未做覆盖测试。使用时需注意是否正确返回。
 func (this *Database) Query2Maps(query string, args ...interface{}) (data []map[string]interface{}, err error) {
-	rows, err := this.Query(query, args...)
-	if err != nil {
-		return
-	}
-	defer rows.Close()
+	err = this.queryScan(context.Background(), query, args, func(rows *sql.Rows) (int64, error) {
+		var err error
+		data, err = scanInterfaceMaps(rows)
+		return int64(len(data)), err
+	})
+	return
+}
 
+// 读取结果集并按列类型转换
+func scanInterfaceMaps(rows *sql.Rows) (data []map[string]interface{}, err error) {
 	cols, err := rows.ColumnTypes()
 
 	// 构建接收队列
@@ -248,12 +323,18 @@ func (this *Database) Query2Maps(query string, args ...interface{}) (data []map[
 
 // 未做覆盖测试。使用时需注意是否正确返回。
 func (this *Database) Query2Map(query string, args ...interface{}) (data map[string]interface{}, err error) {
-	rows, err := this.Query(query, args...)
-	if err != nil {
+	err = this.queryScan(context.Background(), query, args, func(rows *sql.Rows) (n int64, err error) {
+		data, err = scanInterfaceMap(rows)
+		if data != nil {
+			n = 1
+		}
 		return
-	}
-	defer rows.Close()
+	})
+	return
+}
 
+// 读取结果集第一行并按列类型转换
+func scanInterfaceMap(rows *sql.Rows) (data map[string]interface{}, err error) {
 	cols, err := rows.ColumnTypes()
 
 	// 构建接收队列
@@ -287,12 +368,12 @@ func queryAndReflectMap(cols []*sql.ColumnType, row []interface{}, m map[string]
 			case "DECIMAL":
 				var v float64
 				if nil != row[i] {
-					v, _ = strconv.ParseFloat(string(row[i].([]byte)), 0)
+					v, _ = strconv.ParseFloat(string(rawBytes(row[i])), 0)
 				}
 				m[column.Name()] = v
 			default:
 				if row[i] != nil {
-					m[column.Name()] = string(row[i].([]byte))
+					m[column.Name()] = string(rawBytes(row[i]))
 				} else {
 					m[column.Name()] = ""
 				}
@@ -302,7 +383,7 @@ func queryAndReflectMap(cols []*sql.ColumnType, row []interface{}, m map[string]
 			"NullFloat64", "NullFloat32":
 			var v float64
 			if nil != row[i] {
-				v, _ = strconv.ParseFloat(string(row[i].([]byte)), 0)
+				v, _ = strconv.ParseFloat(string(rawBytes(row[i])), 0)
 			}
 			m[column.Name()] = v
 		case
@@ -326,18 +407,28 @@ func queryAndReflectMap(cols []*sql.ColumnType, row []interface{}, m map[string]
 	}
 }
 
-// queryAndReflect 查询并将结果反射成实体集合
-func (this *Database) queryAndReflectOne(sqls string,
+// queryAndReflectOne 查询并将第一行结果反射成实体
+func (this *Database) queryAndReflectOne(ctx context.Context, sqls string,
 	tagMap map[string]int,
 	tp reflect.Type, args ...interface{}) (*reflect.Value, error) {
 
+	var ret *reflect.Value
 	// 执行sql语句
-	rows, err := this.DB.Query(sqls, args...)
+	err := this.queryScan(ctx, sqls, args, func(rows *sql.Rows) (n int64, err error) {
+		ret, err = reflectOneRow(rows, tagMap, tp)
+		if ret != nil {
+			n = 1
+		}
+		return
+	})
 	if nil != err {
 		return nil, err
 	}
+	return ret, nil
+}
 
-	defer rows.Close()
+// reflectOneRow 将结果集的第一行反射成实体
+func reflectOneRow(rows *sql.Rows, tagMap map[string]int, tp reflect.Type) (*reflect.Value, error) {
 	// 开始枚举结果
 	cols, err := rows.Columns()
 	if nil != err {
@@ -370,17 +461,27 @@ func (this *Database) queryAndReflectOne(sqls string,
 }
 
 // queryAndReflect 查询并将结果反射成实体集合
-func (this *Database) queryAndReflect(sql string,
+func (this *Database) queryAndReflect(ctx context.Context, sqls string,
 	tagMap map[string]int,
 	tpSlice reflect.Type, args ...interface{}) (*reflect.Value, error) {
 
+	var ret *reflect.Value
 	// 执行sql语句
-	rows, err := this.DB.Query(sql, args...)
+	err := this.queryScan(ctx, sqls, args, func(rows *sql.Rows) (n int64, err error) {
+		ret, err = reflectRows(rows, tagMap, tpSlice)
+		if ret != nil {
+			n = int64(ret.Len())
+		}
+		return
+	})
 	if nil != err {
 		return nil, err
 	}
+	return ret, nil
+}
 
-	defer rows.Close()
+// reflectRows 将结果集反射成实体集合
+func reflectRows(rows *sql.Rows, tagMap map[string]int, tpSlice reflect.Type) (*reflect.Value, error) {
 	// 开始枚举结果
 	cols, err := rows.Columns()
 	if nil != err {
@@ -420,28 +521,32 @@ func reflectStruct(cols []string, tagMap map[string]int, feild reflect.Value, ro
 		switch feild.Type().Field(n).Type.Kind() {
 		case reflect.Bool:
 			if nil != row[i] {
-				feild.Field(n).SetBool("false" != string(row[i].([]byte)))
+				feild.Field(n).SetBool("false" != string(rawBytes(row[i])))
 			} else {
 				feild.Field(n).SetBool(false)
 			}
 		case reflect.String:
 			if nil != row[i] {
-				feild.Field(n).SetString(string(row[i].([]byte)))
+				feild.Field(n).SetString(string(rawBytes(row[i])))
 			} else {
 				feild.Field(n).SetString("")
 			}
 		case reflect.Float32, reflect.Float64:
 			if nil != row[i] {
-				v, e := strconv.ParseFloat(string(row[i].([]byte)), 0)
+				v, e := strconv.ParseFloat(string(rawBytes(row[i])), 0)
 				if nil == e {
 					feild.Field(n).SetFloat(v)
 				}
 			} else {
 				feild.Field(n).SetFloat(0)
 			}
+		case reflect.Struct: // 开启 parseTime 时时间列可以直接设置到 time.Time 字段
+			if t, ok := row[i].(time.Time); ok && feild.Field(n).Type() == reflect.TypeOf(t) {
+				feild.Field(n).Set(reflect.ValueOf(t))
+			}
 		case reflect.Slice: // 此处指处理binary，统一用[]byte返回
 			if nil != row[i] {
-				feild.Field(n).SetBytes(row[i].([]byte))
+				feild.Field(n).SetBytes(rawBytes(row[i]))
 			}
 		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
 			if nil != row[i] {
@@ -543,12 +648,25 @@ func (row OneRow) Set(key, val string) {
 
 // 查询不定字段的结果集
 func (this *Database) Select(query string, args ...interface{}) ([]map[string]string, error) {
-	rows, err := this.DB.Query(query, args...)
+	return this.SelectContext(context.Background(), query, args...)
+}
+
+// 查询不定字段的结果集
+func (this *Database) SelectContext(ctx context.Context, query string, args ...interface{}) ([]map[string]string, error) {
+	var results []map[string]string
+	err := this.queryScan(ctx, query, args, func(rows *sql.Rows) (int64, error) {
+		var err error
+		results, err = scanStringMaps(rows)
+		return int64(len(results)), err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return results, nil
+}
 
+// 读取不定字段的结果集
+func scanStringMaps(rows *sql.Rows) ([]map[string]string, error) {
 	cols, err := rows.Columns()
 	if err != nil {
 		return nil, err
@@ -587,7 +705,12 @@ func (this *Database) Select(query string, args ...interface{}) ([]map[string]st
 
 // 查询一行不定字段的结果
 func (this *Database) SelectOne(query string, args ...interface{}) (OneRow, error) {
-	ret, err := this.Select(query, args...)
+	return this.SelectOneContext(context.Background(), query, args...)
+}
+
+// 查询一行不定字段的结果
+func (this *Database) SelectOneContext(ctx context.Context, query string, args ...interface{}) (OneRow, error) {
+	ret, err := this.SelectContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -596,71 +719,3 @@ func (this *Database) SelectOne(query string, args ...interface{}) (OneRow, erro
 	}
 	return make(OneRow), nil
 }
-
-// 队列入栈
-func (this *queueList) Push(item *QueueItem) {
-	this.lock.Lock()
-	this.list = append(this.list, item)
-	this.lock.Unlock()
-}
-
-// 队列出栈
-func (this *queueList) Pop() chan *QueueItem {
-	item := make(chan *QueueItem)
-	go func() {
-		defer close(item)
-		for {
-			switch {
-			case len(this.list) == 0:
-				timeout := time.After(time.Second * 2)
-				select {
-				case <-this.quit:
-					this.quited = true
-					return
-				case <-timeout:
-					//log.Println("SQL Queue polling")
-				}
-			default:
-				this.lock.Lock()
-				i := this.list[0]
-				this.list = this.list[1:]
-				this.lock.Unlock()
-				select {
-				case item <- i:
-					return
-				case <-this.quit:
-					this.quited = true
-					return
-				}
-			}
-		}
-	}()
-	return item
-}
-
-// 执行开始执行
-func (this *queueList) Start() {
-	for {
-		if this.quited {
-			return
-		}
-		c := this.Pop()
-		item := <-c
-		item.DB.Exec(item.Query, item.Params...)
-	}
-}
-
-// 停止队列
-func (this *queueList) Stop() {
-	this.quit <- true
-}
-
-// 向Sql队列中插入一条执行语句
-func (this *Database) Queue(query string, args ...interface{}) {
-	item := &QueueItem{
-		DB:     this,
-		Query:  query,
-		Params: args,
-	}
-	queue.Push(item)
-}
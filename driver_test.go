@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 测试用的数据库驱动, 每个数据源对应一个 testServer, 记录执行的语句并返回预设的结果
+func init() {
+	sql.Register("test", testDriver{})
+}
+
+var (
+	testServers     = make(map[string]*testServer)
+	testServersLock sync.Mutex
+	testServerSeq   int64
+)
+
+// 执行过的语句
+type testStmt struct {
+	Query string
+	Args  []driver.Value
+}
+
+// 模拟的数据库服务
+type testServer struct {
+	lock     sync.Mutex
+	stmts    []testStmt
+	errs     []error // 依次作为后续语句的执行错误
+	cols     []string
+	rows     [][]driver.Value
+	lastID   int64
+	affected int64
+	pingErr  error
+	// 设置时代替预设的结果, 返回nil列表示使用预设的结果
+	handle func(query string, args []driver.Value) ([]string, [][]driver.Value, error)
+}
+
+// 创建模拟的数据库服务, 返回其数据源名称
+func newTestServer(t *testing.T) (string, *testServer) {
+	srv := &testServer{}
+	name := "s" + strconv.FormatInt(atomic.AddInt64(&testServerSeq, 1), 10)
+	testServersLock.Lock()
+	testServers[name] = srv
+	testServersLock.Unlock()
+	t.Cleanup(func() {
+		testServersLock.Lock()
+		delete(testServers, name)
+		testServersLock.Unlock()
+	})
+	return name, srv
+}
+
+// 创建使用测试驱动的数据库对象
+func newTestDB(t *testing.T) (*Database, *testServer) {
+	name, srv := newTestServer(t)
+	d, err := sql.Open("test", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	// 查询缓存是全局的, 使用唯一的命名空间避免命中其他测试的缓存
+	return &Database{DB: d, CacheNamespace: name}, srv
+}
+
+// 后续语句依次返回的错误, nil 表示成功
+func (s *testServer) fail(errs ...error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.errs = append(s.errs, errs...)
+}
+
+// 设置查询返回的结果
+func (s *testServer) result(cols []string, rows ...[]driver.Value) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cols, s.rows = cols, rows
+}
+
+// 执行过的语句
+func (s *testServer) queries() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := make([]string, len(s.stmts))
+	for i, st := range s.stmts {
+		ret[i] = st.Query
+	}
+	return ret
+}
+
+// 第i条语句的参数
+func (s *testServer) args(i int) []driver.Value {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stmts[i].Args
+}
+
+// 清空执行记录
+func (s *testServer) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stmts = nil
+}
+
+// 记录语句并返回预设的错误
+func (s *testServer) record(query string, args []driver.Value) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stmts = append(s.stmts, testStmt{Query: query, Args: args})
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	return nil
+}
+
+type testDriver struct{}
+
+func (testDriver) Open(name string) (driver.Conn, error) {
+	testServersLock.Lock()
+	srv := testServers[name]
+	testServersLock.Unlock()
+	if srv == nil {
+		return nil, errors.New("unknown test server " + name)
+	}
+	return &testConn{srv: srv}, nil
+}
+
+type testConn struct {
+	srv *testServer
+}
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) Begin() (driver.Tx, error) {
+	if err := c.srv.record("BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return testTx{c.srv}, nil
+}
+
+func (c *testConn) Ping(ctx context.Context) error {
+	c.srv.lock.Lock()
+	defer c.srv.lock.Unlock()
+	return c.srv.pingErr
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	ret := make([]driver.Value, len(args))
+	for i, a := range args {
+		ret[i] = a.Value
+	}
+	return ret
+}
+
+func (c *testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.srv.record(query, namedValues(args)); err != nil {
+		return nil, err
+	}
+	c.srv.lock.Lock()
+	defer c.srv.lock.Unlock()
+	return testResult{lastID: c.srv.lastID, affected: c.srv.affected}, nil
+}
+
+func (c *testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	values := namedValues(args)
+	if err := c.srv.record(query, values); err != nil {
+		return nil, err
+	}
+	c.srv.lock.Lock()
+	cols, rows, handle := c.srv.cols, c.srv.rows, c.srv.handle
+	c.srv.lock.Unlock()
+	if handle != nil {
+		hcols, hrows, err := handle(query, values)
+		if err != nil {
+			return nil, err
+		}
+		if hcols != nil {
+			cols, rows = hcols, hrows
+		}
+	}
+	return &testRows{cols: cols, rows: rows}, nil
+}
+
+type testTx struct {
+	srv *testServer
+}
+
+func (tx testTx) Commit() error {
+	return tx.srv.record("COMMIT", nil)
+}
+
+func (tx testTx) Rollback() error {
+	return tx.srv.record("ROLLBACK", nil)
+}
+
+type testResult struct {
+	lastID   int64
+	affected int64
+}
+
+func (r testResult) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+func (r testResult) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
+
+type testRows struct {
+	cols []string
+	rows [][]driver.Value
+	i    int
+}
+
+func (r *testRows) Columns() []string {
+	return r.cols
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
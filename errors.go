@@ -0,0 +1,250 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 错误分类
+type ErrorClass int
+
+const (
+	ClassUnknown         ErrorClass = iota // 未知错误
+	ClassDuplicate                         // 唯一键冲突
+	ClassDeadlock                          // 死锁
+	ClassLockWaitTimeout                   // 锁等待超时
+	ClassForeignKey                        // 外键约束
+	ClassConnectionLost                    // 连接丢失
+	ClassSyntax                            // 语法错误
+	ClassDataTooLong                       // 数据超长
+)
+
+var errorClassNames = map[ErrorClass]string{
+	ClassUnknown:         "unknown",
+	ClassDuplicate:       "duplicate",
+	ClassDeadlock:        "deadlock",
+	ClassLockWaitTimeout: "lock_wait_timeout",
+	ClassForeignKey:      "foreign_key",
+	ClassConnectionLost:  "connection_lost",
+	ClassSyntax:          "syntax",
+	ClassDataTooLong:     "data_too_long",
+}
+
+func (c ErrorClass) String() string {
+	if s, ok := errorClassNames[c]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// MySQL错误码与分类的对应关系
+var mysqlErrorClasses = map[int]ErrorClass{
+	1022: ClassDuplicate,
+	1062: ClassDuplicate,
+	1586: ClassDuplicate,
+	1213: ClassDeadlock,
+	1205: ClassLockWaitTimeout,
+	1216: ClassForeignKey,
+	1217: ClassForeignKey,
+	1451: ClassForeignKey,
+	1452: ClassForeignKey,
+	1053: ClassConnectionLost,
+	1927: ClassConnectionLost,
+	2006: ClassConnectionLost,
+	2013: ClassConnectionLost,
+	1064: ClassSyntax,
+	1149: ClassSyntax,
+	1406: ClassDataTooLong,
+}
+
+// 数据库执行错误, 包含错误分类、错误代码及产生错误的SQL
+type Error struct {
+	Class ErrorClass // 错误分类
+	Code  int        // 数据库错误代码, 无法识别时为0
+	Sql   string     // 产生错误的SQL(未绑定参数)
+	Args  []string   // 脱敏后的参数, 只保留类型及长度
+	Err   error      // 原始错误
+}
+
+func (e *Error) Error() string {
+	s := strings.Builder{}
+	s.WriteString(e.Err.Error())
+	if e.Sql != "" {
+		s.WriteString(" [sql: ")
+		s.WriteString(e.Sql)
+		if len(e.Args) > 0 {
+			s.WriteString(" args: (")
+			s.WriteString(strings.Join(e.Args, ", "))
+			s.WriteString(")")
+		}
+		s.WriteString("]")
+	}
+	return s.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+var lastErrLock sync.RWMutex
+
+// 记录最后发生的错误
+func setLastErr(err error) {
+	lastErrLock.Lock()
+	lastError = err
+	lastErrLock.Unlock()
+}
+
+// 包装数据库驱动返回的错误并记录为最后发生的错误
+func wrapError(err error, query string, args []interface{}) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		setLastErr(err)
+		return err
+	}
+	class, code := Classify(err)
+	e = &Error{
+		Class: class,
+		Code:  code,
+		Sql:   query,
+		Args:  redactArgs(args),
+		Err:   err,
+	}
+	setLastErr(e)
+	return e
+}
+
+// 参数脱敏, 只保留参数类型及长度
+func redactArgs(args []interface{}) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	ret := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			ret[i] = "NULL"
+		case string:
+			ret[i] = "string(" + strconv.Itoa(len(v)) + ")"
+		case []byte:
+			ret[i] = "[]byte(" + strconv.Itoa(len(v)) + ")"
+		default:
+			ret[i] = reflect.TypeOf(v).String()
+		}
+	}
+	return ret
+}
+
+// 对错误进行分类, 返回错误分类及数据库错误代码
+func Classify(err error) (ErrorClass, int) {
+	if err == nil {
+		return ClassUnknown, 0
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Class, e.Code
+	}
+	code := driverErrorCode(err)
+	if class, ok := mysqlErrorClasses[code]; ok {
+		return class, code
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return ClassConnectionLost, code
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "invalid connection") ||
+		strings.Contains(msg, "bad connection") ||
+		strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "connection reset by peer") ||
+		strings.Contains(msg, "connection refused") {
+		return ClassConnectionLost, code
+	}
+	return ClassUnknown, code
+}
+
+// 获取数据库驱动错误代码
+// 优先读取驱动错误结构的Number字段(如 mysql.MySQLError), 否则从 "Error 1062: ..." 格式的错误信息中解析
+func driverErrorCode(err error) int {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		rv := reflect.ValueOf(e)
+		if rv.Kind() == reflect.Ptr {
+			rv = rv.Elem()
+		}
+		if rv.Kind() == reflect.Struct {
+			f := rv.FieldByName("Number")
+			if f.IsValid() {
+				switch f.Kind() {
+				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+					return int(f.Uint())
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+					return int(f.Int())
+				}
+			}
+		}
+		msg := e.Error()
+		if strings.HasPrefix(msg, "Error ") {
+			end := 6
+			for end < len(msg) && msg[end] >= '0' && msg[end] <= '9' {
+				end++
+			}
+			if end > 6 {
+				return Atoi(msg[6:end])
+			}
+		}
+	}
+	return 0
+}
+
+// 获取错误的数据库错误代码
+func ErrorCode(err error) int {
+	_, code := Classify(err)
+	return code
+}
+
+// 获取错误分类
+func ErrorClassOf(err error) ErrorClass {
+	class, _ := Classify(err)
+	return class
+}
+
+// 是否为唯一键冲突错误
+func IsDuplicate(err error) bool {
+	return ErrorClassOf(err) == ClassDuplicate
+}
+
+// 是否为死锁错误
+func IsDeadlock(err error) bool {
+	return ErrorClassOf(err) == ClassDeadlock
+}
+
+// 是否为锁等待超时错误
+func IsLockWaitTimeout(err error) bool {
+	return ErrorClassOf(err) == ClassLockWaitTimeout
+}
+
+// 是否为外键约束错误
+func IsForeignKey(err error) bool {
+	return ErrorClassOf(err) == ClassForeignKey
+}
+
+// 是否为连接丢失错误
+func IsConnectionLost(err error) bool {
+	return ErrorClassOf(err) == ClassConnectionLost
+}
+
+// 是否为SQL语法错误
+func IsSyntax(err error) bool {
+	return ErrorClassOf(err) == ClassSyntax
+}
+
+// 是否为数据超长错误
+func IsDataTooLong(err error) bool {
+	return ErrorClassOf(err) == ClassDataTooLong
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// 与 mysql.MySQLError 结构相同的驱动错误
+type testMySQLError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *testMySQLError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.Number, e.Message)
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class ErrorClass
+		code  int
+	}{
+		{"nil", nil, ClassUnknown, 0},
+		{"number field", &testMySQLError{Number: 1062, Message: "Duplicate entry"}, ClassDuplicate, 1062},
+		{"wrapped number field", fmt.Errorf("insert: %w", &testMySQLError{Number: 1213}), ClassDeadlock, 1213},
+		{"message code", errors.New("Error 1205: Lock wait timeout exceeded"), ClassLockWaitTimeout, 1205},
+		{"foreign key", errors.New("Error 1452: Cannot add or update a child row"), ClassForeignKey, 1452},
+		{"syntax", errors.New("Error 1064: You have an error in your SQL syntax"), ClassSyntax, 1064},
+		{"data too long", errors.New("Error 1406: Data too long for column"), ClassDataTooLong, 1406},
+		{"server gone", errors.New("Error 2006: MySQL server has gone away"), ClassConnectionLost, 2006},
+		{"unknown code", errors.New("Error 1146: Table doesn't exist"), ClassUnknown, 1146},
+		{"bad conn", fmt.Errorf("exec: %w", driver.ErrBadConn), ClassConnectionLost, 0},
+		{"broken pipe", errors.New("write tcp: broken pipe"), ClassConnectionLost, 0},
+		{"invalid connection", errors.New("invalid connection"), ClassConnectionLost, 0},
+		{"plain", errors.New("something failed"), ClassUnknown, 0},
+		{"classified", &Error{Class: ClassDeadlock, Code: 7, Err: errors.New("x")}, ClassDeadlock, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, code := Classify(tt.err)
+			if class != tt.class || code != tt.code {
+				t.Fatalf("Classify = %v, %d, want %v, %d", class, code, tt.class, tt.code)
+			}
+			if ErrorClassOf(tt.err) != tt.class || ErrorCode(tt.err) != tt.code {
+				t.Fatal("ErrorClassOf/ErrorCode disagree with Classify")
+			}
+		})
+	}
+}
+
+func TestErrorPredicates(t *testing.T) {
+	err := fmt.Errorf("save: %w", &testMySQLError{Number: 1062})
+	if !IsDuplicate(err) || IsDeadlock(err) {
+		t.Fatal("1062 should only be a duplicate error")
+	}
+	checks := map[int]func(error) bool{
+		1213: IsDeadlock,
+		1205: IsLockWaitTimeout,
+		1451: IsForeignKey,
+		2013: IsConnectionLost,
+		1149: IsSyntax,
+		1406: IsDataTooLong,
+	}
+	for code, is := range checks {
+		if !is(&testMySQLError{Number: uint16(code)}) {
+			t.Errorf("code %d not matched", code)
+		}
+	}
+}
+
+func TestErrorClassString(t *testing.T) {
+	if ClassLockWaitTimeout.String() != "lock_wait_timeout" {
+		t.Fatal(ClassLockWaitTimeout.String())
+	}
+	if ErrorClass(100).String() != "unknown" {
+		t.Fatal(ErrorClass(100).String())
+	}
+}
+
+func TestDatabaseErrorWrapping(t *testing.T) {
+	d, srv := newTestDB(t)
+	cause := &testMySQLError{Number: 1062, Message: "Duplicate entry 'a'"}
+	srv.fail(cause)
+
+	_, err := d.Exec("INSERT INTO user (name, data, note) VALUES (?, ?, ?)", "secret", []byte("xyz"), nil)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("err = %T, want *Error", err)
+	}
+	if e.Class != ClassDuplicate || e.Code != 1062 || e.Sql != "INSERT INTO user (name, data, note) VALUES (?, ?, ?)" {
+		t.Fatalf("Error = %+v", e)
+	}
+	if !reflect.DeepEqual(e.Args, []string{"string(6)", "[]byte(3)", "NULL"}) {
+		t.Fatalf("Args = %v", e.Args)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatal("Error should unwrap to the driver error")
+	}
+	want := "Error 1062: Duplicate entry 'a' [sql: INSERT INTO user (name, data, note) VALUES (?, ?, ?) args: (string(6), []byte(3), NULL)]"
+	if err.Error() != want {
+		t.Fatalf("Error() = %q", err.Error())
+	}
+	if LastErr() != want {
+		t.Fatalf("LastErr() = %q", LastErr())
+	}
+
+	// 已包装的错误不重复包装
+	if again := wrapError(err, "SELECT 1", nil); again != err {
+		t.Fatal("wrapError should keep an existing *Error")
+	}
+	if (&Error{Err: errors.New("x")}).Error() != "x" {
+		t.Fatal("Error without sql should only contain the cause")
+	}
+}
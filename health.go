@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 数据库健康状态
+type HealthStatus struct {
+	Ok      bool          `json:"ok"`
+	Latency time.Duration `json:"latency_ns"` // 检查耗时
+	Version string        `json:"version,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Stats   sql.DBStats   `json:"stats"` // 连接池统计
+	Time    time.Time     `json:"time"`  // 检查时间
+}
+
+// 获取服务器版本的语句
+func versionQuery(dbType string) string {
+	switch dbType {
+	case "mssql":
+		return "SELECT @@VERSION"
+	case "oracle":
+		return "SELECT banner FROM v$version WHERE ROWNUM = 1"
+	}
+	return "SELECT VERSION()"
+}
+
+// 检查数据库是否可用, 返回检查耗时、连接池统计及服务器版本
+// 检查语句不经过钩子与日志
+func (this *Database) Health(ctx context.Context) (HealthStatus, error) {
+	status := HealthStatus{Time: time.Now()}
+	err := this.DB.PingContext(ctx)
+	if err == nil {
+		err = this.DB.QueryRowContext(ctx, versionQuery(this.Type)).Scan(&status.Version)
+	}
+	status.Latency = time.Since(status.Time)
+	status.Stats = this.DB.Stats()
+	if err != nil {
+		status.Error = err.Error()
+		return status, err
+	}
+	status.Ok = true
+	return status, nil
+}
+
+// 健康检查监控, 定时检查数据库并维护就绪状态
+// 连续失败 FailThreshold 次后变为未就绪, 未就绪期间以退避的间隔重新检查, 检查成功后恢复就绪;
+// database/sql 会丢弃失效的连接, 重新检查时将建立新的连接
+type Supervisor struct {
+	Name          string                                // 名称, 用于健康检查接口的输出
+	DB            *Database                             // 数据库对象
+	Interval      time.Duration                         // 检查间隔, 默认为10秒
+	Timeout       time.Duration                         // 单次检查的超时时间, 默认为5秒
+	FailThreshold int                                   // 连续失败多少次后变为未就绪, 默认为1
+	OnChange      func(ready bool, status HealthStatus) // 就绪状态改变时的回调, 可为nil
+	ready         int32                                 // 是否就绪
+	fails         int                                   // 连续失败次数
+	last          HealthStatus                          // 最后一次检查的结果
+	lock          sync.Mutex
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// 创建并开始健康检查监控, 开始前先同步检查一次
+func (this *Database) Supervise(name string, interval time.Duration) *Supervisor {
+	s := &Supervisor{
+		Name:          name,
+		DB:            this,
+		Interval:      interval,
+		Timeout:       5 * time.Second,
+		FailThreshold: 1,
+	}
+	s.Start()
+	return s
+}
+
+// 开始监控
+func (s *Supervisor) Start() {
+	s.lock.Lock()
+	if s.stop != nil {
+		s.lock.Unlock()
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.stop, s.done = stop, done
+	s.lock.Unlock()
+
+	s.check()
+	go s.loop(stop, done)
+}
+
+// 停止监控
+func (s *Supervisor) Stop() {
+	s.lock.Lock()
+	stop, done := s.stop, s.done
+	s.stop = nil
+	s.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// 监控循环
+func (s *Supervisor) loop(stop, done chan struct{}) {
+	defer close(done)
+
+	backoff := 500 * time.Millisecond
+	for {
+		wait := s.Interval
+		if wait <= 0 {
+			wait = 10 * time.Second
+		}
+		if !s.Ready() && backoff < wait {
+			// 未就绪时以退避的间隔重新检查
+			wait = backoff
+			backoff *= 2
+		} else {
+			backoff = 500 * time.Millisecond
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.check()
+	}
+}
+
+// 检查一次并更新就绪状态
+func (s *Supervisor) check() {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	status, err := s.DB.Health(ctx)
+	cancel()
+
+	s.lock.Lock()
+	s.last = status
+	was := atomic.LoadInt32(&s.ready) == 1
+	ready := was
+	if err == nil {
+		s.fails = 0
+		ready = true
+	} else {
+		s.fails++
+		threshold := s.FailThreshold
+		if threshold <= 0 {
+			threshold = 1
+		}
+		if s.fails >= threshold {
+			ready = false
+		}
+	}
+	if ready {
+		atomic.StoreInt32(&s.ready, 1)
+	} else {
+		atomic.StoreInt32(&s.ready, 0)
+	}
+	onChange := s.OnChange
+	s.lock.Unlock()
+
+	if ready != was {
+		if ready {
+			DefaultLogger.Log(LevelInfo, "database ready", "name", s.Name, "latency", status.Latency)
+		} else {
+			DefaultLogger.Log(LevelWarn, "database not ready", "name", s.Name, "error", status.Error)
+		}
+		if onChange != nil {
+			onChange(ready, status)
+		}
+	}
+}
+
+// 是否就绪
+func (s *Supervisor) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// 最后一次检查的结果
+func (s *Supervisor) Status() HealthStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.last
+}
+
+// 健康检查接口
+type healthHandler struct {
+	sups []*Supervisor
+}
+
+// 创建健康检查接口
+// 路径以 /live 或 /livez 结尾时为存活检查, 总是返回200; 其他路径为就绪检查, 所有数据库都就绪时返回200, 否则返回503
+func NewHealthHandler(sups ...*Supervisor) http.Handler {
+	return &healthHandler{sups: sups}
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.HasSuffix(r.URL.Path, "/live") || strings.HasSuffix(r.URL.Path, "/livez") {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok"}`))
+		return
+	}
+
+	type dbStatus struct {
+		Name  string       `json:"name"`
+		Ready bool         `json:"ready"`
+		Last  HealthStatus `json:"last"`
+	}
+	ret := struct {
+		Status    string     `json:"status"`
+		Databases []dbStatus `json:"databases"`
+	}{Status: "ok", Databases: make([]dbStatus, 0, len(h.sups))}
+	code := http.StatusOK
+	for _, s := range h.sups {
+		ready := s.Ready()
+		if !ready {
+			ret.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+		ret.Databases = append(ret.Databases, dbStatus{Name: s.Name, Ready: ready, Last: s.Status()})
+	}
+	b, _ := json.Marshal(ret)
+	w.WriteHeader(code)
+	w.Write(b)
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 设置检查连接时返回的错误
+func (s *testServer) setPingErr(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pingErr = err
+}
+
+// 创建可以通过健康检查的测试数据库
+func newHealthyDB(t *testing.T) (*Database, *testServer) {
+	d, srv := newTestDB(t)
+	srv.result([]string{"version"}, []driver.Value{[]byte("8.0.36")})
+	return d, srv
+}
+
+func TestHealth(t *testing.T) {
+	d, srv := newHealthyDB(t)
+	var hooked bool
+	d.AddHook(HookFuncs{BeforeFunc: func(stmt *Statement) error {
+		hooked = true
+		return nil
+	}})
+
+	status, err := d.Health(context.Background())
+	if err != nil || !status.Ok || status.Version != "8.0.36" || status.Latency <= 0 || status.Time.IsZero() {
+		t.Fatalf("Health = %+v, %v", status, err)
+	}
+	if q := srv.queries(); len(q) != 1 || q[0] != "SELECT VERSION()" {
+		t.Fatalf("queries = %q", q)
+	}
+	if hooked {
+		t.Fatal("health check should not run hooks")
+	}
+
+	srv.setPingErr(errors.New("Error 2003: Can't connect to MySQL server"))
+	status, err = d.Health(context.Background())
+	if err == nil || status.Ok || !strings.Contains(status.Error, "Error 2003") {
+		t.Fatalf("Health = %+v, %v", status, err)
+	}
+
+	for tp, want := range map[string]string{"": "SELECT VERSION()", "mssql": "SELECT @@VERSION", "oracle": "SELECT banner FROM v$version WHERE ROWNUM = 1"} {
+		if q := versionQuery(tp); q != want {
+			t.Errorf("versionQuery(%q) = %q", tp, q)
+		}
+	}
+}
+
+// 等待就绪状态变为 ready
+func waitReady(t *testing.T, s *Supervisor, ready bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Ready() != ready {
+		if time.Now().After(deadline) {
+			t.Fatalf("Ready() did not become %v", ready)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	useDefaultLogger(t, &testLogger{level: LevelError})
+	d, srv := newHealthyDB(t)
+	changes := make(chan bool, 10)
+	s := &Supervisor{
+		Name:          "main",
+		DB:            d,
+		Interval:      5 * time.Millisecond,
+		FailThreshold: 2,
+		OnChange:      func(ready bool, status HealthStatus) { changes <- ready },
+	}
+	s.Start()
+	defer s.Stop()
+
+	// 开始时同步检查一次
+	if !s.Ready() || !s.Status().Ok {
+		t.Fatalf("status = %+v", s.Status())
+	}
+	if ready := <-changes; !ready {
+		t.Fatal("OnChange should report ready")
+	}
+
+	srv.setPingErr(errors.New("Error 2003: Can't connect to MySQL server"))
+	waitReady(t, s, false)
+	if s.Status().Ok || <-changes {
+		t.Fatal("OnChange should report not ready")
+	}
+
+	srv.setPingErr(nil)
+	waitReady(t, s, true)
+	if !<-changes {
+		t.Fatal("OnChange should report ready again")
+	}
+
+	s.Stop()
+	n := len(srv.queries())
+	time.Sleep(20 * time.Millisecond)
+	if len(srv.queries()) != n {
+		t.Fatal("stopped supervisor should not check")
+	}
+	s.Stop()
+}
+
+func TestHealthHandler(t *testing.T) {
+	d1, _ := newHealthyDB(t)
+	d2, srv2 := newHealthyDB(t)
+	useDefaultLogger(t, &testLogger{level: LevelError})
+	s1 := d1.Supervise("primary", time.Hour)
+	defer s1.Stop()
+	srv2.setPingErr(errors.New("Error 2003: Can't connect to MySQL server"))
+	s2 := d2.Supervise("replica", time.Hour)
+	defer s2.Stop()
+	h := NewHealthHandler(s1, s2)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/health/livez", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"status":"ok"}` {
+		t.Fatalf("live = %d %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/health/ready", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("ready = %d %v", w.Code, w.Header())
+	}
+	var ret struct {
+		Status    string
+		Databases []struct {
+			Name  string
+			Ready bool
+			Last  HealthStatus
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+	if ret.Status != "unavailable" || len(ret.Databases) != 2 || !ret.Databases[0].Ready || ret.Databases[1].Ready || ret.Databases[1].Last.Error == "" {
+		t.Fatalf("body = %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	NewHealthHandler(s1).ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"ok"`) {
+		t.Fatalf("ready = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 语句执行方式
+type StmtKind int
+
+const (
+	_ StmtKind = iota
+	KindExec
+	KindQuery
+	KindQueryRow
+	KindBegin
+	KindCommit
+	KindRollback
+)
+
+var stmtKindNames = map[StmtKind]string{
+	KindExec:     "exec",
+	KindQuery:    "query",
+	KindQueryRow: "query_row",
+	KindBegin:    "begin",
+	KindCommit:   "commit",
+	KindRollback: "rollback",
+}
+
+func (k StmtKind) String() string {
+	return stmtKindNames[k]
+}
+
+// 语句执行信息, 在钩子之间传递
+type Statement struct {
+	Ctx          context.Context
+	DB           *Database
+	Kind         StmtKind
+	Query        string        // SQL语句, Before钩子中可以修改
+	Args         []interface{} // 参数列表, Before钩子中可以修改
+	Caller       string        // 调用位置(文件:行号), 异步队列中为入队时的位置
+	Queued       bool          // 是否由异步队列执行
+	InTx         bool          // 是否在事务中执行
+	Start        time.Time     // 开始执行时间
+	Duration     time.Duration // 执行耗时, 查询包含读取结果集的时间
+	RowsAffected int64         // 受影响的行数, 未知时为-1
+	LastInsertID int64         // 最后生成的自增ID, 未知时为-1
+	Rows         int64         // 返回的行数, 未知时为-1
+	Err          error         // 执行错误
+}
+
+// 语句钩子
+// Before 在语句执行前调用, 可以修改 Query 与 Args, 返回错误则否决该语句的执行
+// After 在语句执行后调用, 语句被否决时同样会被调用
+type Hook interface {
+	Before(stmt *Statement) error
+	After(stmt *Statement)
+}
+
+// 使用函数实现的钩子, 未设置的函数将被忽略
+type HookFuncs struct {
+	BeforeFunc func(stmt *Statement) error
+	AfterFunc  func(stmt *Statement)
+}
+
+func (h HookFuncs) Before(stmt *Statement) error {
+	if h.BeforeFunc != nil {
+		return h.BeforeFunc(stmt)
+	}
+	return nil
+}
+
+func (h HookFuncs) After(stmt *Statement) {
+	if h.AfterFunc != nil {
+		h.AfterFunc(stmt)
+	}
+}
+
+// 语句被钩子否决时返回的错误
+type VetoError struct {
+	Err error
+}
+
+func (e *VetoError) Error() string {
+	return "statement vetoed: " + e.Err.Error()
+}
+
+func (e *VetoError) Unwrap() error {
+	return e.Err
+}
+
+// 添加语句钩子, Before 按添加顺序调用, After 按相反顺序调用
+func (this *Database) AddHook(hooks ...Hook) {
+	this.hookLock.Lock()
+	defer this.hookLock.Unlock()
+	list := make([]Hook, 0, len(this.hooks)+len(hooks))
+	list = append(list, this.hooks...)
+	this.hooks = append(list, hooks...)
+}
+
+// 获取当前的钩子列表及指标
+func (this *Database) getHooks() ([]Hook, *Metrics) {
+	this.hookLock.RLock()
+	defer this.hookLock.RUnlock()
+	return this.hooks, this.metrics
+}
+
+type queuedKey struct{}
+type callerKey struct{}
+
+// 标记上下文为异步队列执行, caller 为入队时的调用位置
+func withQueued(ctx context.Context, caller string) context.Context {
+	return context.WithValue(context.WithValue(ctx, queuedKey{}, true), callerKey{}, caller)
+}
+
+// 只保留上下文中的值, 不随原上下文取消或超时, 用于异步执行入队时的上下文
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// 执行语句并调用钩子
+// fn 使用 stmt 中(可能已被钩子修改)的 Query 与 Args 执行语句, 并填写结果信息
+func (this *Database) run(ctx context.Context, kind StmtKind, query string, args []interface{}, fn func(stmt *Statement) error) error {
+	hooks, metrics := this.getHooks()
+	if len(hooks) == 0 && metrics == nil && this.Tracer == nil && !this.SQLComment && !this.logging(ctx) {
+		stmt := Statement{Ctx: ctx, Query: query, Args: args}
+		return fn(&stmt)
+	}
+
+	stmt := &Statement{
+		Ctx:          ctx,
+		DB:           this,
+		Kind:         kind,
+		Query:        query,
+		Args:         args,
+		RowsAffected: -1,
+		LastInsertID: -1,
+		Rows:         -1,
+	}
+	stmt.Queued, _ = ctx.Value(queuedKey{}).(bool)
+	stmt.InTx, _ = ctx.Value(txKey{}).(bool)
+	if c, ok := ctx.Value(callerKey{}).(string); ok {
+		stmt.Caller = c
+	} else if len(hooks) > 0 {
+		stmt.Caller = callerLocation()
+	}
+
+	stmt.Start = time.Now()
+	span := this.startSpan(stmt)
+	for _, h := range hooks {
+		if err := h.Before(stmt); err != nil {
+			stmt.Err = &VetoError{Err: err}
+			setLastErr(stmt.Err)
+			break
+		}
+	}
+	if stmt.Err == nil {
+		if span != nil {
+			span.SetAttribute("db.statement", stmt.Query)
+		}
+		if this.SQLComment {
+			stmt.Query = sqlComment(stmt, span)
+		}
+		stmt.Err = fn(stmt)
+	}
+	stmt.Duration = time.Since(stmt.Start)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].After(stmt)
+	}
+	this.logStatement(stmt)
+	if metrics != nil {
+		metrics.observe(stmt)
+	}
+	if span != nil {
+		endSpan(span, stmt)
+	}
+	return stmt.Err
+}
+
+var pkgPath = reflect.TypeOf((*Database)(nil)).Elem().PkgPath()
+
+// 获取本包之外的调用位置
+func callerLocation() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, pkgPath+".") || strings.HasSuffix(f.File, "_test.go") {
+			return f.File + ":" + strconv.Itoa(f.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 记录调用顺序的钩子
+type orderHook struct {
+	name  string
+	calls *[]string
+}
+
+func (h orderHook) Before(stmt *Statement) error {
+	*h.calls = append(*h.calls, "before "+h.name)
+	return nil
+}
+
+func (h orderHook) After(stmt *Statement) {
+	*h.calls = append(*h.calls, "after "+h.name)
+}
+
+func TestHookOrder(t *testing.T) {
+	d, _ := newTestDB(t)
+	var calls []string
+	d.AddHook(orderHook{"a", &calls}, orderHook{"b", &calls})
+	d.AddHook(orderHook{"c", &calls})
+
+	if _, err := d.Exec("UPDATE t SET a = 1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"before a", "before b", "before c", "after c", "after b", "after a"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls = %v", calls)
+	}
+}
+
+func TestHookRewrite(t *testing.T) {
+	d, srv := newTestDB(t)
+	d.AddHook(HookFuncs{BeforeFunc: func(stmt *Statement) error {
+		stmt.Query += " AND tenant = ?"
+		stmt.Args = append(stmt.Args, 9)
+		return nil
+	}})
+	if _, err := d.Exec("DELETE FROM t WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.queries()[0]; q != "DELETE FROM t WHERE id = ? AND tenant = ?" {
+		t.Fatalf("query = %q", q)
+	}
+	if args := srv.args(0); !reflect.DeepEqual(args, []driver.Value{int64(1), int64(9)}) {
+		t.Fatalf("args = %v", args)
+	}
+}
+
+func TestHookStatement(t *testing.T) {
+	d, srv := newTestDB(t)
+	srv.lastID, srv.affected = 7, 3
+	srv.result([]string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+	var stmts []Statement
+	d.AddHook(HookFuncs{AfterFunc: func(stmt *Statement) {
+		stmts = append(stmts, *stmt)
+	}})
+
+	d.Exec("UPDATE t SET a = 1")
+	d.Select("SELECT id FROM t")
+	if len(stmts) != 2 {
+		t.Fatalf("After called %d times", len(stmts))
+	}
+	exec, query := stmts[0], stmts[1]
+	if exec.Kind != KindExec || exec.RowsAffected != 3 || exec.LastInsertID != 7 || exec.Rows != -1 || exec.DB != d {
+		t.Fatalf("exec statement = %+v", exec)
+	}
+	if query.Kind != KindQuery || query.Rows != 2 || query.RowsAffected != -1 {
+		t.Fatalf("query statement = %+v", query)
+	}
+	if !strings.Contains(exec.Caller, "hook_test.go:") {
+		t.Fatalf("Caller = %q", exec.Caller)
+	}
+	if exec.InTx || exec.Queued || exec.Start.IsZero() {
+		t.Fatalf("exec statement = %+v", exec)
+	}
+
+	stmts = nil
+	d.Transaction(func(tx *Tx) error {
+		_, err := tx.Exec("UPDATE t SET a = 2")
+		return err
+	})
+	var kinds []string
+	for _, s := range stmts {
+		if !s.InTx {
+			t.Fatalf("%s statement should be in transaction", s.Kind)
+		}
+		kinds = append(kinds, s.Kind.String())
+	}
+	if !reflect.DeepEqual(kinds, []string{"begin", "exec", "commit"}) {
+		t.Fatalf("kinds = %v", kinds)
+	}
+}
+
+func TestHookVeto(t *testing.T) {
+	d, srv := newTestDB(t)
+	denied := errors.New("write is not allowed")
+	var after *Statement
+	d.AddHook(HookFuncs{
+		BeforeFunc: func(stmt *Statement) error {
+			if strings.HasPrefix(stmt.Query, "DELETE") {
+				return denied
+			}
+			return nil
+		},
+		AfterFunc: func(stmt *Statement) {
+			after = stmt
+		},
+	})
+
+	_, err := d.Exec("DELETE FROM t")
+	var veto *VetoError
+	if !errors.As(err, &veto) || !errors.Is(err, denied) {
+		t.Fatalf("err = %v", err)
+	}
+	if after == nil || after.Err != err {
+		t.Fatal("After should receive the veto error")
+	}
+	if len(srv.queries()) != 0 {
+		t.Fatal("vetoed statement should not be executed")
+	}
+	if LastErr() != err.Error() {
+		t.Fatalf("LastErr() = %q", LastErr())
+	}
+
+	if _, err = d.Exec("UPDATE t SET a = 1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHookVetoQueryRow(t *testing.T) {
+	d, srv := newTestDB(t)
+	denied := errors.New("denied")
+	d.AddHook(HookFuncs{BeforeFunc: func(stmt *Statement) error {
+		if stmt.Kind == KindQueryRow {
+			return denied
+		}
+		return nil
+	}})
+
+	var v int
+	err := d.QueryRow("SELECT 1").Scan(&v)
+	var veto *VetoError
+	if !errors.As(err, &veto) || veto.Err != denied {
+		t.Fatalf("Scan err = %v", err)
+	}
+	if err = d.QueryRow("SELECT 1").Err(); !errors.As(err, &veto) {
+		t.Fatalf("Err() = %v", err)
+	}
+
+	err = d.Transaction(func(tx *Tx) error {
+		return tx.QueryRow("SELECT 1").Scan(&v)
+	})
+	if !errors.As(err, &veto) {
+		t.Fatalf("transaction Scan err = %v", err)
+	}
+	for _, q := range srv.queries() {
+		if q == "SELECT 1" {
+			t.Fatal("vetoed query should not be executed")
+		}
+	}
+}
+
+func TestQueryRow(t *testing.T) {
+	d, srv := newTestDB(t)
+	srv.result([]string{"n"}, []driver.Value{int64(5)})
+	var n int
+	if err := d.QueryRow("SELECT n FROM t WHERE id = ?", 1).Scan(&n); err != nil || n != 5 {
+		t.Fatalf("Scan = %d, %v", n, err)
+	}
+	if err := d.QueryRow("SELECT n FROM t").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 执行错误包装为 *Error, 没有记录时返回 sql.ErrNoRows
+	srv.fail(errTestDeadlock)
+	err := d.QueryRow("SELECT n FROM t WHERE id = ?", 2).Scan(&n)
+	var e *Error
+	if !errors.As(err, &e) || !IsDeadlock(err) || e.Sql != "SELECT n FROM t WHERE id = ?" || LastErr() != err.Error() {
+		t.Fatalf("err = %v", err)
+	}
+	srv.fail(errTestDeadlock)
+	if err = d.QueryRow("SELECT n FROM t").Err(); !errors.As(err, &e) {
+		t.Fatalf("Err = %v", err)
+	}
+	srv.reset()
+	srv.result([]string{"n"})
+	if err = d.QueryRow("SELECT n FROM t").Scan(&n); err != sql.ErrNoRows {
+		t.Fatalf("err = %v", err)
+	}
+}
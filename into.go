@@ -0,0 +1,92 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// 查询实体集合, obj 为实体切片指针, 字段按 db 标签映射, 同 Database.QueryStructs
+func (q *SQ) Into(obj interface{}, args ...interface{}) error {
+	query, err := q.execSql()
+	if err != nil {
+		return err
+	}
+	ctx := q.context()
+	if q.cache {
+		return q.db.queryStructCached(ctx, q.cacheTTL, q.cacheGroup, obj, query, args, true)
+	}
+	return q.db.QueryStructsContext(ctx, obj, query, args...)
+}
+
+// 查询第一条记录到实体, obj 为实体指针, 没有记录时返回 sql.ErrNoRows
+func (q *SQ) First(obj interface{}, args ...interface{}) error {
+	q.Limit(1, 0)
+	query, err := q.execSql()
+	if err != nil {
+		return err
+	}
+	ctx := q.context()
+	if q.cache {
+		return q.db.queryStructCached(ctx, q.cacheTTL, q.cacheGroup, obj, query, args, false)
+	}
+	return q.db.QueryStructContext(ctx, obj, query, args...)
+}
+
+// 查询实体集合并按列建立索引, obj 为 map 指针, 如 *map[int64]User 或 *map[string]*User
+// keyCol 为实体中 db 标签等于该值的字段, 其类型需能转换为 map 的键类型; 键重复时保留后面的记录
+func (q *SQ) IntoMap(obj interface{}, keyCol string, args ...interface{}) error {
+	tp := reflect.TypeOf(obj)
+	if tp == nil || tp.Kind() != reflect.Ptr || tp.Elem().Kind() != reflect.Map {
+		return errors.New("is not map pointer")
+	}
+	mapType := tp.Elem()
+	elemType := mapType.Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return errors.New("is not struct map pointer")
+	}
+
+	field := -1
+	for i := 0; i < elemType.NumField(); i++ {
+		if elemType.Field(i).Tag.Get(dbTag) == keyCol {
+			field = i
+			break
+		}
+	}
+	if field < 0 {
+		return fmt.Errorf("key column %q not found in struct", keyCol)
+	}
+	keyType := mapType.Key()
+	// 非字符串字段作为字符串键时按 fmt.Sprint 格式化, 避免整数被转换为字符
+	format := keyType.Kind() == reflect.String && elemType.Field(field).Type.Kind() != reflect.String
+	if !format && !elemType.Field(field).Type.ConvertibleTo(keyType) {
+		return fmt.Errorf("key column %q cannot be converted to %s", keyCol, keyType)
+	}
+
+	list := reflect.New(reflect.SliceOf(elemType))
+	if err := q.Into(list.Interface(), args...); err != nil {
+		return err
+	}
+	list = list.Elem()
+	ret := reflect.MakeMapWithSize(mapType, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		item := list.Index(i)
+		var key reflect.Value
+		if format {
+			key = reflect.ValueOf(fmt.Sprint(item.Field(field).Interface())).Convert(keyType)
+		} else {
+			key = item.Field(field).Convert(keyType)
+		}
+		if isPtr {
+			ret.SetMapIndex(key, item.Addr())
+		} else {
+			ret.SetMapIndex(key, item)
+		}
+	}
+	reflect.ValueOf(obj).Elem().Set(ret)
+	return nil
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type intoUser struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+	Age  int    `db:"age"`
+}
+
+// 返回用户记录
+func userRows(srv *testServer) {
+	srv.result([]string{"id", "name", "age"},
+		[]driver.Value{[]byte("1"), []byte("tom"), []byte("20")},
+		[]driver.Value{[]byte("2"), []byte("amy"), []byte("20")},
+		[]driver.Value{[]byte("3"), []byte("bob"), []byte("31")},
+	)
+}
+
+func TestInto(t *testing.T) {
+	d, srv := newTestDB(t)
+	userRows(srv)
+
+	var users []intoUser
+	if err := Select("*").DB(d).Table("user").Where("age > ?").Order("id").Into(&users, 18); err != nil {
+		t.Fatal(err)
+	}
+	want := []intoUser{{1, "tom", 20}, {2, "amy", 20}, {3, "bob", 31}}
+	if !reflect.DeepEqual(users, want) {
+		t.Fatalf("users = %v", users)
+	}
+	if q := srv.queries()[0]; q != "SELECT * FROM user WHERE age > ? ORDER BY id" {
+		t.Fatalf("query = %q", q)
+	}
+	if !reflect.DeepEqual(srv.args(0), []driver.Value{int64(18)}) {
+		t.Fatalf("args = %v", srv.args(0))
+	}
+
+	if err := Select("*").DB(d).Table("user").Into(users); err == nil {
+		t.Fatal("non pointer should fail")
+	}
+}
+
+func TestFirst(t *testing.T) {
+	d, srv := newTestDB(t)
+	userRows(srv)
+
+	var u intoUser
+	if err := Select("*").DB(d).Table("user").Where("age = ?").Order("id").First(&u, 20); err != nil {
+		t.Fatal(err)
+	}
+	if u != (intoUser{1, "tom", 20}) {
+		t.Fatalf("user = %+v", u)
+	}
+	if q := srv.queries()[0]; !strings.HasPrefix(q, "SELECT * FROM user WHERE age = ? ORDER BY id LIMIT ") {
+		t.Fatalf("query = %q", q)
+	}
+
+	srv.reset()
+	srv.result([]string{"id", "name", "age"})
+	if err := Select("*").DB(d).Table("user").Where("id = 0").First(&u); err != sql.ErrNoRows {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestIntoMap(t *testing.T) {
+	d, srv := newTestDB(t)
+	userRows(srv)
+
+	var byID map[int64]intoUser
+	if err := Select("*").DB(d).Table("user").IntoMap(&byID, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(byID) != 3 || byID[2] != (intoUser{2, "amy", 20}) {
+		t.Fatalf("map = %v", byID)
+	}
+
+	// 键重复时保留后面的记录
+	var byAge map[int]*intoUser
+	if err := Select("*").DB(d).Table("user").IntoMap(&byAge, "age"); err != nil {
+		t.Fatal(err)
+	}
+	if len(byAge) != 2 || byAge[20].Name != "amy" || byAge[31].Name != "bob" {
+		t.Fatalf("map = %v", byAge)
+	}
+
+	// 整数字段作为字符串键时按十进制格式化
+	var byStr map[string]intoUser
+	if err := Select("*").DB(d).Table("user").IntoMap(&byStr, "id"); err != nil {
+		t.Fatal(err)
+	}
+	if byStr["3"].Name != "bob" {
+		t.Fatalf("map = %v", byStr)
+	}
+
+	errCases := []struct {
+		obj  interface{}
+		col  string
+		want string
+	}{
+		{byID, "id", "is not map pointer"},
+		{&map[int64]int{}, "id", "is not struct map pointer"},
+		{&byID, "email", `key column "email" not found in struct`},
+		{&map[bool]intoUser{}, "id", `key column "id" cannot be converted to bool`},
+	}
+	for _, c := range errCases {
+		if err := Select("*").DB(d).Table("user").IntoMap(c.obj, c.col); err == nil || err.Error() != c.want {
+			t.Errorf("IntoMap(%T, %q) = %v, want %q", c.obj, c.col, err, c.want)
+		}
+	}
+}
+
+func TestIntoCached(t *testing.T) {
+	d, srv := newTestDB(t)
+	userRows(srv)
+
+	for i := 0; i < 2; i++ {
+		var users []intoUser
+		if err := Select("*").DB(d).Table("user").Cache(time.Minute).Into(&users); err != nil || len(users) != 3 {
+			t.Fatalf("users = %v, %v", users, err)
+		}
+		var u intoUser
+		if err := Select("*").DB(d).Table("user").Cache(time.Minute).First(&u); err != nil || u.Name != "tom" {
+			t.Fatalf("user = %+v, %v", u, err)
+		}
+	}
+	if n := len(srv.queries()); n != 2 {
+		t.Fatalf("executed %d queries, want 2", n)
+	}
+}
+
+func TestIntoParseTime(t *testing.T) {
+	d, srv := newTestDB(t)
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv.result([]string{"id", "created", "at"}, []driver.Value{[]byte("1"), at, at})
+	type event struct {
+		ID      int       `db:"id"`
+		Created string    `db:"created"`
+		At      time.Time `db:"at"`
+	}
+
+	// 开启 parseTime 时时间列的值为 time.Time
+	var events []event
+	if err := Select("*").DB(d).Table("event").Into(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Created != "2024-01-02 03:04:05" || !events[0].At.Equal(at) {
+		t.Fatalf("events = %+v", events)
+	}
+}
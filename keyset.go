@@ -0,0 +1,365 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor") // 游标格式错误或签名不匹配
+
+	// 游标签名密钥, 多个进程之间需要使用相同的密钥; 为空时使用进程内随机生成的密钥
+	CursorSecret []byte
+
+	randomCursorSecret     []byte
+	randomCursorSecretOnce sync.Once
+)
+
+// 获取游标签名密钥
+func cursorSecret() []byte {
+	if len(CursorSecret) > 0 {
+		return CursorSecret
+	}
+	randomCursorSecretOnce.Do(func() {
+		randomCursorSecret = make([]byte, 32)
+		rand.Read(randomCursorSecret)
+	})
+	return randomCursorSecret
+}
+
+// 游标分页的排序键
+type seekKey struct {
+	column string // 列名, 可含表名前缀
+	field  string // 结果集中的字段名
+	desc   bool
+}
+
+// 游标内容
+type cursorData struct {
+	Prev   bool     `json:"p,omitempty"` // 是否为上一页的游标
+	Values []string `json:"v"`           // 排序键的值
+}
+
+// 游标分页结果
+type CursorPage struct {
+	Items   []map[string]string // 当前页的记录, 使用 SeekPageInto 时为nil
+	Next    string              // 下一页的游标, 没有下一页时为空
+	Prev    string              // 上一页的游标, 没有上一页时为空
+	HasNext bool                // 是否有下一页
+	HasPrev bool                // 是否有上一页
+}
+
+// 设置游标分页的排序键, 如 Keyset("created_at DESC", "id DESC")
+// 排序键的值不能为NULL, 且所有排序键组合后必须唯一(通常以主键结尾); 结果集中的字段名为去掉表名前缀的列名
+func (q *SQ) Keyset(keys ...string) *SQ {
+	q.keys = nil
+	for _, k := range keys {
+		parts := strings.Fields(k)
+		if len(parts) == 0 {
+			continue
+		}
+		key := seekKey{column: parts[0], field: parts[0]}
+		if i := strings.LastIndexByte(key.field, '.'); i >= 0 {
+			key.field = key.field[i+1:]
+		}
+		key.field = strings.Trim(key.field, WrapSymbol)
+		if len(parts) > 1 && strings.EqualFold(parts[1], "DESC") {
+			key.desc = true
+		}
+		q.keys = append(q.keys, key)
+	}
+	return q
+}
+
+// 排序键的签名内容, 游标只能用于相同排序键的查询
+func (q *SQ) keysSpec() string {
+	s := strings.Builder{}
+	for _, k := range q.keys {
+		s.WriteString(k.column)
+		if k.desc {
+			s.WriteString(" DESC")
+		}
+		s.WriteString(",")
+	}
+	return s.String()
+}
+
+// 生成游标
+func (q *SQ) encodeCursor(c cursorData) string {
+	payload, _ := json.Marshal(c)
+	mac := hmac.New(sha256.New, cursorSecret())
+	mac.Write([]byte(q.keysSpec()))
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// 解析并校验游标
+func (q *SQ) decodeCursor(cursor string) (cursorData, error) {
+	var c cursorData
+	i := strings.IndexByte(cursor, '.')
+	if i < 0 {
+		return c, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(cursor[:i])
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(cursor[i+1:])
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	mac := hmac.New(sha256.New, cursorSecret())
+	mac.Write([]byte(q.keysSpec()))
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)[:16]) {
+		return c, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(payload, &c); err != nil || len(c.Values) != len(q.keys) {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// 构造定位条件, 排序方向一致且数据库支持时使用行比较 (a, b) > (?, ?), 否则展开为 a > ? OR (a = ? AND b > ?)
+func (q *SQ) seekPredicate(values []string, prev bool) (string, []interface{}) {
+	same := true
+	for _, k := range q.keys[1:] {
+		if k.desc != q.keys[0].desc {
+			same = false
+		}
+	}
+	op := func(desc bool) string {
+		if desc != prev {
+			return "<"
+		}
+		return ">"
+	}
+
+	s := strings.Builder{}
+	var args []interface{}
+	if same && len(q.keys) > 1 && (q.dbType() == "mysql" || q.dbType() == "postgres") {
+		s.WriteString("(")
+		for i, k := range q.keys {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(k.column)
+			args = append(args, values[i])
+		}
+		s.WriteString(") ")
+		s.WriteString(op(q.keys[0].desc))
+		s.WriteString(" (")
+		s.WriteString(strings.TrimSuffix(strings.Repeat("?, ", len(q.keys)), ", "))
+		s.WriteString(")")
+		return s.String(), args
+	}
+
+	for i, k := range q.keys {
+		if i > 0 {
+			s.WriteString(" OR ")
+		}
+		s.WriteString("(")
+		for j := 0; j < i; j++ {
+			s.WriteString(q.keys[j].column)
+			s.WriteString(" = ? AND ")
+			args = append(args, values[j])
+		}
+		s.WriteString(k.column)
+		s.WriteString(" ")
+		s.WriteString(op(k.desc))
+		s.WriteString(" ?)")
+		args = append(args, values[i])
+	}
+	return s.String(), args
+}
+
+// 构造游标分页的查询语句, 多查询一条记录用于判断是否还有更多记录
+func (q *SQ) seekSql(cursor string, size int, args []interface{}) (string, []interface{}, cursorData, error) {
+	var c cursorData
+	if len(q.keys) == 0 {
+		return "", nil, c, errors.New("keyset is not set, use SQ.Keyset")
+	}
+	if size <= 0 {
+		return "", nil, c, errors.New("page size must be greater than 0")
+	}
+	if cursor != "" {
+		var err error
+		if c, err = q.decodeCursor(cursor); err != nil {
+			return "", nil, c, err
+		}
+	}
+
+	s := *q
+	allArgs := append([]interface{}(nil), args...)
+	if cursor != "" {
+		pred, predArgs := q.seekPredicate(c.Values, c.Prev)
+		if s.where != "" {
+			s.where = "(" + s.where + ") AND (" + pred + ")"
+		} else {
+			s.where = pred
+		}
+		allArgs = append(allArgs, predArgs...)
+	}
+	order := make([]string, len(q.keys))
+	for i, k := range q.keys {
+		if k.desc != c.Prev {
+			order[i] = k.column + " DESC"
+		} else {
+			order[i] = k.column + " ASC"
+		}
+	}
+	s.order = strings.Join(order, ", ")
+	s.Limit(size + 1)
+	query, err := s.execSql()
+	return query, allArgs, c, err
+}
+
+// 根据查询到的记录数设置游标, rows 为查询到的记录数(最多 size+1), keyValues 获取第i条记录的排序键值; 返回当前页的记录数
+// 上一页按相反顺序查询, 第0条为离游标最近的记录
+func (q *SQ) seekResult(p *CursorPage, cursor string, c cursorData, size, rows int, keyValues func(i int) []string) int {
+	more := rows > size
+	if more {
+		rows = size
+	}
+	if rows == 0 {
+		return 0
+	}
+	first, last := 0, rows-1
+	hasPrev, hasNext := cursor != "", more
+	if c.Prev {
+		first, last = rows-1, 0
+		hasPrev, hasNext = more, true
+	}
+	if hasPrev {
+		p.Prev = q.encodeCursor(cursorData{Prev: true, Values: keyValues(first)})
+	}
+	if hasNext {
+		p.Next = q.encodeCursor(cursorData{Values: keyValues(last)})
+	}
+	p.HasPrev, p.HasNext = hasPrev, hasNext
+	return rows
+}
+
+// 游标分页查询, cursor 为空时查询第一页, 否则为上一次结果中的 Next 或 Prev; args 为语句的参数
+// 使用 Keyset 设置的排序键代替 ORDER BY; 游标经过签名, 被篡改或用于其他排序键时返回 ErrInvalidCursor
+func (q *SQ) SeekPage(cursor string, size int, args ...interface{}) (*CursorPage, error) {
+	query, allArgs, c, err := q.seekSql(cursor, size, args)
+	if err != nil {
+		return nil, err
+	}
+	ctx := q.context()
+	var rows []map[string]string
+	if q.cache {
+		rows, err = q.db.SelectCachedContext(ctx, q.cacheTTL, q.cacheGroup, query, allArgs...)
+	} else {
+		rows, err = q.db.SelectContext(ctx, query, allArgs...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 排序键必须在查询的字段中, 否则游标中的值为空
+	if len(rows) > 0 {
+		for _, k := range q.keys {
+			if _, ok := rows[0][k.field]; !ok {
+				return nil, fmt.Errorf("keyset field %q not found in result", k.field)
+			}
+		}
+	}
+
+	p := &CursorPage{}
+	n := q.seekResult(p, cursor, c, size, len(rows), func(i int) []string {
+		values := make([]string, len(q.keys))
+		for j, k := range q.keys {
+			values[j] = rows[i][k.field]
+		}
+		return values
+	})
+	rows = rows[:n]
+	if c.Prev {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if rows == nil {
+		rows = []map[string]string{}
+	}
+	p.Items = rows
+	return p, nil
+}
+
+// 游标分页查询并将当前页的记录填充到实体切片, obj 为实体切片指针, 参见 SeekPage
+// 排序键的值从 db 标签与字段名相同的实体字段中读取
+func (q *SQ) SeekPageInto(obj interface{}, cursor string, size int, args ...interface{}) (*CursorPage, error) {
+	tp := reflect.TypeOf(obj)
+	if tp == nil || tp.Kind() != reflect.Ptr || tp.Elem().Kind() != reflect.Slice || tp.Elem().Elem().Kind() != reflect.Struct {
+		return nil, errors.New("is not struct slice pointer")
+	}
+	fields := make([]int, len(q.keys))
+	for i, k := range q.keys {
+		fields[i] = -1
+		for j := 0; j < tp.Elem().Elem().NumField(); j++ {
+			if tp.Elem().Elem().Field(j).Tag.Get(dbTag) == k.field {
+				fields[i] = j
+			}
+		}
+		if fields[i] < 0 {
+			return nil, fmt.Errorf("keyset field %q not found in struct", k.field)
+		}
+	}
+
+	query, allArgs, c, err := q.seekSql(cursor, size, args)
+	if err != nil {
+		return nil, err
+	}
+	ctx := q.context()
+	if q.cache {
+		err = q.db.queryStructCached(ctx, q.cacheTTL, q.cacheGroup, obj, query, allArgs, true)
+	} else {
+		err = q.db.QueryStructsContext(ctx, obj, query, allArgs...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := reflect.ValueOf(obj).Elem()
+	p := &CursorPage{}
+	n := q.seekResult(p, cursor, c, size, list.Len(), func(i int) []string {
+		values := make([]string, len(q.keys))
+		for j, f := range fields {
+			values[j] = cursorValue(list.Index(i).Field(f).Interface())
+		}
+		return values
+	})
+	list = list.Slice(0, n)
+	if c.Prev {
+		swap := reflect.Swapper(list.Interface())
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+	reflect.ValueOf(obj).Elem().Set(list)
+	return p, nil
+}
+
+// 将实体字段的值转换为游标中的字符串
+func cursorValue(v interface{}) string {
+	switch val := v.(type) {
+	case time.Time:
+		return val.Format("2006-01-02 15:04:05.999999999")
+	case []byte:
+		return string(val)
+	case fmt.Stringer:
+		return val.String()
+	}
+	return fmt.Sprint(v)
+}
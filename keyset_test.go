@@ -0,0 +1,220 @@
+package db
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 模拟按 id 游标分页的查询, 表中 id 为 1 到 n
+func seekServer(srv *testServer, n int) {
+	srv.handle = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		limit, _ := strconv.Atoi(query[strings.LastIndex(query, " ")+1:])
+		desc := strings.Contains(query, "ORDER BY id DESC")
+		var rows [][]driver.Value
+		for i := 1; i <= n; i++ {
+			id := i
+			if desc {
+				id = n + 1 - i
+			}
+			if len(args) > 0 {
+				v, _ := strconv.Atoi(args[len(args)-1].(string))
+				if strings.Contains(query, "id > ?") && id <= v || strings.Contains(query, "id < ?") && id >= v {
+					continue
+				}
+			}
+			if len(rows) < limit {
+				rows = append(rows, []driver.Value{[]byte(strconv.Itoa(id)), []byte("u" + strconv.Itoa(id))})
+			}
+		}
+		return []string{"id", "name"}, rows, nil
+	}
+}
+
+// 当前页记录的 id
+func pageIDs(p *CursorPage) string {
+	var ids []string
+	for _, row := range p.Items {
+		ids = append(ids, row["id"])
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSeekSql(t *testing.T) {
+	d, _ := newTestDB(t)
+	q := Select("*").DB(d).Table("post").Where("uid = ?").Keyset("p.created_at DESC", "`id` DESC")
+	if q.keys[0].field != "created_at" || q.keys[1].field != "id" {
+		t.Fatalf("keys = %+v", q.keys)
+	}
+
+	s, args, _, err := q.seekSql("", 10, []interface{}{7})
+	if err != nil || s != "SELECT * FROM post WHERE uid = ? ORDER BY p.created_at DESC, `id` DESC LIMIT 11" || len(args) != 1 {
+		t.Fatalf("seekSql = %q, %v, %v", s, args, err)
+	}
+
+	// 排序方向一致时使用行比较
+	cursor := q.encodeCursor(cursorData{Values: []string{"2024-01-02", "9"}})
+	s, args, _, _ = q.seekSql(cursor, 10, []interface{}{7})
+	if s != "SELECT * FROM post WHERE (uid = ?) AND ((p.created_at, `id`) < (?, ?)) ORDER BY p.created_at DESC, `id` DESC LIMIT 11" {
+		t.Fatalf("seekSql = %q", s)
+	}
+	if !reflect.DeepEqual(args, []interface{}{7, "2024-01-02", "9"}) {
+		t.Fatalf("args = %v", args)
+	}
+
+	// 上一页按相反顺序查询
+	cursor = q.encodeCursor(cursorData{Prev: true, Values: []string{"2024-01-02", "9"}})
+	if s, _, _, _ = q.seekSql(cursor, 10, nil); !strings.Contains(s, "(p.created_at, `id`) > (?, ?)) ORDER BY p.created_at ASC, `id` ASC") {
+		t.Fatalf("seekSql = %q", s)
+	}
+
+	// 排序方向不一致或数据库不支持行比较时展开条件
+	q = Select("*").DB(d).Table("post").Keyset("score DESC", "id")
+	pred, args := q.seekPredicate([]string{"5", "9"}, false)
+	if pred != "(score < ?) OR (score = ? AND id > ?)" || !reflect.DeepEqual(args, []interface{}{"5", "5", "9"}) {
+		t.Fatalf("predicate = %q, %v", pred, args)
+	}
+	d.Type = "oracle"
+	q = Select("*").DB(d).Table("post").Keyset("a", "b")
+	if pred, _ = q.seekPredicate([]string{"1", "2"}, false); pred != "(a > ?) OR (a = ? AND b > ?)" {
+		t.Fatalf("predicate = %q", pred)
+	}
+
+	if _, _, _, err = Select("*").DB(d).Table("post").seekSql("", 10, nil); err == nil {
+		t.Fatal("missing keyset should fail")
+	}
+	if _, _, _, err = q.seekSql("", 0, nil); err == nil {
+		t.Fatal("zero page size should fail")
+	}
+}
+
+func TestCursor(t *testing.T) {
+	q := Select("*").Keyset("id")
+	cursor := q.encodeCursor(cursorData{Values: []string{"9"}})
+	if c, err := q.decodeCursor(cursor); err != nil || c.Prev || !reflect.DeepEqual(c.Values, []string{"9"}) {
+		t.Fatalf("decodeCursor = %+v, %v", c, err)
+	}
+
+	bad := []string{
+		"",
+		"abc",
+		"!!." + cursor[strings.IndexByte(cursor, '.')+1:],
+		cursor[:len(cursor)-2] + "AA",
+		Select("*").Keyset("id DESC").encodeCursor(cursorData{Values: []string{"9"}}),
+		q.encodeCursor(cursorData{Values: []string{"9", "1"}}),
+	}
+	for _, c := range bad {
+		if _, err := q.decodeCursor(c); err != ErrInvalidCursor {
+			t.Errorf("decodeCursor(%q) = %v", c, err)
+		}
+	}
+
+	old := CursorSecret
+	CursorSecret = []byte("other secret")
+	defer func() { CursorSecret = old }()
+	if _, err := q.decodeCursor(cursor); err != ErrInvalidCursor {
+		t.Fatal("cursor signed with another secret should be rejected")
+	}
+}
+
+func TestSeekPage(t *testing.T) {
+	d, srv := newTestDB(t)
+	seekServer(srv, 5)
+	page := func(cursor string) *CursorPage {
+		p, err := Select("id, name").DB(d).Table("user").Keyset("id").SeekPage(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	p1 := page("")
+	if pageIDs(p1) != "1,2" || !p1.HasNext || p1.HasPrev || p1.Prev != "" {
+		t.Fatalf("page 1 = %+v", p1)
+	}
+	p2 := page(p1.Next)
+	if pageIDs(p2) != "3,4" || !p2.HasNext || !p2.HasPrev {
+		t.Fatalf("page 2 = %+v", p2)
+	}
+	p3 := page(p2.Next)
+	if pageIDs(p3) != "5" || p3.HasNext || p3.Next != "" || !p3.HasPrev {
+		t.Fatalf("page 3 = %+v", p3)
+	}
+
+	// 向前翻页时记录保持正序
+	back := page(p3.Prev)
+	if pageIDs(back) != "3,4" || !back.HasNext || !back.HasPrev {
+		t.Fatalf("back to page 2 = %+v", back)
+	}
+	first := page(back.Prev)
+	if pageIDs(first) != "1,2" || first.HasPrev || !first.HasNext {
+		t.Fatalf("back to page 1 = %+v", first)
+	}
+
+	again := page(back.Next)
+	if pageIDs(again) != "5" || again.HasNext {
+		t.Fatalf("forward again = %+v", again)
+	}
+	if _, err := Select("id").DB(d).Table("user").Keyset("id").SeekPage("bad.cursor", 2); err != ErrInvalidCursor {
+		t.Fatalf("err = %v", err)
+	}
+	// 排序键不在查询的字段中
+	if _, err := Select("name").DB(d).Table("user").Keyset("id", "created_at").SeekPage("", 2); err == nil || err.Error() != `keyset field "created_at" not found in result` {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestSeekPageEmpty(t *testing.T) {
+	d, srv := newTestDB(t)
+	seekServer(srv, 0)
+	p, err := Select("id, name").DB(d).Table("user").Keyset("id").SeekPage("", 2)
+	if err != nil || p.Items == nil || len(p.Items) != 0 || p.HasNext || p.HasPrev {
+		t.Fatalf("page = %+v, %v", p, err)
+	}
+}
+
+func TestSeekPageInto(t *testing.T) {
+	d, srv := newTestDB(t)
+	seekServer(srv, 3)
+	type user struct {
+		ID   int    `db:"id"`
+		Name string `db:"name"`
+	}
+
+	var users []user
+	q := Select("id, name").DB(d).Table("user").Keyset("id DESC")
+	p, err := q.SeekPageInto(&users, "", 2)
+	if err != nil || !reflect.DeepEqual(users, []user{{3, "u3"}, {2, "u2"}}) || !p.HasNext || p.Items != nil {
+		t.Fatalf("page = %+v, %v, %v", p, users, err)
+	}
+	p, err = Select("id, name").DB(d).Table("user").Keyset("id DESC").SeekPageInto(&users, p.Next, 2)
+	if err != nil || !reflect.DeepEqual(users, []user{{1, "u1"}}) || p.HasNext || !p.HasPrev {
+		t.Fatalf("page = %+v, %v, %v", p, users, err)
+	}
+
+	if _, err = q.SeekPageInto(users, "", 2); err == nil {
+		t.Fatal("non pointer should fail")
+	}
+	var other []struct {
+		Name string `db:"name"`
+	}
+	if _, err = q.SeekPageInto(&other, "", 2); err == nil || !strings.Contains(err.Error(), `keyset field "id" not found`) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestCursorValue(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	cases := map[interface{}]string{1: "1", "a": "a", at: "2024-01-02 03:04:05.6", ErrorClass(0): ErrorClass(0).String()}
+	for v, want := range cases {
+		if got := cursorValue(v); got != want {
+			t.Errorf("cursorValue(%v) = %q, want %q", v, got, want)
+		}
+	}
+	if got := cursorValue([]byte("b")); got != "b" {
+		t.Fatalf("cursorValue([]byte) = %q", got)
+	}
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 日志级别, 取值与 log/slog 保持一致
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// 日志接口
+// kv 为交替出现的键值对, 与 log/slog 的参数形式一致
+type Logger interface {
+	Enabled(level Level) bool
+	Log(level Level, msg string, kv ...interface{})
+}
+
+// 文本日志, 输出 log/slog TextHandler 风格的 key=value 格式
+type TextLogger struct {
+	Level Level // 最低输出级别
+	w     io.Writer
+	lock  sync.Mutex
+}
+
+// 获取一个文本日志对象
+func NewTextLogger(w io.Writer, level Level) *TextLogger {
+	return &TextLogger{Level: level, w: w}
+}
+
+// 默认日志对象, 用于 SQ.Debug 及内部警告输出
+var DefaultLogger Logger = NewTextLogger(os.Stderr, LevelInfo)
+
+func (l *TextLogger) Enabled(level Level) bool {
+	return level >= l.Level
+}
+
+func (l *TextLogger) Log(level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	s := strings.Builder{}
+	s.WriteString("time=")
+	s.WriteString(time.Now().Format("2006-01-02T15:04:05.000Z07:00"))
+	s.WriteString(" level=")
+	s.WriteString(level.String())
+	s.WriteString(" msg=")
+	s.WriteString(logQuote(msg))
+	for i := 0; i < len(kv); i += 2 {
+		s.WriteString(" ")
+		s.WriteString(fmt.Sprint(kv[i]))
+		s.WriteString("=")
+		if i+1 < len(kv) {
+			s.WriteString(logQuote(logValue(kv[i+1])))
+		} else {
+			s.WriteString("!MISSING")
+		}
+	}
+	s.WriteString("\n")
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	io.WriteString(l.w, s.String())
+}
+
+// 格式化日志值
+func logValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case error:
+		return val.Error()
+	case time.Duration:
+		return val.String()
+	case []byte:
+		return string(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// 需要时为日志值添加引号
+func logQuote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+type debugKey struct{}
+
+// 获取数据库使用的日志对象
+func (this *Database) logger() Logger {
+	if this.Logger != nil {
+		return this.Logger
+	}
+	return DefaultLogger
+}
+
+// 是否需要记录语句日志
+func (this *Database) logging(ctx context.Context) bool {
+	if this.Logger != nil || this.SlowThreshold > 0 {
+		return true
+	}
+	v, _ := ctx.Value(debugKey{}).(bool)
+	return v
+}
+
+// 记录语句日志
+// 使用 SQ.Debug 的语句以 INFO 级别输出; 执行出错的语句以 ERROR 级别输出;
+// 耗时超过 SlowThreshold 的语句作为慢查询以 WARN 级别输出; 其他语句以 DEBUG 级别输出
+// 只有设置了 Logger 时才会输出 ERROR 与 DEBUG 级别的语句日志
+func (this *Database) logStatement(stmt *Statement) {
+	lg := this.logger()
+	debug, _ := stmt.Ctx.Value(debugKey{}).(bool)
+
+	var (
+		level Level
+		msg   string
+	)
+	switch {
+	case debug:
+		level, msg = LevelInfo, "sql debug"
+	case stmt.Err != nil && this.Logger != nil:
+		level, msg = LevelError, "sql error"
+	case this.SlowThreshold > 0 && stmt.Duration >= this.SlowThreshold:
+		level, msg = LevelWarn, "slow query"
+	case this.Logger != nil:
+		level, msg = LevelDebug, "sql"
+	default:
+		return
+	}
+	if !lg.Enabled(level) {
+		return
+	}
+
+	if stmt.Caller == "" {
+		stmt.Caller = callerLocation()
+	}
+	kv := []interface{}{
+		"kind", stmt.Kind.String(),
+		"sql", stmt.Query,
+	}
+	if len(stmt.Args) > 0 {
+		if debug || this.LogRawArgs {
+			kv = append(kv, "args", fmt.Sprint(stmt.Args))
+		} else {
+			kv = append(kv, "args", strings.Join(redactArgs(stmt.Args), ","))
+		}
+	}
+	kv = append(kv, "duration", stmt.Duration)
+	if stmt.Rows >= 0 {
+		kv = append(kv, "rows", stmt.Rows)
+	}
+	if stmt.RowsAffected >= 0 {
+		kv = append(kv, "affected", stmt.RowsAffected)
+	}
+	if stmt.Queued {
+		kv = append(kv, "queued", true)
+	}
+	if stmt.Err != nil {
+		kv = append(kv, "error", stmt.Err)
+	}
+	kv = append(kv, "caller", stmt.Caller)
+	lg.Log(level, msg, kv...)
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录日志的测试日志对象
+type testLogger struct {
+	lock    sync.Mutex
+	level   Level
+	entries []testLogEntry
+}
+
+type testLogEntry struct {
+	Level Level
+	Msg   string
+	KV    map[string]interface{}
+}
+
+func (l *testLogger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *testLogger) Log(level Level, msg string, kv ...interface{}) {
+	e := testLogEntry{Level: level, Msg: msg, KV: make(map[string]interface{})}
+	for i := 0; i+1 < len(kv); i += 2 {
+		e.KV[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = append(l.entries, e)
+}
+
+func (l *testLogger) logs() []testLogEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]testLogEntry(nil), l.entries...)
+}
+
+// 临时替换默认日志对象
+func useDefaultLogger(t *testing.T, l Logger) {
+	old := DefaultLogger
+	DefaultLogger = l
+	t.Cleanup(func() { DefaultLogger = old })
+}
+
+func TestTextLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewTextLogger(buf, LevelInfo)
+	l.Log(LevelDebug, "hidden")
+	l.Log(LevelWarn, "slow query", "sql", "SELECT 1", "duration", 1500*time.Millisecond, "error", errors.New("a=b"), "odd")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatal("debug message should be filtered")
+	}
+	if !strings.HasPrefix(out, "time=") || !strings.HasSuffix(out, "\n") {
+		t.Fatalf("output = %q", out)
+	}
+	for _, want := range []string{` level=WARN msg="slow query" `, ` sql="SELECT 1" `, ` duration=1.5s `, ` error="a=b" `, ` odd=!MISSING`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestLevelString(t *testing.T) {
+	levels := map[Level]string{LevelDebug: "DEBUG", LevelInfo: "INFO", LevelWarn: "WARN", LevelError: "ERROR", 2: "INFO", 12: "ERROR"}
+	for l, want := range levels {
+		if l.String() != want {
+			t.Errorf("Level(%d) = %s, want %s", l, l.String(), want)
+		}
+	}
+}
+
+func TestStatementLog(t *testing.T) {
+	d, srv := newTestDB(t)
+	lg := &testLogger{level: LevelDebug}
+	d.Logger = lg
+	srv.affected = 2
+
+	d.Exec("UPDATE user SET name = ? WHERE id = ?", "secret", 1)
+	srv.fail(errors.New("Error 1064: syntax error"))
+	d.Exec("UPDAT user")
+
+	logs := lg.logs()
+	if len(logs) != 2 {
+		t.Fatalf("logged %d entries, want 2", len(logs))
+	}
+	e := logs[0]
+	if e.Level != LevelDebug || e.Msg != "sql" || e.KV["kind"] != "exec" || e.KV["sql"] != "UPDATE user SET name = ? WHERE id = ?" {
+		t.Fatalf("entry = %+v", e)
+	}
+	if e.KV["args"] != "string(6),int" {
+		t.Fatalf("args should be redacted: %v", e.KV["args"])
+	}
+	if e.KV["affected"] != int64(2) || !strings.Contains(e.KV["caller"].(string), "logger_test.go:") {
+		t.Fatalf("entry = %+v", e)
+	}
+	if _, ok := e.KV["rows"]; ok {
+		t.Fatal("exec should not log rows")
+	}
+
+	e = logs[1]
+	if e.Level != LevelError || e.Msg != "sql error" || !IsSyntax(e.KV["error"].(error)) {
+		t.Fatalf("entry = %+v", e)
+	}
+
+	d.LogRawArgs = true
+	d.Exec("UPDATE user SET name = ?", "secret")
+	if e = lg.logs()[2]; e.KV["args"] != "[secret]" {
+		t.Fatalf("raw args = %v", e.KV["args"])
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	d, _ := newTestDB(t)
+	lg := &testLogger{level: LevelInfo}
+	useDefaultLogger(t, lg)
+
+	d.Exec("UPDATE t SET a = 1")
+	if len(lg.logs()) != 0 {
+		t.Fatal("statements should not be logged without Logger or SlowThreshold")
+	}
+
+	d.SlowThreshold = time.Nanosecond
+	d.Exec("UPDATE t SET a = 1")
+	logs := lg.logs()
+	if len(logs) != 1 || logs[0].Level != LevelWarn || logs[0].Msg != "slow query" {
+		t.Fatalf("logs = %+v", logs)
+	}
+
+	d.SlowThreshold = time.Hour
+	d.Exec("UPDATE t SET a = 1")
+	if len(lg.logs()) != 1 {
+		t.Fatal("fast statements should not be logged as slow")
+	}
+}
+
+func TestDebugLog(t *testing.T) {
+	d, _ := newTestDB(t)
+	lg := &testLogger{level: LevelInfo}
+	useDefaultLogger(t, lg)
+
+	Update().DB(d).Table("user").Value(Values{"name": "secret"}).Where("id = 1").Debug().Exec()
+	logs := lg.logs()
+	if len(logs) != 1 || logs[0].Level != LevelInfo || logs[0].Msg != "sql debug" {
+		t.Fatalf("logs = %+v", logs)
+	}
+	if logs[0].KV["args"] != "[secret]" {
+		t.Fatalf("debug args should not be redacted: %v", logs[0].KV["args"])
+	}
+}
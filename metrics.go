@@ -0,0 +1,384 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的耗时直方图上限(秒)
+var DefaultMetricsBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SQ 语句类型的名称, 用作指标的 type 标签
+var sqlTypeNames = map[int]string{
+	TypeInsert:       "insert",
+	TypeDelete:       "delete",
+	TypeUpdate:       "update",
+	TypeSelect:       "select",
+	TypeInsertUpdate: "insert_update",
+}
+
+// 获取语句类型, 事务语句为 begin/commit/rollback, 无法识别时为 other
+func statementType(kind StmtKind, query string) string {
+	switch kind {
+	case KindBegin, KindCommit, KindRollback:
+		return kind.String()
+	}
+	s := strings.TrimLeft(query, " \t\r\n(")
+	i := strings.IndexAny(s, " \t\r\n(")
+	if i < 0 {
+		i = len(s)
+	}
+	t := 0
+	switch strings.ToUpper(s[:i]) {
+	case "INSERT", "REPLACE":
+		t = TypeInsert
+		if strings.Contains(strings.ToUpper(s), "ON DUPLICATE KEY UPDATE") {
+			t = TypeInsertUpdate
+		}
+	case "UPDATE":
+		t = TypeUpdate
+	case "DELETE":
+		t = TypeDelete
+	case "SELECT", "WITH":
+		t = TypeSelect
+	}
+	if name, ok := sqlTypeNames[t]; ok {
+		return name
+	}
+	return "other"
+}
+
+// 单个语句类型的指标
+type queryMetrics struct {
+	count, errors, rows, affected int64
+	duration                      time.Duration
+	counts                        []int64 // 各直方图区间的次数, 最后一个为超出所有上限的次数
+}
+
+// 数据库指标, 由 EnableMetrics 创建
+// 记录经过本对象执行的语句, 包括异步队列及事务中的语句
+type Metrics struct {
+	Name        string    // 名称, 用作指标的 db 标签
+	buckets     []float64 // 耗时直方图上限(秒), 升序
+	db          *Database
+	lock        sync.Mutex
+	queries     map[string]*queryMetrics
+	errors      map[string]int64
+	cacheHits   int64
+	cacheMisses int64
+}
+
+// 语句类型的指标快照
+type QueryMetrics struct {
+	Count    int64         // 执行次数
+	Errors   int64         // 出错次数
+	Rows     int64         // 返回的行数
+	Affected int64         // 受影响的行数
+	Duration time.Duration // 总耗时
+	Buckets  []float64     // 耗时直方图上限(秒)
+	Counts   []int64       // 耗时小于等于对应上限的累计次数
+}
+
+// 指标快照
+type MetricsSnapshot struct {
+	Name          string
+	Time          time.Time
+	Queries       map[string]QueryMetrics  // 按语句类型(insert/select/...)统计
+	Errors        map[string]int64         // 按错误分类统计, 被钩子否决的语句为 vetoed
+	Queue         QueueStats               // 异步队列统计, 未使用队列时为零值
+	CacheHits     int64                    // 查询缓存命中次数
+	CacheMisses   int64                    // 查询缓存未命中次数
+	CacheHitRatio float64                  // 查询缓存命中率, 没有缓存查询时为0
+	Pool          sql.DBStats              // 连接池统计
+	ReplicaLag    map[string]time.Duration // 从库复制延迟, 键为从库开启指标时的名称或从库序号, 未知时为-1; 仅集群主库有值
+}
+
+// 开启指标收集并返回指标对象, 已开启时返回已有的对象
+// buckets 为耗时直方图上限(秒), 省略时使用 DefaultMetricsBuckets
+func (this *Database) EnableMetrics(name string, buckets ...float64) *Metrics {
+	this.hookLock.Lock()
+	defer this.hookLock.Unlock()
+	if this.metrics != nil {
+		return this.metrics
+	}
+	if len(buckets) == 0 {
+		buckets = DefaultMetricsBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	this.metrics = &Metrics{
+		Name:    name,
+		buckets: buckets,
+		db:      this,
+		queries: make(map[string]*queryMetrics),
+		errors:  make(map[string]int64),
+	}
+	return this.metrics
+}
+
+// 获取指标对象, 未开启时返回nil
+func (this *Database) Metrics() *Metrics {
+	this.hookLock.RLock()
+	defer this.hookLock.RUnlock()
+	return this.metrics
+}
+
+// 记录语句的执行结果
+func (m *Metrics) observe(stmt *Statement) {
+	t := statementType(stmt.Kind, stmt.Query)
+	sec := stmt.Duration.Seconds()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	q, ok := m.queries[t]
+	if !ok {
+		q = &queryMetrics{counts: make([]int64, len(m.buckets)+1)}
+		m.queries[t] = q
+	}
+	q.count++
+	q.duration += stmt.Duration
+	q.counts[sort.SearchFloat64s(m.buckets, sec)]++
+	if stmt.Rows > 0 {
+		q.rows += stmt.Rows
+	}
+	if stmt.RowsAffected > 0 {
+		q.affected += stmt.RowsAffected
+	}
+	if stmt.Err != nil {
+		q.errors++
+		var veto *VetoError
+		if errors.As(stmt.Err, &veto) {
+			m.errors["vetoed"]++
+		} else {
+			m.errors[ErrorClassOf(stmt.Err).String()]++
+		}
+	}
+}
+
+// 记录查询缓存是否命中
+func (m *Metrics) observeCache(hit bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// 获取指标快照
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	s := MetricsSnapshot{
+		Name:    m.Name,
+		Time:    time.Now(),
+		Queries: make(map[string]QueryMetrics),
+		Errors:  make(map[string]int64),
+		Pool:    m.db.DB.Stats(),
+	}
+	if queue := m.db.currentQueue(); queue != nil {
+		s.Queue = queue.Stats()
+	}
+	if c := m.db.cluster; c != nil {
+		s.ReplicaLag = make(map[string]time.Duration)
+		for i, r := range c.Replicas() {
+			s.ReplicaLag[replicaName(i, r.DB)] = r.Lag
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for t, q := range m.queries {
+		counts := make([]int64, len(m.buckets))
+		var n int64
+		for i := range m.buckets {
+			n += q.counts[i]
+			counts[i] = n
+		}
+		s.Queries[t] = QueryMetrics{
+			Count:    q.count,
+			Errors:   q.errors,
+			Rows:     q.rows,
+			Affected: q.affected,
+			Duration: q.duration,
+			Buckets:  m.buckets,
+			Counts:   counts,
+		}
+	}
+	for class, n := range m.errors {
+		s.Errors[class] = n
+	}
+	s.CacheHits, s.CacheMisses = m.cacheHits, m.cacheMisses
+	if total := s.CacheHits + s.CacheMisses; total > 0 {
+		s.CacheHitRatio = float64(s.CacheHits) / float64(total)
+	}
+	return s
+}
+
+// 从库在指标中的名称, 从库开启了指标时使用指标名称, 否则使用序号
+func replicaName(i int, db *Database) string {
+	if m := db.Metrics(); m != nil && m.Name != "" {
+		return m.Name
+	}
+	return strconv.Itoa(i)
+}
+
+// 指标接口
+type metricsHandler struct {
+	metrics []*Metrics
+}
+
+// 创建 Prometheus 文本格式的指标接口
+func NewMetricsHandler(metrics ...*Metrics) http.Handler {
+	return &metricsHandler{metrics: metrics}
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshots := make([]MetricsSnapshot, len(h.metrics))
+	for i, m := range h.metrics {
+		snapshots[i] = m.Snapshot()
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w, snapshots...)
+}
+
+// 转义标签值
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 指标输出
+type metricsWriter struct {
+	w io.Writer
+}
+
+// 输出指标的说明及类型
+func (p metricsWriter) header(name, typ, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// 输出一个指标值, labels 为 名称, 值, 名称, 值...
+func (p metricsWriter) value(name string, v float64, labels ...string) {
+	s := strings.Builder{}
+	s.WriteString(name)
+	if len(labels) > 0 {
+		s.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				s.WriteString(",")
+			}
+			s.WriteString(labels[i])
+			s.WriteString(`="`)
+			s.WriteString(labelEscaper.Replace(labels[i+1]))
+			s.WriteString(`"`)
+		}
+		s.WriteString("}")
+	}
+	s.WriteString(" ")
+	s.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	s.WriteString("\n")
+	io.WriteString(p.w, s.String())
+}
+
+// 按键名排序
+func sortedKeys(m map[string]QueryMetrics) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 以 Prometheus 文本格式输出指标快照
+func WriteMetrics(w io.Writer, snapshots ...MetricsSnapshot) {
+	p := metricsWriter{w: w}
+
+	queryCounter := func(name, help string, get func(q QueryMetrics) int64) {
+		p.header(name, "counter", help)
+		for _, s := range snapshots {
+			for _, t := range sortedKeys(s.Queries) {
+				p.value(name, float64(get(s.Queries[t])), "db", s.Name, "type", t)
+			}
+		}
+	}
+	queryCounter("db_queries_total", "Statements executed by type.", func(q QueryMetrics) int64 { return q.Count })
+	queryCounter("db_query_errors_total", "Statements failed by type.", func(q QueryMetrics) int64 { return q.Errors })
+	queryCounter("db_rows_returned_total", "Rows returned by queries.", func(q QueryMetrics) int64 { return q.Rows })
+	queryCounter("db_rows_affected_total", "Rows affected by statements.", func(q QueryMetrics) int64 { return q.Affected })
+
+	p.header("db_query_duration_seconds", "histogram", "Statement latency by type.")
+	for _, s := range snapshots {
+		for _, t := range sortedKeys(s.Queries) {
+			q := s.Queries[t]
+			for i, le := range q.Buckets {
+				p.value("db_query_duration_seconds_bucket", float64(q.Counts[i]), "db", s.Name, "type", t, "le", strconv.FormatFloat(le, 'g', -1, 64))
+			}
+			p.value("db_query_duration_seconds_bucket", float64(q.Count), "db", s.Name, "type", t, "le", "+Inf")
+			p.value("db_query_duration_seconds_sum", q.Duration.Seconds(), "db", s.Name, "type", t)
+			p.value("db_query_duration_seconds_count", float64(q.Count), "db", s.Name, "type", t)
+		}
+	}
+
+	p.header("db_errors_total", "counter", "Statement errors by class.")
+	for _, s := range snapshots {
+		classes := make([]string, 0, len(s.Errors))
+		for c := range s.Errors {
+			classes = append(classes, c)
+		}
+		sort.Strings(classes)
+		for _, c := range classes {
+			p.value("db_errors_total", float64(s.Errors[c]), "db", s.Name, "class", c)
+		}
+	}
+
+	each := func(name, typ, help string, get func(s MetricsSnapshot) float64) {
+		p.header(name, typ, help)
+		for _, s := range snapshots {
+			p.value(name, get(s), "db", s.Name)
+		}
+	}
+	each("db_queue_pending", "gauge", "Queued statements waiting to run.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Pending) })
+	each("db_queue_active", "gauge", "Queued statements running.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Active) })
+	each("db_queue_delayed", "gauge", "Queued statements waiting for their schedule or retry.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Delayed) })
+	each("db_queue_dead", "gauge", "Statements in the dead letter list.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Dead) })
+	each("db_queue_succeeded_total", "counter", "Queued statements succeeded.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Succeeded) })
+	each("db_queue_failed_total", "counter", "Queued statements failed.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Failed) })
+	each("db_queue_dropped_total", "counter", "Queued statements dropped because the queue was full.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Dropped) })
+	each("db_queue_rejected_total", "counter", "Statements rejected by the queue.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Rejected) })
+	each("db_queue_retried_total", "counter", "Queued statement retries.", func(s MetricsSnapshot) float64 { return float64(s.Queue.Retried) })
+
+	p.header("db_replica_lag_seconds", "gauge", "Replication lag of each replica measured by Cluster.MeasureLag, -1 when unknown.")
+	for _, s := range snapshots {
+		names := make([]string, 0, len(s.ReplicaLag))
+		for r := range s.ReplicaLag {
+			names = append(names, r)
+		}
+		sort.Strings(names)
+		for _, r := range names {
+			lag := s.ReplicaLag[r].Seconds()
+			if s.ReplicaLag[r] < 0 {
+				lag = -1
+			}
+			p.value("db_replica_lag_seconds", lag, "db", s.Name, "replica", r)
+		}
+	}
+
+	each("db_cache_hits_total", "counter", "Query cache hits.", func(s MetricsSnapshot) float64 { return float64(s.CacheHits) })
+	each("db_cache_misses_total", "counter", "Query cache misses.", func(s MetricsSnapshot) float64 { return float64(s.CacheMisses) })
+	each("db_cache_hit_ratio", "gauge", "Query cache hit ratio.", func(s MetricsSnapshot) float64 { return s.CacheHitRatio })
+
+	each("db_pool_max_open_connections", "gauge", "Maximum number of open connections.", func(s MetricsSnapshot) float64 { return float64(s.Pool.MaxOpenConnections) })
+	each("db_pool_open_connections", "gauge", "Established connections.", func(s MetricsSnapshot) float64 { return float64(s.Pool.OpenConnections) })
+	each("db_pool_in_use_connections", "gauge", "Connections currently in use.", func(s MetricsSnapshot) float64 { return float64(s.Pool.InUse) })
+	each("db_pool_idle_connections", "gauge", "Idle connections.", func(s MetricsSnapshot) float64 { return float64(s.Pool.Idle) })
+	each("db_pool_wait_count_total", "counter", "Connections waited for.", func(s MetricsSnapshot) float64 { return float64(s.Pool.WaitCount) })
+	each("db_pool_wait_duration_seconds_total", "counter", "Time blocked waiting for a connection.", func(s MetricsSnapshot) float64 { return s.Pool.WaitDuration.Seconds() })
+	each("db_pool_max_idle_closed_total", "counter", "Connections closed due to max idle connections.", func(s MetricsSnapshot) float64 { return float64(s.Pool.MaxIdleClosed) })
+	each("db_pool_max_idle_time_closed_total", "counter", "Connections closed due to max idle time.", func(s MetricsSnapshot) float64 { return float64(s.Pool.MaxIdleTimeClosed) })
+	each("db_pool_max_lifetime_closed_total", "counter", "Connections closed due to max lifetime.", func(s MetricsSnapshot) float64 { return float64(s.Pool.MaxLifetimeClosed) })
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatementType(t *testing.T) {
+	cases := map[string]string{
+		"SELECT 1":                                               "select",
+		" (select 1) UNION (select 2)":                           "select",
+		"WITH t AS (SELECT 1) SELECT * FROM t":                   "select",
+		"insert into t values (1)":                               "insert",
+		"REPLACE INTO t VALUES (1)":                              "insert",
+		"INSERT INTO t VALUES (1) ON DUPLICATE KEY UPDATE a = 1": "insert_update",
+		"UPDATE t SET a = 1":                                     "update",
+		"DELETE FROM t":                                          "delete",
+		"SHOW TABLES":                                            "other",
+		"":                                                       "other",
+	}
+	for query, want := range cases {
+		if got := statementType(KindExec, query); got != want {
+			t.Errorf("statementType(%q) = %q, want %q", query, got, want)
+		}
+	}
+	if got := statementType(KindCommit, ""); got != "commit" {
+		t.Fatalf("statementType(commit) = %q", got)
+	}
+}
+
+func TestMetricsSnapshot(t *testing.T) {
+	d, srv := newTestDB(t)
+	m := d.EnableMetrics("main", 10, 0.5)
+	if d.EnableMetrics("other") != m || d.Metrics() != m {
+		t.Fatal("EnableMetrics should return the existing metrics")
+	}
+
+	srv.affected = 2
+	srv.result([]string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+	d.Exec("UPDATE t SET a = 1")
+	d.Select("SELECT id FROM t")
+	srv.fail(errTestDeadlock)
+	d.Exec("UPDATE t SET a = 2")
+	d.AddHook(HookFuncs{BeforeFunc: func(stmt *Statement) error {
+		if strings.HasPrefix(stmt.Query, "DELETE") {
+			return errors.New("denied")
+		}
+		return nil
+	}})
+	d.Exec("DELETE FROM t")
+	d.SelectCached(time.Minute, "SELECT id FROM t WHERE id = ?", 1)
+	d.SelectCached(time.Minute, "SELECT id FROM t WHERE id = ?", 1)
+
+	s := m.Snapshot()
+	if s.Name != "main" || s.Time.IsZero() || s.ReplicaLag != nil {
+		t.Fatalf("snapshot = %+v", s)
+	}
+	up := s.Queries["update"]
+	if up.Count != 2 || up.Errors != 1 || up.Affected != 2 || up.Rows != 0 {
+		t.Fatalf("update = %+v", up)
+	}
+	if !reflect.DeepEqual(up.Buckets, []float64{0.5, 10}) || !reflect.DeepEqual(up.Counts, []int64{2, 2}) {
+		t.Fatalf("histogram = %v %v", up.Buckets, up.Counts)
+	}
+	if sel := s.Queries["select"]; sel.Count != 2 || sel.Rows != 4 {
+		t.Fatalf("select = %+v", sel)
+	}
+	if !reflect.DeepEqual(s.Errors, map[string]int64{"deadlock": 1, "vetoed": 1}) {
+		t.Fatalf("errors = %v", s.Errors)
+	}
+	if s.CacheHits != 1 || s.CacheMisses != 1 || s.CacheHitRatio != 0.5 {
+		t.Fatalf("cache = %d/%d %v", s.CacheHits, s.CacheMisses, s.CacheHitRatio)
+	}
+}
+
+func TestMetricsQueueAndReplicas(t *testing.T) {
+	d, _ := newQueueDB(t, QueueOptions{})
+	r1, _ := newTestDB(t)
+	r2, _ := newTestDB(t)
+	r2.EnableMetrics("eu")
+	c := NewCluster(d, r1, r2)
+	c.replicas[0].lag = -1
+	c.replicas[1].lag = 1500 * time.Millisecond
+	m := d.EnableMetrics("main")
+
+	waitFuture(t, d.Queue("UPDATE t SET a = 1"))
+	s := m.Snapshot()
+	if s.Queue.Succeeded != 1 || s.Queries["update"].Count != 1 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+	if !reflect.DeepEqual(s.ReplicaLag, map[string]time.Duration{"0": -1, "eu": 1500 * time.Millisecond}) {
+		t.Fatalf("replica lag = %v", s.ReplicaLag)
+	}
+
+	buf := &bytes.Buffer{}
+	WriteMetrics(buf, s)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE db_replica_lag_seconds gauge\n",
+		`db_replica_lag_seconds{db="main",replica="0"} -1` + "\n",
+		`db_replica_lag_seconds{db="main",replica="eu"} 1.5` + "\n",
+		`db_queue_succeeded_total{db="main"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	s := MetricsSnapshot{
+		Name: `a"b`,
+		Queries: map[string]QueryMetrics{
+			"update": {Count: 3, Errors: 1, Affected: 5, Duration: 1500 * time.Millisecond, Buckets: []float64{0.1, 1}, Counts: []int64{1, 2}},
+			"select": {Count: 1, Rows: 7, Duration: time.Millisecond, Buckets: []float64{0.1, 1}, Counts: []int64{1, 1}},
+		},
+		Errors:        map[string]int64{"timeout": 2, "deadlock": 1},
+		CacheHits:     3,
+		CacheMisses:   1,
+		CacheHitRatio: 0.75,
+	}
+	s.Pool.MaxOpenConnections = 10
+	buf := &bytes.Buffer{}
+	WriteMetrics(buf, s)
+	out := buf.String()
+
+	want := `# HELP db_queries_total Statements executed by type.
+# TYPE db_queries_total counter
+db_queries_total{db="a\"b",type="select"} 1
+db_queries_total{db="a\"b",type="update"} 3
+`
+	if !strings.HasPrefix(out, want) {
+		t.Fatalf("output = %s", out)
+	}
+	for _, want := range []string{
+		`db_rows_returned_total{db="a\"b",type="select"} 7`,
+		`db_rows_affected_total{db="a\"b",type="update"} 5`,
+		`db_query_duration_seconds_bucket{db="a\"b",type="update",le="0.1"} 1`,
+		`db_query_duration_seconds_bucket{db="a\"b",type="update",le="1"} 2`,
+		`db_query_duration_seconds_bucket{db="a\"b",type="update",le="+Inf"} 3`,
+		`db_query_duration_seconds_sum{db="a\"b",type="update"} 1.5`,
+		`db_query_duration_seconds_count{db="a\"b",type="update"} 3`,
+		`db_errors_total{db="a\"b",class="deadlock"} 1` + "\n" + `db_errors_total{db="a\"b",class="timeout"} 2`,
+		`db_cache_hit_ratio{db="a\"b"} 0.75`,
+		`db_pool_max_open_connections{db="a\"b"} 10`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+	if strings.Contains(out, "db_replica_lag_seconds{") {
+		t.Fatal("replica lag should only be written for clusters")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	d, _ := newTestDB(t)
+	m := d.EnableMetrics("main")
+	d.ExecContext(context.Background(), "UPDATE t SET a = 1")
+
+	w := httptest.NewRecorder()
+	NewMetricsHandler(m).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), `db_queries_total{db="main",type="update"} 1`+"\n") {
+		t.Fatalf("body = %s", w.Body)
+	}
+}
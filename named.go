@@ -0,0 +1,254 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 命名参数SQL
+// 支持 :name 与 @name 两种写法, 参数可以是 Values / map[string]interface{} 或带 db 标签的结构体(指针)
+// 例: SELECT * FROM user WHERE id IN (:ids) AND name = :name
+//
+// 注意:
+//  1. 字符串/标识符/注释中的冒号和@不会被当作参数
+//  2. :: 类型转换会原样保留
+//  3. @name 在参数中不存在时按MySQL用户变量原样保留, @@系统变量同样保留
+//  4. 切片参数([]byte除外)会被展开为多个占位符, 用于 IN (:ids)
+
+// 将命名参数SQL转换为数据库对应的占位符SQL及参数列表
+func BindNamed(dbType, query string, params interface{}) (string, []interface{}, error) {
+	lookup, err := namedLookup(params)
+	if err != nil {
+		return "", nil, err
+	}
+
+	rs := []rune(query)
+	n := len(rs)
+	s := strings.Builder{}
+	args := make([]interface{}, 0)
+
+	for i := 0; i < n; i++ {
+		c := rs[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// 字符串或标识符, 原样输出直到结束引号
+			j := skipQuoted(rs, i)
+			s.WriteString(string(rs[i:j]))
+			i = j - 1
+		case c == '-' && i+1 < n && rs[i+1] == '-', c == '#' && (dbType == "" || dbType == "mysql"):
+			// 单行注释
+			j := i
+			for j < n && rs[j] != '\n' {
+				j++
+			}
+			s.WriteString(string(rs[i:j]))
+			i = j - 1
+		case c == '/' && i+1 < n && rs[i+1] == '*':
+			// 多行注释
+			j := i + 2
+			for j+1 < n && !(rs[j] == '*' && rs[j+1] == '/') {
+				j++
+			}
+			j += 2
+			if j > n {
+				j = n
+			}
+			s.WriteString(string(rs[i:j]))
+			i = j - 1
+		case c == ':' && i+1 < n && rs[i+1] == ':':
+			// PostgreSQL 类型转换
+			s.WriteString("::")
+			i++
+		case c == '@' && i+1 < n && rs[i+1] == '@':
+			// 系统变量
+			j := i + 2
+			for j < n && isNameRune(rs[j]) {
+				j++
+			}
+			s.WriteString(string(rs[i:j]))
+			i = j - 1
+		case (c == ':' || c == '@') && i+1 < n && isNameStart(rs[i+1]):
+			j := i + 1
+			for j < n && isNameRune(rs[j]) {
+				j++
+			}
+			name := string(rs[i+1 : j])
+			val, ok := lookup(name)
+			if !ok {
+				if c == '@' {
+					// 用户变量
+					s.WriteString(string(rs[i:j]))
+					i = j - 1
+					continue
+				}
+				return "", nil, fmt.Errorf("missing named parameter: %s", name)
+			}
+			if err = appendNamedArg(&s, &args, dbType, name, val); err != nil {
+				return "", nil, err
+			}
+			i = j - 1
+		default:
+			s.WriteRune(c)
+		}
+	}
+	return s.String(), args, nil
+}
+
+// 写入参数占位符, 切片参数展开为多个占位符
+func appendNamedArg(s *strings.Builder, args *[]interface{}, dbType, name string, val interface{}) error {
+	rv := reflect.ValueOf(val)
+	if val != nil && (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+		if rv.Len() == 0 {
+			return fmt.Errorf("empty slice for named parameter: %s", name)
+		}
+		for k := 0; k < rv.Len(); k++ {
+			if k > 0 {
+				s.WriteString(",")
+			}
+			*args = append(*args, rv.Index(k).Interface())
+			s.WriteString(placeholder(dbType, len(*args)))
+		}
+		return nil
+	}
+	*args = append(*args, val)
+	s.WriteString(placeholder(dbType, len(*args)))
+	return nil
+}
+
+// 获取指定数据库类型的第n(从1开始)个占位符
+func placeholder(dbType string, n int) string {
+	switch dbType {
+	case "postgres", "postgresql", "pgsql":
+		return "$" + strconv.Itoa(n)
+	case "mssql", "sqlserver":
+		return "@p" + strconv.Itoa(n)
+	case "oracle":
+		return ":" + strconv.Itoa(n)
+	default:
+		return "?"
+	}
+}
+
+// 跳过引号包裹的内容, 返回结束引号之后的位置
+func skipQuoted(rs []rune, start int) int {
+	q := rs[start]
+	n := len(rs)
+	for j := start + 1; j < n; j++ {
+		switch rs[j] {
+		case '\\':
+			if q != '`' {
+				j++
+			}
+		case q:
+			// 连续两个引号表示转义
+			if j+1 < n && rs[j+1] == q {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return n
+}
+
+func isNameStart(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isNameRune(r rune) bool {
+	return isNameStart(r) || (r >= '0' && r <= '9')
+}
+
+// 构建命名参数的取值函数
+func namedLookup(params interface{}) (func(string) (interface{}, bool), error) {
+	switch p := params.(type) {
+	case nil:
+		return func(string) (interface{}, bool) { return nil, false }, nil
+	case Values:
+		return func(name string) (interface{}, bool) {
+			v, ok := p[name]
+			return v, ok
+		}, nil
+	case map[string]interface{}:
+		return func(name string) (interface{}, bool) {
+			v, ok := p[name]
+			return v, ok
+		}, nil
+	}
+
+	rv := reflect.ValueOf(params)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, errors.New("named parameters is nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("named parameters must be Values, map or struct")
+	}
+
+	tp := rv.Type()
+	fields := make(map[string]int)
+	for i := 0; i < tp.NumField(); i++ {
+		tag := tp.Field(i).Tag.Get(dbTag)
+		if len(tag) > 0 && tag != "-" {
+			fields[tag] = i
+		}
+	}
+	return func(name string) (interface{}, bool) {
+		i, ok := fields[name]
+		if !ok {
+			return nil, false
+		}
+		return rv.Field(i).Interface(), true
+	}, nil
+}
+
+// 使用命名参数执行语句
+func (this *Database) ExecNamed(query string, params interface{}) (sql.Result, error) {
+	s, args, err := BindNamed(this.Type, query, params)
+	if err != nil {
+		return nil, err
+	}
+	return this.Exec(s, args...)
+}
+
+// 使用命名参数查询不定字段的结果集
+func (this *Database) SelectNamed(query string, params interface{}) ([]map[string]string, error) {
+	s, args, err := BindNamed(this.Type, query, params)
+	if err != nil {
+		return nil, err
+	}
+	return this.Select(s, args...)
+}
+
+// 使用命名参数查询一行不定字段的结果
+func (this *Database) SelectOneNamed(query string, params interface{}) (OneRow, error) {
+	s, args, err := BindNamed(this.Type, query, params)
+	if err != nil {
+		return nil, err
+	}
+	return this.SelectOne(s, args...)
+}
+
+// 使用命名参数查询单个实体
+func (this *Database) QueryStructNamed(obj interface{}, query string, params interface{}) error {
+	s, args, err := BindNamed(this.Type, query, params)
+	if err != nil {
+		return err
+	}
+	return this.QueryStruct(obj, s, args...)
+}
+
+// 使用命名参数查询实体集合
+func (this *Database) QueryStructsNamed(obj interface{}, query string, params interface{}) error {
+	s, args, err := BindNamed(this.Type, query, params)
+	if err != nil {
+		return err
+	}
+	return this.QueryStructs(obj, s, args...)
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBindNamed(t *testing.T) {
+	type user struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+		Skip string `db:"-"`
+		Note string
+	}
+	tests := []struct {
+		name   string
+		dbType string
+		query  string
+		params interface{}
+		sql    string
+		args   []interface{}
+	}{
+		{
+			name:   "values",
+			query:  "SELECT * FROM user WHERE id = :id AND name = @name",
+			params: Values{"id": 1, "name": "a"},
+			sql:    "SELECT * FROM user WHERE id = ? AND name = ?",
+			args:   []interface{}{1, "a"},
+		},
+		{
+			name:   "map",
+			query:  "UPDATE user SET name = :name WHERE id = :id",
+			params: map[string]interface{}{"id": 2, "name": "b"},
+			sql:    "UPDATE user SET name = ? WHERE id = ?",
+			args:   []interface{}{"b", 2},
+		},
+		{
+			name:   "struct pointer",
+			query:  "INSERT INTO user (id, name) VALUES (:id, :name)",
+			params: &user{ID: 3, Name: "c"},
+			sql:    "INSERT INTO user (id, name) VALUES (?, ?)",
+			args:   []interface{}{int64(3), "c"},
+		},
+		{
+			name:   "repeated name",
+			query:  "SELECT :v, :v",
+			params: Values{"v": 1},
+			sql:    "SELECT ?, ?",
+			args:   []interface{}{1, 1},
+		},
+		{
+			name:   "slice expands",
+			query:  "SELECT * FROM user WHERE id IN (:ids) AND data = :data",
+			params: Values{"ids": []int{1, 2, 3}, "data": []byte("x")},
+			sql:    "SELECT * FROM user WHERE id IN (?,?,?) AND data = ?",
+			args:   []interface{}{1, 2, 3, []byte("x")},
+		},
+		{
+			name:   "quoted and comments",
+			query:  "SELECT ':a', \"@b\", `:c`, 'it''s :d', 'x\\':e' -- :f\n, /* :g */ :h # :i",
+			params: Values{"h": 1},
+			sql:    "SELECT ':a', \"@b\", `:c`, 'it''s :d', 'x\\':e' -- :f\n, /* :g */ ? # :i",
+			args:   []interface{}{1},
+		},
+		{
+			name:   "variables",
+			query:  "SELECT @@version, @rownum := @rownum + 1, :id",
+			params: Values{"id": 1},
+			sql:    "SELECT @@version, @rownum := @rownum + 1, ?",
+			args:   []interface{}{1},
+		},
+		{
+			name:   "postgres",
+			dbType: "postgres",
+			query:  "SELECT :id::text, # FROM t WHERE id IN (:ids)",
+			params: Values{"id": 1, "ids": []string{"a", "b"}},
+			sql:    "SELECT $1::text, # FROM t WHERE id IN ($2,$3)",
+			args:   []interface{}{1, "a", "b"},
+		},
+		{
+			name:   "mssql",
+			dbType: "mssql",
+			query:  "SELECT :a, :b",
+			params: Values{"a": 1, "b": 2},
+			sql:    "SELECT @p1, @p2",
+			args:   []interface{}{1, 2},
+		},
+		{
+			name:   "oracle",
+			dbType: "oracle",
+			query:  "SELECT :a FROM dual",
+			params: Values{"a": 1},
+			sql:    "SELECT :1 FROM dual",
+			args:   []interface{}{1},
+		},
+		{
+			name:   "unicode",
+			query:  "SELECT '名字:x' AS 名字, :名",
+			params: nil,
+			sql:    "SELECT '名字:x' AS 名字, :名",
+			args:   []interface{}{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := BindNamed(tt.dbType, tt.query, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.sql {
+				t.Errorf("sql = %q, want %q", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestBindNamedErrors(t *testing.T) {
+	var nilUser *struct{}
+	tests := []struct {
+		name   string
+		query  string
+		params interface{}
+		err    string
+	}{
+		{"missing", "SELECT :id", Values{}, "missing named parameter: id"},
+		{"empty slice", "SELECT * FROM t WHERE id IN (:ids)", Values{"ids": []int{}}, "empty slice for named parameter: ids"},
+		{"nil pointer", "SELECT :id", nilUser, "nil pointer"},
+		{"bad type", "SELECT :id", 1, "must be Values, map or struct"},
+		{"ignored field", "SELECT :Note", struct{ Note string }{}, "missing named parameter: Note"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := BindNamed("mysql", tt.query, tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDatabaseNamed(t *testing.T) {
+	d, srv := newTestDB(t)
+	srv.result([]string{"id", "name"}, []driver.Value{int64(1), []byte("a")})
+
+	if _, err := d.ExecNamed("UPDATE user SET name = :name WHERE id = :id", Values{"id": 1, "name": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if q := srv.queries()[0]; q != "UPDATE user SET name = ? WHERE id = ?" {
+		t.Fatalf("query = %q", q)
+	}
+	if args := srv.args(0); !reflect.DeepEqual(args, []driver.Value{"a", int64(1)}) {
+		t.Fatalf("args = %#v", args)
+	}
+
+	rows, err := d.SelectNamed("SELECT id, name FROM user WHERE id IN (:ids)", Values{"ids": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["name"] != "a" {
+		t.Fatalf("rows = %v", rows)
+	}
+	if q := srv.queries()[1]; q != "SELECT id, name FROM user WHERE id IN (?,?)" {
+		t.Fatalf("query = %q", q)
+	}
+
+	var u struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	if err = d.QueryStructNamed(&u, "SELECT id, name FROM user WHERE id = :id", Values{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != 1 || u.Name != "a" {
+		t.Fatalf("struct = %+v", u)
+	}
+
+	if _, err = d.ExecNamed("DELETE FROM user WHERE id = :id", nil); err == nil {
+		t.Fatal("missing parameter should fail before executing")
+	}
+	if n := len(srv.queries()); n != 3 {
+		t.Fatalf("executed %d statements, want 3", n)
+	}
+}
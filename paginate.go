@@ -0,0 +1,125 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// 分页结果
+type Page struct {
+	Items      []map[string]string // 当前页的记录, 使用 PaginateInto 时为nil
+	Page       int                 // 当前页码, 从1开始
+	Size       int                 // 每页记录数
+	Total      int64               // 总记录数
+	TotalPages int                 // 总页数
+	HasNext    bool                // 是否有下一页
+	HasPrev    bool                // 是否有上一页
+}
+
+// 构造统计总记录数的语句, 去掉 ORDER BY 与 LIMIT; 含 GROUP BY 或 DISTINCT 的查询作为子查询统计
+func (q *SQ) countSql() (string, error) {
+	c := *q
+	c.order = ""
+	c.limit = ""
+	if c.group == "" && !hasDistinct(c.field) {
+		c.field = "COUNT(*)"
+		return c.execSql()
+	}
+	inner, err := c.execSql()
+	if err != nil {
+		return "", err
+	}
+	return "SELECT COUNT(*) FROM (" + inner + ") AS _count", nil
+}
+
+// 字段列表是否以 DISTINCT 开头
+func hasDistinct(field string) bool {
+	field = strings.TrimSpace(field)
+	return len(field) >= 8 && strings.EqualFold(field[:8], "DISTINCT")
+}
+
+// 统计总记录数并计算分页信息, 返回的 Page 中 Items 为nil
+func (q *SQ) paginate(page, size int, args []interface{}) (*Page, error) {
+	if size <= 0 {
+		return nil, errors.New("page size must be greater than 0")
+	}
+	if page < 1 {
+		page = 1
+	}
+	query, err := q.countSql()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	ctx := q.context()
+	if q.cache {
+		var ret []map[string]string
+		ret, err = q.db.SelectCachedContext(ctx, q.cacheTTL, q.cacheGroup, query, args...)
+		if err == nil && len(ret) > 0 {
+			for _, v := range ret[0] {
+				total = Atoi64(v)
+			}
+		}
+	} else {
+		err = q.db.readRow(ctx, query, args).Scan(&total)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Page{Page: page, Size: size, Total: total}
+	p.TotalPages = int((total + int64(size) - 1) / int64(size))
+	p.HasNext = page < p.TotalPages
+	p.HasPrev = page > 1
+	return p, nil
+}
+
+// 分页查询, page 从1开始, args 为语句的参数
+// 总记录数由构造的语句自动统计; 页码超出范围时 Items 为空
+func (q *SQ) Paginate(page, size int, args ...interface{}) (*Page, error) {
+	p, err := q.paginate(page, size, args)
+	if err != nil {
+		return nil, err
+	}
+	if int64(p.Page-1)*int64(p.Size) >= p.Total {
+		p.Items = []map[string]string{}
+		return p, nil
+	}
+	q.Limit(p.Size, (p.Page-1)*p.Size)
+	p.Items, err = q.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// 分页查询并将当前页的记录填充到实体切片, obj 为实体切片指针, 参见 Paginate
+// 页码超出范围时 obj 被置为空切片
+func (q *SQ) PaginateInto(obj interface{}, page, size int, args ...interface{}) (*Page, error) {
+	p, err := q.paginate(page, size, args)
+	if err != nil {
+		return nil, err
+	}
+	if int64(p.Page-1)*int64(p.Size) >= p.Total {
+		if v := reflect.ValueOf(obj); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+			v.Elem().Set(reflect.MakeSlice(v.Elem().Type(), 0, 0))
+		}
+		return p, nil
+	}
+	q.Limit(p.Size, (p.Page-1)*p.Size)
+	query, err := q.execSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx := q.context()
+	if q.cache {
+		err = q.db.queryStructCached(ctx, q.cacheTTL, q.cacheGroup, obj, query, args, true)
+	} else {
+		err = q.db.QueryStructsContext(ctx, obj, query, args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
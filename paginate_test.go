@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 统计语句返回 total, 其他查询返回 rows
+func pageServer(srv *testServer, total int64, rows ...[]driver.Value) {
+	srv.handle = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT COUNT(*)") {
+			return []string{"n"}, [][]driver.Value{{total}}, nil
+		}
+		return []string{"id", "name"}, rows, nil
+	}
+}
+
+func TestCountSql(t *testing.T) {
+	d, _ := newTestDB(t)
+	cases := []struct {
+		q    *SQ
+		want string
+	}{
+		{Select("id, name").DB(d).Table("user").Where("age > ?").Order("id DESC").Limit(10, 20), "SELECT COUNT(*) FROM user WHERE age > ?"},
+		{Select("dept").DB(d).Table("user").Group("dept"), "SELECT COUNT(*) FROM (SELECT dept FROM user GROUP BY dept) AS _count"},
+		{Select(" distinct dept").DB(d).Table("user"), "SELECT COUNT(*) FROM (SELECT  distinct dept FROM user) AS _count"},
+	}
+	for _, c := range cases {
+		if s, err := c.q.countSql(); err != nil || s != c.want {
+			t.Errorf("countSql = %q, %v, want %q", s, err, c.want)
+		}
+	}
+	// 统计不影响原语句
+	q := cases[0].q
+	if s, _ := q.ToSql(); !strings.HasSuffix(s, "ORDER BY id DESC LIMIT 20,10") {
+		t.Fatalf("ToSql = %q", s)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	d, srv := newTestDB(t)
+	pageServer(srv, 25, []driver.Value{[]byte("11"), []byte("k")})
+
+	p, err := Select("id, name").DB(d).Table("user").Where("age > ?").Order("id").Paginate(2, 10, 18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Page{Items: []map[string]string{{"id": "11", "name": "k"}}, Page: 2, Size: 10, Total: 25, TotalPages: 3, HasNext: true, HasPrev: true}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("page = %+v", p)
+	}
+	q := srv.queries()
+	if len(q) != 2 || q[0] != "SELECT COUNT(*) FROM user WHERE age > ?" || q[1] != "SELECT id, name FROM user WHERE age > ? ORDER BY id LIMIT 10,10" {
+		t.Fatalf("queries = %q", q)
+	}
+	if !reflect.DeepEqual(srv.args(0), []driver.Value{int64(18)}) || !reflect.DeepEqual(srv.args(1), []driver.Value{int64(18)}) {
+		t.Fatal("args should be passed to both statements")
+	}
+
+	// 页码超出范围时不查询记录
+	srv.reset()
+	p, err = Select("id, name").DB(d).Table("user").Paginate(4, 10)
+	if err != nil || len(p.Items) != 0 || p.Items == nil || p.HasNext || p.TotalPages != 3 {
+		t.Fatalf("page = %+v, %v", p, err)
+	}
+	if len(srv.queries()) != 1 {
+		t.Fatal("out of range page should only count")
+	}
+
+	// 页码小于1时为第一页
+	p, _ = Select("id, name").DB(d).Table("user").Paginate(0, 10)
+	if p.Page != 1 || p.HasPrev {
+		t.Fatalf("page = %+v", p)
+	}
+	if _, err = Select("id").DB(d).Table("user").Paginate(1, 0); err == nil {
+		t.Fatal("zero page size should fail")
+	}
+}
+
+func TestPaginateEmpty(t *testing.T) {
+	d, srv := newTestDB(t)
+	pageServer(srv, 0)
+	p, err := Select("id").DB(d).Table("user").Paginate(1, 10)
+	if err != nil || p.Total != 0 || p.TotalPages != 0 || p.HasNext || p.HasPrev || len(p.Items) != 0 {
+		t.Fatalf("page = %+v, %v", p, err)
+	}
+}
+
+func TestPaginateInto(t *testing.T) {
+	d, srv := newTestDB(t)
+	pageServer(srv, 3, []driver.Value{[]byte("1"), []byte("a")}, []driver.Value{[]byte("2"), []byte("b")})
+	type user struct {
+		ID   int    `db:"id"`
+		Name string `db:"name"`
+	}
+
+	var users []user
+	p, err := Select("id, name").DB(d).Table("user").PaginateInto(&users, 1, 2)
+	if err != nil || p.Items != nil || p.TotalPages != 2 || !p.HasNext {
+		t.Fatalf("page = %+v, %v", p, err)
+	}
+	if !reflect.DeepEqual(users, []user{{1, "a"}, {2, "b"}}) {
+		t.Fatalf("users = %v", users)
+	}
+
+	// 页码超出范围时置为空切片
+	p, err = Select("id, name").DB(d).Table("user").PaginateInto(&users, 3, 2)
+	if err != nil || users == nil || len(users) != 0 {
+		t.Fatalf("users = %v, %v", users, err)
+	}
+}
+
+func TestPaginateCached(t *testing.T) {
+	d, srv := newTestDB(t)
+	pageServer(srv, 5, []driver.Value{[]byte("1"), []byte("a")})
+
+	for i := 0; i < 2; i++ {
+		p, err := Select("id, name").DB(d).Table("user").Cache(time.Minute).Paginate(1, 2)
+		if err != nil || p.Total != 5 || len(p.Items) != 1 {
+			t.Fatalf("page = %+v, %v", p, err)
+		}
+	}
+	if n := len(srv.queries()); n != 2 {
+		t.Fatalf("executed %d queries, want 2", n)
+	}
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 查询缓存的默认分组
+const QueryCacheGroup = "sql"
+
+var tableRe = regexp.MustCompile("(?i)\\b(?:from|join)\\s+")
+
+// 获取SQL语句中 FROM 与 JOIN 之后的表名
+func queryTables(query string) []string {
+	var tables []string
+	for _, loc := range tableRe.FindAllStringIndex(query, -1) {
+		rest := query[loc[1]:]
+		for {
+			name := rest
+			if i := strings.IndexAny(name, " \t\r\n,()"); i >= 0 {
+				name = name[:i]
+			}
+			if name == "" {
+				break
+			}
+			tables = append(tables, normalizeTable(name))
+			// FROM a, b 形式的多个表
+			rest = rest[len(name):]
+			i := strings.IndexAny(rest, ",()")
+			if i < 0 || rest[i] != ',' || !isTableAlias(rest[:i]) {
+				break
+			}
+			rest = strings.TrimLeft(rest[i+1:], " \t\r\n")
+		}
+	}
+	return tables
+}
+
+// 表名与逗号之间是否只有别名
+func isTableAlias(s string) bool {
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 0:
+		return true
+	case 1:
+		return !isSqlKeyword(fields[0])
+	case 2:
+		return strings.EqualFold(fields[0], "AS")
+	}
+	return false
+}
+
+func isSqlKeyword(s string) bool {
+	switch strings.ToUpper(s) {
+	case "WHERE", "GROUP", "ORDER", "LIMIT", "HAVING", "JOIN", "LEFT", "RIGHT", "INNER", "OUTER", "CROSS", "ON", "UNION", "FOR":
+		return true
+	}
+	return false
+}
+
+// 规范化表名, 去掉引号及库名并转为小写
+func normalizeTable(name string) string {
+	name = strings.Trim(name, "`\"[]")
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = strings.Trim(name[i+1:], "`\"[]")
+	}
+	return strings.ToLower(name)
+}
+
+// 规范化SQL语句, 合并引号之外的连续空白
+func normalizeSQL(query string) string {
+	s := strings.Builder{}
+	var quote byte
+	space := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			space = true
+			continue
+		}
+		if space && s.Len() > 0 {
+			s.WriteByte(' ')
+		}
+		space = false
+		s.WriteByte(c)
+	}
+	return s.String()
+}
+
+// 表令牌保存在缓存后端中的分组, 表被写入时更换令牌, 使用旧令牌生成的缓存键不再被命中
+const tableTokenGroup = "_tables"
+
+var queryFlight flightGroup
+
+// 获取缓存键的命名空间
+func (this *Database) cacheNamespace() string {
+	if this.CacheNamespace != "" {
+		return this.CacheNamespace
+	}
+	return fmt.Sprintf("%p", this)
+}
+
+// 表令牌的缓存键
+func (this *Database) tableTokenKey(table string) string {
+	return this.cacheNamespace() + "\x00" + table
+}
+
+// 获取表的令牌, 不存在时生成新的令牌
+// 令牌不会被重复使用, 因此令牌被淘汰时只会使该表的缓存失效, 不会命中过期的数据
+func (this *Database) tableToken(b CacheBackend, table string) string {
+	key := this.tableTokenKey(table)
+	v, ok, err := b.Get(tableTokenGroup, key)
+	if err == nil && ok {
+		return string(v)
+	}
+	token := newCacheToken()
+	if err == nil {
+		b.Set(tableTokenGroup, key, []byte(token), 0)
+	}
+	return token
+}
+
+// 生成查询缓存的键, 包含命名空间、规范化的SQL语句、参数、结果类型及相关表的令牌
+// 同时返回相关表的标签, 为表令牌的缓存键加上令牌
+func (this *Database) queryCacheKey(b CacheBackend, query string, args []interface{}, kind string) (string, []string) {
+	s := strings.Builder{}
+	s.WriteString(this.cacheNamespace())
+	s.WriteByte(0)
+	s.WriteString(normalizeSQL(query))
+	for _, arg := range args {
+		s.WriteByte(0)
+		s.WriteString(fmt.Sprintf("%T=%v", arg, arg))
+	}
+	s.WriteByte(0)
+	s.WriteString(kind)
+	var tags []string
+	for _, t := range queryTables(query) {
+		token := this.tableToken(b, t)
+		s.WriteByte(0)
+		s.WriteString(t)
+		s.WriteByte('@')
+		s.WriteString(token)
+		tags = append(tags, this.tableTokenKey(t)+"@"+token)
+	}
+	return s.String(), tags
+}
+
+// 使表的查询缓存失效
+// 使用 Insert/Update/Delete/InsertUpdate 构造的语句执行成功后会自动调用(执行过缓存查询或设置了缓存后端时); 直接执行SQL语句写入时需要手动调用
+// 缓存后端支持标签时同时删除使用旧令牌的缓存数据
+func (this *Database) InvalidateTables(tables ...string) {
+	b := GetCacheBackend()
+	tb, tagged := b.(taggedBackend)
+	for _, t := range tables {
+		key := this.tableTokenKey(normalizeTable(t))
+		var old []byte
+		var exist bool
+		if tagged {
+			old, exist, _ = b.Get(tableTokenGroup, key)
+		}
+		if err := b.Set(tableTokenGroup, key, []byte(newCacheToken()), 0); err != nil {
+			logWari("查询缓存失效失败: ", err)
+			continue
+		}
+		if exist {
+			tb.DelTag(key + "@" + string(old))
+		}
+	}
+}
+
+// 使用缓存查询, 结果以JSON格式保存在缓存后端中, 解码到out
+// 缓存后端出错时直接查询; 同一查询的并发未命中只执行一次load
+func (this *Database) cachedQuery(ttl time.Duration, group string, query string, args []interface{}, out interface{}, load func() (interface{}, error)) error {
+	atomic.StoreInt32(&queryCacheUsed, 1)
+	b := GetCacheBackend()
+	key, tags := this.queryCacheKey(b, query, args, reflect.TypeOf(out).String())
+	data, ok, err := b.Get(group, key)
+	if err != nil {
+		logWari("查询缓存读取失败: ", err)
+	}
+	if m := this.Metrics(); m != nil {
+		m.observeCache(ok)
+	}
+	if !ok {
+		v, err := queryFlight.do(group+"\x00"+key, func() (interface{}, error) {
+			v, err := load()
+			if err != nil {
+				return nil, err
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if tb, ok := b.(taggedBackend); ok {
+				err = tb.SetTagged(group, key, data, ttl, tags)
+			} else {
+				err = b.Set(group, key, data, ttl)
+			}
+			if err != nil {
+				logWari("查询缓存写入失败: ", err)
+			}
+			return data, nil
+		})
+		if err != nil {
+			return err
+		}
+		if data, ok = v.([]byte); !ok {
+			return errors.New("invalid cached query result")
+		}
+	}
+	return json.Unmarshal(data, out)
+}
+
+// 查询不定字段的结果集并缓存
+// ttl 小于等于0时使用缓存后端的默认过期时间; 同一查询的并发未命中只执行一次查询
+func (this *Database) SelectCached(ttl time.Duration, query string, args ...interface{}) ([]map[string]string, error) {
+	return this.SelectCachedContext(context.Background(), ttl, QueryCacheGroup, query, args...)
+}
+
+// 查询不定字段的结果集并缓存到指定的分组, 参见 SelectCached
+func (this *Database) SelectCachedContext(ctx context.Context, ttl time.Duration, group string, query string, args ...interface{}) ([]map[string]string, error) {
+	var ret []map[string]string
+	err := this.cachedQuery(ttl, group, query, args, &ret, func() (interface{}, error) {
+		return this.SelectContext(ctx, query, args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 查询实体集合并缓存, 参见 SelectCached
+// obj 为接收数据的实体切片指针, 实体以JSON格式缓存, 只有可导出的字段会被保存
+func (this *Database) QueryStructsCached(ttl time.Duration, obj interface{}, query string, args ...interface{}) error {
+	return this.queryStructCached(context.Background(), ttl, QueryCacheGroup, obj, query, args, true)
+}
+
+// 查询单个实体并缓存, 参见 QueryStructsCached
+func (this *Database) QueryStructCached(ttl time.Duration, obj interface{}, query string, args ...interface{}) error {
+	return this.queryStructCached(context.Background(), ttl, QueryCacheGroup, obj, query, args, false)
+}
+
+// 查询实体并缓存
+func (this *Database) queryStructCached(ctx context.Context, ttl time.Duration, group string, obj interface{}, query string, args []interface{}, many bool) error {
+	tp := reflect.TypeOf(obj)
+	if tp == nil || tp.Kind() != reflect.Ptr {
+		return errors.New("is not pointer")
+	}
+	return this.cachedQuery(ttl, group, query, args, obj, func() (interface{}, error) {
+		ptr := reflect.New(tp.Elem())
+		var err error
+		if many {
+			err = this.QueryStructsContext(ctx, ptr.Interface(), query, args...)
+		} else {
+			err = this.QueryStructContext(ctx, ptr.Interface(), query, args...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ptr.Interface(), nil
+	})
+}
+
+// 查询结果使用缓存
+// ttl 小于等于0时使用缓存后端的默认过期时间, group 为缓存分组, 默认为 QueryCacheGroup; 对 QueryAllRow 与 QueryRow 无效
+func (q *SQ) Cache(ttl time.Duration, group ...string) *SQ {
+	q.cache = true
+	q.cacheTTL = ttl
+	q.cacheGroup = QueryCacheGroup
+	if len(group) > 0 {
+		q.cacheGroup = group[0]
+	}
+	return q
+}
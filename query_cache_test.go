@@ -0,0 +1,253 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueryTables(t *testing.T) {
+	cases := map[string][]string{
+		"SELECT * FROM user WHERE id = 1":                               {"user"},
+		"SELECT * FROM `app`.`User` u JOIN orders o ON o.uid = u.id":    {"user", "orders"},
+		"SELECT * FROM a x, b AS y, c WHERE x.id = y.id":                {"a", "b", "c"},
+		"SELECT * FROM a, b WHERE a.id IN (SELECT id FROM d)":           {"a", "b", "d"},
+		"SELECT * FROM (SELECT id FROM t) x LEFT JOIN s ON s.id = x.id": {"t", "s"},
+		"SELECT NOW()": nil,
+	}
+	for query, want := range cases {
+		if got := queryTables(query); !reflect.DeepEqual(got, want) {
+			t.Errorf("queryTables(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	cases := map[string]string{
+		"  SELECT  a,\n\tb FROM t  ":       "SELECT a, b FROM t",
+		"SELECT * FROM t WHERE s = 'a  b'": "SELECT * FROM t WHERE s = 'a  b'",
+		"SELECT `a  b`\r\nFROM t":          "SELECT `a  b` FROM t",
+	}
+	for query, want := range cases {
+		if got := normalizeSQL(query); got != want {
+			t.Errorf("normalizeSQL(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestSelectCached(t *testing.T) {
+	d, srv := newTestDB(t)
+	srv.result([]string{"id", "name"}, []driver.Value{[]byte("1"), []byte("a")})
+
+	for _, query := range []string{"SELECT id, name FROM user WHERE id = ?", "SELECT id,  name\nFROM user WHERE id = ?"} {
+		ret, err := d.SelectCached(time.Minute, query, 1)
+		if err != nil || len(ret) != 1 || ret[0]["name"] != "a" {
+			t.Fatalf("SelectCached = %v, %v", ret, err)
+		}
+	}
+	if n := len(srv.queries()); n != 1 {
+		t.Fatalf("executed %d queries, want 1", n)
+	}
+
+	// 参数不同时不命中
+	d.SelectCached(time.Minute, "SELECT id, name FROM user WHERE id = ?", 2)
+	if n := len(srv.queries()); n != 2 {
+		t.Fatalf("executed %d queries, want 2", n)
+	}
+
+	// 结果类型不同时不命中
+	type user struct {
+		ID   int    `db:"id"`
+		Name string `db:"name"`
+	}
+	var users []user
+	if err := d.QueryStructsCached(time.Minute, &users, "SELECT id, name FROM user WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	var u user
+	if err := d.QueryStructsCached(time.Minute, &users, "SELECT id, name FROM user WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.QueryStructCached(time.Minute, &u, "SELECT id, name FROM user WHERE id = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0] != (user{1, "a"}) || u != (user{1, "a"}) {
+		t.Fatalf("users = %v, user = %v", users, u)
+	}
+	if n := len(srv.queries()); n != 4 {
+		t.Fatalf("executed %d queries, want 4", n)
+	}
+	if err := d.QueryStructCached(time.Minute, u, "SELECT 1"); err == nil {
+		t.Fatal("non pointer should fail")
+	}
+}
+
+func TestQueryOneCached(t *testing.T) {
+	d, srv := newTestDB(t)
+	for _, cached := range []bool{false, true} {
+		q := func() *SQ {
+			q := Select("*").DB(d).Table("user")
+			if cached {
+				q.Cache(time.Minute)
+			}
+			return q
+		}
+
+		// 缓存与不缓存的查询结果一致
+		srv.reset()
+		srv.result([]string{"id"})
+		if row, err := q().QueryOne(); err != nil || row == nil || len(row) != 0 {
+			t.Fatalf("cached=%v: QueryOne = %v, %v", cached, row, err)
+		}
+		srv.reset()
+		srv.fail(errTestDeadlock)
+		if row, err := q().Where("id = 1").QueryOne(); !IsDeadlock(err) || row != nil {
+			t.Fatalf("cached=%v: QueryOne = %v, %v", cached, row, err)
+		}
+	}
+}
+
+func TestQueryCacheInvalidation(t *testing.T) {
+	d, srv := newTestDB(t)
+	srv.result([]string{"n"}, []driver.Value{int64(1)})
+
+	Select("n").DB(d).Table("user").Cache(time.Minute).Query()
+	Select("n").DB(d).Table("orders").Cache(time.Minute).Query()
+	Update().DB(d).Table("`User`").Value(Values{"n": 2}).Where("id = 1").Exec()
+	Select("n").DB(d).Table("user").Cache(time.Minute).Query()
+	Select("n").DB(d).Table("orders").Cache(time.Minute).Query()
+
+	// 只有被写入的表的缓存失效
+	want := []string{"SELECT n FROM user", "SELECT n FROM orders", "UPDATE `User` SET `n`=? WHERE id = 1", "SELECT n FROM user"}
+	if q := srv.queries(); !reflect.DeepEqual(q, want) {
+		t.Fatalf("queries = %q", q)
+	}
+
+	// 直接执行的写入需要手动使缓存失效
+	d.Exec("DELETE FROM orders")
+	Select("n").DB(d).Table("orders").Cache(time.Minute).Query()
+	d.InvalidateTables("orders")
+	Select("n").DB(d).Table("orders").Cache(time.Minute).Query()
+	if n := len(srv.queries()); n != 6 {
+		t.Fatalf("executed %d statements, want 6", n)
+	}
+}
+
+func TestQueryCacheReleasesStale(t *testing.T) {
+	d, srv := newTestDB(t)
+	srv.result([]string{"n"}, []driver.Value{int64(1)})
+	group := d.CacheNamespace
+
+	// 表被写入时删除使用旧令牌的缓存数据
+	for i := 0; i < 50; i++ {
+		Select("n").DB(d).Table("user").Cache(time.Millisecond, group).Query()
+		Update().DB(d).Table("user").Value(Values{"n": i}).Where("id = 1").Exec()
+	}
+	Select("n").DB(d).Table("user").Cache(time.Minute, group).Query()
+	Cache.lock.Lock()
+	n := len(Cache.data[group])
+	Cache.lock.Unlock()
+	if n != 1 {
+		t.Fatalf("cache holds %d entries, want 1", n)
+	}
+}
+
+func TestQueryCacheUnused(t *testing.T) {
+	old := atomic.LoadInt32(&queryCacheUsed)
+	atomic.StoreInt32(&queryCacheUsed, 0)
+	defer atomic.StoreInt32(&queryCacheUsed, old)
+
+	d, _ := newTestDB(t)
+	d.CacheNamespace = "unused-test"
+	Cache.Del("unused-test\x00user", tableTokenGroup)
+	if ret := Update().DB(d).Table("user").Value(Values{"n": 1}).Where("id = 1").Exec(); ret.Err != nil {
+		t.Fatal(ret.Err)
+	}
+	// 未使用查询缓存时写入不更新表令牌
+	if _, ok := Cache.Lookup("unused-test\x00user", tableTokenGroup); ok {
+		t.Fatal("table token should not be written when query caching is unused")
+	}
+
+	Select("n").DB(d).Table("user").Cache(time.Minute).Query()
+	if !queryCacheInUse() {
+		t.Fatal("cached query should mark query caching as used")
+	}
+	token, _ := Cache.Lookup("unused-test\x00user", tableTokenGroup)
+	Update().DB(d).Table("user").Value(Values{"n": 2}).Where("id = 1").Exec()
+	if now, _ := Cache.Lookup("unused-test\x00user", tableTokenGroup); reflect.DeepEqual(now, token) {
+		t.Fatal("table token should change after a write")
+	}
+}
+
+func TestQueryCacheSingleflight(t *testing.T) {
+	d, srv := newTestDB(t)
+	var calls int32
+	release := make(chan struct{})
+	srv.handle = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []string{"n"}, [][]driver.Value{{int64(7)}}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]map[string]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = d.SelectCached(time.Minute, "SELECT n FROM counter")
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("query executed %d times, want 1", n)
+	}
+	for _, ret := range results {
+		if len(ret) != 1 || ret[0]["n"] != "7" {
+			t.Fatalf("results = %v", results)
+		}
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		g.do("k", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err := g.do("k", func() (interface{}, error) { return nil, errors.New("not shared") })
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	// 等待者得到错误, 执行者继续panic
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("err = %v", err)
+	}
+	if r := <-panicked; r != "boom" {
+		t.Fatalf("recovered %v", r)
+	}
+	if v, err := g.do("k", func() (interface{}, error) { return 1, nil }); v != 1 || err != nil {
+		t.Fatal("key should be released after panic")
+	}
+}
@@ -0,0 +1,796 @@
+package db
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueClosed  = errors.New("sql queue is closed")             // 队列已关闭
+	ErrQueueFull    = errors.New("sql queue is full")               // 队列已满
+	ErrQueueDropped = errors.New("sql queue item dropped by newer") // 队列已满时被新语句挤出
+)
+
+// 队列已满时的处理方式
+type Overflow int
+
+const (
+	OverflowBlock      Overflow = iota // 阻塞直到队列有空间
+	OverflowDropOldest                 // 丢弃最早入队的语句
+	OverflowReject                     // 拒绝新的语句
+)
+
+// 队列语句的优先级, 优先级高的语句先执行
+type QueuePriority int
+
+const (
+	PriorityLow    QueuePriority = -1 // 低优先级, 如批量统计数据
+	PriorityNormal QueuePriority = 0  // 默认优先级
+	PriorityHigh   QueuePriority = 1  // 高优先级, 如关键数据的写入
+)
+
+// 队列选项
+type QueueOptions struct {
+	Workers      int                              // 并发执行的协程数, 默认为1; 大于1时不再保证执行顺序
+	Capacity     int                              // 队列容量, 0表示不限制
+	Overflow     Overflow                         // 队列已满时的处理方式
+	ErrorHandler func(item *QueueItem, err error) // 执行失败时的处理函数, 为nil时使用 SetQueueErrorHandler 设置的全局处理函数
+	BatchSize    int                              // 合并执行的最大语句数, 小于等于1时不合并; 队列头部连续的同类单行插入语句合并为一条多行插入语句
+	BatchWindow  time.Duration                    // 合并执行时等待更多语句入队的最长时间, 0表示不等待
+	BatchTx      bool                             // 是否将队列头部连续的多条语句(最多 BatchSize 条)放在同一事务中执行
+	Retry        *RetryPolicy                     // 执行失败时的重试策略, 为nil时不重试; 重试的语句按退避时间延迟后重新入队
+	DeadLetters  int                              // 死信列表的最大长度, 0表示使用默认值1000, 小于0表示不保留
+}
+
+// SQL异步执行队列定义
+type queueList struct {
+	db             *Database
+	opts           QueueOptions
+	list           []*QueueItem //队列列表
+	lock           sync.Mutex
+	notEmpty       *sync.Cond
+	notFull        *sync.Cond
+	gen            int                   //执行协程的代数, 改变时旧的执行协程退出
+	running        bool                  //执行协程是否在运行
+	closed         bool                  //是否已关闭, 关闭后不再接受新的语句
+	active         int                   //已出栈但未执行完成的语句数
+	workers        sync.WaitGroup        //执行协程
+	journal        *queueJournal         //预写日志, 为nil时不记录
+	journalOpening bool                  //是否正在打开预写日志
+	keyed          map[string]*QueueItem //等待执行的按键合并的语句
+	delayed        delayHeap             //延迟执行的语句
+	timer          *time.Timer           //延迟语句到期的定时器
+	seq            uint64                //入队序号
+	dead           deadLetters           //死信列表
+	retried        int64                 //重试的次数
+	succeeded      int64                 //执行成功的语句数
+	failed         int64                 //执行失败的语句数
+	dropped        int64                 //被挤出的语句数
+	rejected       int64                 //被拒绝的语句数
+}
+
+// SQL异步执行队列子元素定义
+type QueueItem struct {
+	DB        *Database              //数据库对象
+	Query     string                 //SQL语句字符串
+	Params    []interface{}          //参数列表
+	Callback  func(res *QueueResult) //执行完成后的回调, 可为nil
+	Key       string                 //合并的键, 不为空时覆盖相同键的未执行语句
+	Priority  QueuePriority          //优先级
+	At        time.Time              //计划执行时间, 为零值或已过去时立即执行
+	caller    string                 //入队时的调用位置
+	ctx       context.Context        //入队时的上下文, 只保留其中的值, 用于追踪及SQL注释
+	future    *QueueFuture           //执行结果凭证
+	journalID uint64                 //预写日志中的条目ID
+	merged    []*QueueItem           //被本语句覆盖的语句
+	attempts  int                    //已执行失败的次数
+	seq       uint64                 //入队序号, 用于相同执行时间的延迟语句排序
+}
+
+// 异步队列语句的执行结果
+type QueueResult struct {
+	LastID   int64 // 最后生成的自增ID
+	Affected int64 // 受影响的行数
+	Err      error // 执行错误
+	Batched  int   // 合并执行的语句数, 未合并时为1
+}
+
+// 异步队列语句的执行凭证, 可用于等待语句执行完成
+type QueueFuture struct {
+	done   chan struct{}
+	result QueueResult
+}
+
+func newQueueFuture() *QueueFuture {
+	return &QueueFuture{done: make(chan struct{})}
+}
+
+// 完成执行
+func (f *QueueFuture) complete(res QueueResult) {
+	f.result = res
+	close(f.done)
+}
+
+// 执行完成时关闭的通道
+func (f *QueueFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// 等待语句执行完成, 返回最后生成的自增ID、受影响的行数及错误
+func (f *QueueFuture) Wait() (int64, int64, error) {
+	<-f.done
+	return f.result.LastID, f.result.Affected, f.result.Err
+}
+
+// 等待语句执行完成, 上下文结束时返回上下文的错误
+func (f *QueueFuture) WaitContext(ctx context.Context) (int64, int64, error) {
+	select {
+	case <-f.done:
+		return f.result.LastID, f.result.Affected, f.result.Err
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	}
+}
+
+// 队列执行统计
+type QueueStats struct {
+	Pending   int64 // 等待执行的语句数
+	Active    int64 // 正在执行的语句数
+	Succeeded int64 // 执行成功的语句数
+	Failed    int64 // 执行失败的语句数
+	Dropped   int64 // 队列已满时被挤出的语句数
+	Rejected  int64 // 队列已满或已关闭时被拒绝的语句数
+	Delayed   int64 // 等待计划执行时间或重试的语句数
+	Retried   int64 // 重试的次数
+	Dead      int64 // 死信列表中的语句数
+}
+
+var (
+	queueErrorHandler     func(item *QueueItem, err error)
+	queueErrorHandlerLock sync.RWMutex
+
+	// 所有已创建的队列
+	allQueues     []*queueList
+	allQueuesLock sync.Mutex
+)
+
+// 设置队列语句执行失败时的全局处理函数
+func SetQueueErrorHandler(fn func(item *QueueItem, err error)) {
+	queueErrorHandlerLock.Lock()
+	queueErrorHandler = fn
+	queueErrorHandlerLock.Unlock()
+}
+
+// 创建队列
+func newQueueList(db *Database, opts QueueOptions) *queueList {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	q := &queueList{db: db, opts: opts, keyed: make(map[string]*QueueItem)}
+	q.notEmpty = sync.NewCond(&q.lock)
+	q.notFull = sync.NewCond(&q.lock)
+	return q
+}
+
+// 获取数据库的队列, 第一次使用时创建并开始执行
+func (this *Database) getQueue() *queueList {
+	this.queueLock.Lock()
+	defer this.queueLock.Unlock()
+	if this.queue == nil {
+		this.queue = newQueueList(this, this.queueOptions)
+		this.queue.Start()
+		allQueuesLock.Lock()
+		allQueues = append(allQueues, this.queue)
+		allQueuesLock.Unlock()
+	}
+	return this.queue
+}
+
+// 获取数据库已创建的队列, 未使用过队列时返回nil
+func (this *Database) currentQueue() *queueList {
+	this.queueLock.Lock()
+	defer this.queueLock.Unlock()
+	return this.queue
+}
+
+// 关闭数据库的队列并从所有队列中移除, 等待队列中的语句执行完成
+func (this *Database) closeQueue() {
+	q := this.currentQueue()
+	if q == nil {
+		return
+	}
+	q.Shutdown(context.Background())
+	q.closeJournal()
+	allQueuesLock.Lock()
+	for i, it := range allQueues {
+		if it == q {
+			allQueues = append(allQueues[:i], allQueues[i+1:]...)
+			break
+		}
+	}
+	allQueuesLock.Unlock()
+}
+
+// 获取所有已创建的队列
+func getAllQueues() []*queueList {
+	allQueuesLock.Lock()
+	defer allQueuesLock.Unlock()
+	return append([]*queueList(nil), allQueues...)
+}
+
+// 设置队列选项, 队列正在执行时将等待正在执行的语句完成后以新的选项重新开始
+func (this *Database) SetQueueOptions(opts QueueOptions) {
+	this.queueLock.Lock()
+	this.queueOptions = opts
+	q := this.queue
+	this.queueLock.Unlock()
+	if q == nil {
+		return
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	q.lock.Lock()
+	running := q.running
+	q.lock.Unlock()
+	q.Stop()
+	q.lock.Lock()
+	q.opts = opts
+	q.notFull.Broadcast()
+	q.lock.Unlock()
+	if running {
+		q.Start()
+	}
+}
+
+// 队列入栈
+// 队列已满时按 Overflow 选项处理; 队列关闭时语句将以 ErrQueueClosed 错误结束;
+// 开启预写日志时先在锁外写入日志, 写入失败的语句以该错误结束, 不会入队
+func (this *queueList) Push(item *QueueItem) {
+	this.lock.Lock()
+	j := this.journal
+	this.lock.Unlock()
+	journaled := false
+	if j != nil && item.journalID == 0 {
+		if err := j.append(item); err == nil {
+			journaled = true
+		} else if err != errJournalClosed {
+			this.lock.Lock()
+			this.rejected++
+			this.lock.Unlock()
+			this.reject(item, fmt.Errorf("sql queue journal: %w", err))
+			return
+		}
+	}
+	// 未入队的语句不需要重放
+	discard := func(err error) {
+		if journaled {
+			if err := j.markDone(item.journalID); err != nil {
+				logWari("队列日志标记完成失败: ", err)
+			}
+		}
+		this.reject(item, err)
+	}
+
+	this.lock.Lock()
+	delayed := item.At.After(time.Now())
+	for !delayed && !this.closed && this.opts.Capacity > 0 && len(this.list) >= this.opts.Capacity {
+		switch this.opts.Overflow {
+		case OverflowDropOldest:
+			old := this.dropOldest()
+			this.dropped++
+			this.lock.Unlock()
+			this.finish(old, QueueResult{Err: ErrQueueDropped})
+			this.lock.Lock()
+		case OverflowReject:
+			this.rejected++
+			this.lock.Unlock()
+			discard(ErrQueueFull)
+			return
+		default:
+			this.notFull.Wait()
+		}
+	}
+	if this.closed {
+		this.rejected++
+		this.lock.Unlock()
+		discard(ErrQueueClosed)
+		return
+	}
+	if item.Key != "" {
+		// 覆盖相同键的未执行语句, 保留其在队列中的位置
+		if old, ok := this.keyed[item.Key]; ok {
+			old.Query, old.Params = item.Query, item.Params
+			old.merged = append(old.merged, item)
+			this.lock.Unlock()
+			return
+		}
+		this.keyed[item.Key] = item
+	}
+	if delayed {
+		this.delay(item)
+	} else {
+		this.insert(item)
+		this.notEmpty.Signal()
+	}
+	this.lock.Unlock()
+}
+
+// 按优先级插入队列, 相同优先级的语句按入队顺序执行, 调用时需持有锁
+func (this *queueList) insert(item *QueueItem) {
+	i := len(this.list)
+	for i > 0 && this.list[i-1].Priority < item.Priority {
+		i--
+	}
+	this.list = append(this.list, nil)
+	copy(this.list[i+1:], this.list[i:])
+	this.list[i] = item
+}
+
+// 挤出优先级最低的语句中最早入队的语句, 调用时需持有锁
+func (this *queueList) dropOldest() *QueueItem {
+	i := len(this.list) - 1
+	for i > 0 && this.list[i-1].Priority == this.list[i].Priority {
+		i--
+	}
+	item := this.list[i]
+	this.list = append(this.list[:i], this.list[i+1:]...)
+	if item.Key != "" && this.keyed[item.Key] == item {
+		delete(this.keyed, item.Key)
+	}
+	return item
+}
+
+// 加入延迟执行的语句, 调用时需持有锁
+func (this *queueList) delay(item *QueueItem) {
+	this.seq++
+	item.seq = this.seq
+	heap.Push(&this.delayed, item)
+	this.resetTimer()
+}
+
+// 重置定时器为最早到期的延迟语句的时间, 调用时需持有锁
+func (this *queueList) resetTimer() {
+	if len(this.delayed) == 0 {
+		return
+	}
+	d := time.Until(this.delayed[0].At)
+	if this.timer == nil {
+		this.timer = time.AfterFunc(d, this.promote)
+	} else {
+		this.timer.Reset(d)
+	}
+}
+
+// 将到期的延迟语句移入队列
+func (this *queueList) promote() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := time.Now()
+	n := 0
+	for len(this.delayed) > 0 && !this.delayed[0].At.After(now) {
+		this.insert(heap.Pop(&this.delayed).(*QueueItem))
+		n++
+	}
+	if n > 0 {
+		this.notEmpty.Broadcast()
+	}
+	this.resetTimer()
+}
+
+// 延迟语句堆, 按计划执行时间排序
+type delayHeap []*QueueItem
+
+func (h delayHeap) Len() int { return len(h) }
+func (h delayHeap) Less(i, j int) bool {
+	if h[i].At.Equal(h[j].At) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].At.Before(h[j].At)
+}
+func (h delayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *delayHeap) Push(x interface{}) { *h = append(*h, x.(*QueueItem)) }
+func (h *delayHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// 开始执行队列, 队列已关闭时重新开启
+func (this *queueList) Start() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.closed = false
+	if this.running {
+		return
+	}
+	this.running = true
+	this.gen++
+	for i := 0; i < this.opts.Workers; i++ {
+		this.workers.Add(1)
+		go this.worker(this.gen)
+	}
+}
+
+// 执行协程
+func (this *queueList) worker(gen int) {
+	defer this.workers.Done()
+	for {
+		this.lock.Lock()
+		for len(this.list) == 0 && this.gen == gen {
+			this.notEmpty.Wait()
+		}
+		if this.gen != gen {
+			this.lock.Unlock()
+			return
+		}
+		this.active++
+		runs := this.popBatch(gen)
+		n := 0
+		for _, run := range runs {
+			n += len(run.items)
+		}
+		this.active += n - 1
+		this.notFull.Broadcast()
+		this.lock.Unlock()
+
+		this.executeBatch(runs)
+
+		this.lock.Lock()
+		this.active -= n
+		this.lock.Unlock()
+	}
+}
+
+// 取出队列头部的语句, 调用时需持有锁
+func (this *queueList) popFront() *QueueItem {
+	item := this.list[0]
+	this.list[0] = nil
+	this.list = this.list[1:]
+	if item.Key != "" && this.keyed[item.Key] == item {
+		delete(this.keyed, item.Key)
+	}
+	return item
+}
+
+// 通知执行协程退出
+func (this *queueList) stopWorkers() {
+	this.lock.Lock()
+	this.running = false
+	this.gen++
+	this.notEmpty.Broadcast()
+	this.lock.Unlock()
+}
+
+// 停止队列, 等待正在执行的语句完成, 未执行的语句仍保留在队列中
+func (this *queueList) Stop() {
+	this.stopWorkers()
+	this.workers.Wait()
+}
+
+// 执行语句时使用的上下文, 从预写日志或死信恢复的语句使用空上下文
+func (item *QueueItem) context() context.Context {
+	if item.ctx == nil {
+		return context.Background()
+	}
+	return item.ctx
+}
+
+// 执行队列中的语句并通知结果
+func (this *queueList) execute(item *QueueItem) {
+	res := QueueResult{Batched: 1}
+	ret, err := item.DB.ExecContext(withQueued(item.context(), item.caller), item.Query, item.Params...)
+	res.LastID, res.Affected, res.Err = execResult(ret, err)
+	this.complete(item, res)
+}
+
+// 记录执行结果并通知
+// 执行失败时按重试策略延迟后重新入队, 不再重试的语句加入死信列表
+func (this *queueList) complete(item *QueueItem, res QueueResult) {
+	if res.Err != nil {
+		item.attempts++
+		if this.retry(item, res.Err) {
+			return
+		}
+		atomic.AddInt64(&this.failed, 1)
+		this.handleError(item, res.Err)
+		this.dead.add(this.opts.DeadLetters, item, res.Err)
+	} else {
+		atomic.AddInt64(&this.succeeded, 1)
+	}
+	this.finish(item, res)
+}
+
+// 按重试策略重新安排执行失败的语句, 返回是否已安排重试
+// 队列中的语句不是幂等的, 连接丢失时不会重试; 按键合并的语句重试前可以被相同键的新语句覆盖
+func (this *queueList) retry(item *QueueItem, err error) bool {
+	p := this.opts.Retry
+	if p == nil || item.attempts >= p.MaxAttempts || !p.retryable(err, false) {
+		return false
+	}
+	delay := p.backoff(item.attempts)
+	if p.OnRetry != nil {
+		p.OnRetry(item.attempts+1, err, delay)
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return false
+	}
+	if item.Key != "" {
+		// 执行期间入队了相同键的新语句时, 由新语句代替重试
+		if newer, ok := this.keyed[item.Key]; ok {
+			newer.merged = append(append(newer.merged, item), item.merged...)
+			item.merged = nil
+			return true
+		}
+		this.keyed[item.Key] = item
+	}
+	item.At = time.Now().Add(delay)
+	this.delay(item)
+	this.retried++
+	return true
+}
+
+// 调用执行失败的处理函数
+func (this *queueList) handleError(item *QueueItem, err error) {
+	handler := this.opts.ErrorHandler
+	if handler == nil {
+		queueErrorHandlerLock.RLock()
+		handler = queueErrorHandler
+		queueErrorHandlerLock.RUnlock()
+	}
+	if handler != nil {
+		handler(item, err)
+	}
+}
+
+// 语句结束, 标记预写日志并通知结果
+// 覆盖的语句一同结束
+func (this *queueList) finish(item *QueueItem, res QueueResult) {
+	this.lock.Lock()
+	j := this.journal
+	this.lock.Unlock()
+	for _, it := range append([]*QueueItem{item}, item.merged...) {
+		if it.journalID != 0 && j != nil {
+			if err := j.markDone(it.journalID); err != nil {
+				logWari("队列日志标记完成失败: ", err)
+			}
+		}
+		r := res
+		if it.Callback != nil {
+			it.Callback(&r)
+		}
+		if it.future != nil {
+			it.future.complete(r)
+		}
+	}
+}
+
+// 以错误结束未执行的语句, 预写日志中的条目将被保留
+func (this *queueList) reject(item *QueueItem, err error) {
+	for _, it := range append([]*QueueItem{item}, item.merged...) {
+		res := QueueResult{Err: err}
+		if it.Callback != nil {
+			it.Callback(&res)
+		}
+		if it.future != nil {
+			it.future.complete(res)
+		}
+	}
+}
+
+// 队列是否已执行完所有语句
+func (this *queueList) idle() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.list) == 0 && this.active == 0
+}
+
+// 获取队列执行统计
+func (this *queueList) Stats() QueueStats {
+	this.lock.Lock()
+	pending, active, delayed := int64(len(this.list)), int64(this.active), int64(len(this.delayed))
+	dropped, rejected, retried := this.dropped, this.rejected, this.retried
+	this.lock.Unlock()
+	return QueueStats{
+		Pending:   pending,
+		Active:    active,
+		Succeeded: atomic.LoadInt64(&this.succeeded),
+		Failed:    atomic.LoadInt64(&this.failed),
+		Dropped:   dropped,
+		Rejected:  rejected,
+		Delayed:   delayed,
+		Retried:   retried,
+		Dead:      int64(this.dead.len()),
+	}
+}
+
+// 关闭队列并等待队列中的语句执行完成
+// 上下文结束时停止执行, 返回未执行的语句及上下文的错误, 未执行的语句以 ErrQueueClosed 错误结束;
+// 尚未到期的延迟语句及等待重试的语句不会等待, 同样作为未执行的语句返回;
+// 开启了预写日志时, 未执行的语句仍保留在日志中, 下次开启日志时会重放
+func (this *queueList) Shutdown(ctx context.Context) ([]*QueueItem, error) {
+	this.lock.Lock()
+	this.closed = true
+	this.notFull.Broadcast()
+	this.notEmpty.Broadcast()
+	this.lock.Unlock()
+
+	var err error
+	ticker := time.NewTicker(10 * time.Millisecond)
+wait:
+	for !this.idle() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break wait
+		case <-ticker.C:
+		}
+	}
+	ticker.Stop()
+
+	this.stopWorkers()
+	if err == nil {
+		this.workers.Wait()
+	}
+
+	this.lock.Lock()
+	left := this.list
+	for len(this.delayed) > 0 {
+		left = append(left, heap.Pop(&this.delayed).(*QueueItem))
+	}
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+	this.list = nil
+	this.keyed = make(map[string]*QueueItem)
+	this.lock.Unlock()
+	for _, item := range left {
+		this.reject(item, ErrQueueClosed)
+	}
+	return left, err
+}
+
+// 向Sql队列中插入一条执行语句, 可通过返回的 QueueFuture 等待执行结果
+func (this *Database) Queue(query string, args ...interface{}) *QueueFuture {
+	return this.Enqueue(&QueueItem{Query: query, Params: args})
+}
+
+// 向Sql队列中插入一条执行语句, 执行时沿用 ctx 中的追踪跨度及SQL注释标签, 不随 ctx 取消
+func (this *Database) QueueContext(ctx context.Context, query string, args ...interface{}) *QueueFuture {
+	return this.EnqueueContext(ctx, &QueueItem{Query: query, Params: args})
+}
+
+// 向Sql队列中插入一条执行语句, 执行完成后调用callback
+func (this *Database) QueueFunc(callback func(res *QueueResult), query string, args ...interface{}) *QueueFuture {
+	return this.Enqueue(&QueueItem{Query: query, Params: args, Callback: callback})
+}
+
+// 以指定的优先级向Sql队列中插入一条执行语句
+func (this *Database) QueuePriority(priority QueuePriority, query string, args ...interface{}) *QueueFuture {
+	return this.Enqueue(&QueueItem{Query: query, Params: args, Priority: priority})
+}
+
+// 向Sql队列中插入一条在指定时间执行的语句
+func (this *Database) QueueAt(at time.Time, query string, args ...interface{}) *QueueFuture {
+	return this.Enqueue(&QueueItem{Query: query, Params: args, At: at})
+}
+
+// 向Sql队列中插入一条在指定时间之后执行的语句
+func (this *Database) QueueAfter(d time.Duration, query string, args ...interface{}) *QueueFuture {
+	return this.Enqueue(&QueueItem{Query: query, Params: args, At: time.Now().Add(d)})
+}
+
+// 向Sql队列中插入一条执行语句, 可同时设置回调、合并的键、优先级及计划执行时间
+func (this *Database) Enqueue(item *QueueItem) *QueueFuture {
+	return this.EnqueueContext(context.Background(), item)
+}
+
+// 向Sql队列中插入一条执行语句, 参见 QueueContext
+func (this *Database) EnqueueContext(ctx context.Context, item *QueueItem) *QueueFuture {
+	item.DB = this
+	item.ctx = detachedContext{ctx}
+	item.caller = callerLocation()
+	item.future = newQueueFuture()
+	this.getQueue().Push(item)
+	return item.future
+}
+
+// 获取数据库队列的执行统计
+func (this *Database) QueueStats() QueueStats {
+	if q := this.currentQueue(); q != nil {
+		return q.Stats()
+	}
+	return QueueStats{}
+}
+
+// 关闭数据库的队列, 参见 QueueShutdown
+func (this *Database) QueueShutdown(ctx context.Context) ([]*QueueItem, error) {
+	if q := this.currentQueue(); q != nil {
+		return q.Shutdown(ctx)
+	}
+	return nil, nil
+}
+
+// 开启数据库的队列, 用于 QueueShutdown 之后重新开启
+func (this *Database) QueueStart() {
+	this.getQueue().Start()
+}
+
+// 获取所有队列的执行统计之和
+func GetQueueStats() QueueStats {
+	var stats QueueStats
+	for _, q := range getAllQueues() {
+		s := q.Stats()
+		stats.Pending += s.Pending
+		stats.Active += s.Active
+		stats.Succeeded += s.Succeeded
+		stats.Failed += s.Failed
+		stats.Dropped += s.Dropped
+		stats.Rejected += s.Rejected
+		stats.Delayed += s.Delayed
+		stats.Retried += s.Retried
+		stats.Dead += s.Dead
+	}
+	return stats
+}
+
+// 关闭所有SQL队列, 不再接受新的语句, 并在上下文结束前执行完队列中的语句
+// 返回未能执行的语句, 可以在 QueueStart 之后通过 Requeue 重新入队
+func QueueShutdown(ctx context.Context) ([]*QueueItem, error) {
+	var (
+		wg    sync.WaitGroup
+		lock  sync.Mutex
+		left  []*QueueItem
+		first error
+	)
+	for _, q := range getAllQueues() {
+		wg.Add(1)
+		go func(q *queueList) {
+			defer wg.Done()
+			items, err := q.Shutdown(ctx)
+			lock.Lock()
+			left = append(left, items...)
+			if first == nil {
+				first = err
+			}
+			lock.Unlock()
+		}(q)
+	}
+	wg.Wait()
+	return left, first
+}
+
+// 开启所有SQL队列, 用于 QueueShutdown 之后重新开启
+func QueueStart() {
+	for _, q := range getAllQueues() {
+		q.Start()
+	}
+}
+
+// 将未执行的语句重新入队, 返回新的执行凭证
+func Requeue(item *QueueItem) *QueueFuture {
+	n := &QueueItem{
+		DB:        item.DB,
+		Query:     item.Query,
+		Params:    item.Params,
+		Callback:  item.Callback,
+		Key:       item.Key,
+		Priority:  item.Priority,
+		At:        item.At,
+		caller:    item.caller,
+		ctx:       item.ctx,
+		future:    newQueueFuture(),
+		journalID: item.journalID,
+	}
+	item.DB.getQueue().Push(n)
+	return n.future
+}
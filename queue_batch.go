@@ -0,0 +1,228 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// 可合并的单行插入语句结构
+// 例: INSERT INTO hits (a, b) VALUES (?, ?) 可与同样的语句合并为 INSERT INTO hits (a, b) VALUES (?, ?),(?, ?)
+type insertShape struct {
+	head  string // 行数据之前的部分, 包含 VALUES
+	tuple string // 单行的占位符
+	tail  string // 行数据之后的部分, 如 ON DUPLICATE KEY UPDATE ...
+	plain bool   // 是否为普通的INSERT, 普通INSERT可以按顺序推算每行的自增ID
+}
+
+// 解析单行插入语句, 只有全部使用占位符的单行 INSERT/REPLACE 语句才能合并
+func parseInsertShape(query string, nargs int) (*insertShape, bool) {
+	q := strings.TrimSpace(query)
+	upper := strings.ToUpper(q)
+	if !strings.HasPrefix(upper, "INSERT ") && !strings.HasPrefix(upper, "REPLACE ") {
+		return nil, false
+	}
+	idx := strings.Index(upper, "VALUES")
+	if idx < 0 {
+		return nil, false
+	}
+	head := q[:idx+len("VALUES")]
+	rest := q[idx+len("VALUES"):]
+	start := strings.IndexByte(rest, '(')
+	if start < 0 || strings.TrimSpace(rest[:start]) != "" {
+		return nil, false
+	}
+	end := strings.IndexByte(rest, ')')
+	if end < start {
+		return nil, false
+	}
+	tuple := rest[start : end+1]
+	tail := rest[end+1:]
+	if strings.ContainsAny(head, "?'\"") || strings.Contains(tail, "?") || strings.Contains(strings.TrimSpace(tail), ";") {
+		return nil, false
+	}
+	holders := 0
+	for _, c := range tuple[1 : len(tuple)-1] {
+		switch c {
+		case '?':
+			holders++
+		case ',', ' ', '\t', '\r', '\n':
+		default:
+			return nil, false
+		}
+	}
+	if holders == 0 || holders != nargs {
+		return nil, false
+	}
+	return &insertShape{
+		head:  head,
+		tuple: tuple,
+		tail:  tail,
+		plain: strings.HasPrefix(upper, "INSERT ") && !strings.Contains(strings.ToUpper(head), " IGNORE ") && strings.TrimSpace(tail) == "",
+	}, true
+}
+
+// 合并执行的语句
+type queueRun struct {
+	items []*QueueItem
+	shape *insertShape
+}
+
+// 取出一批语句, 调用时需持有锁, 返回时仍持有锁
+// 未开启合并时只取出一条语句; 开启合并时将队列头部连续的同类插入语句合并,
+// 不足 BatchSize 时最多等待 BatchWindow 收集更多的语句; BatchTx 时取出头部连续的多条任意语句在同一事务中执行
+func (this *queueList) popBatch(gen int) []*queueRun {
+	first := this.popFront()
+	if this.opts.BatchSize <= 1 {
+		return []*queueRun{{items: []*QueueItem{first}}}
+	}
+
+	runs := []*queueRun{this.newRun(first)}
+	count := 1
+	deadline := time.Now().Add(this.opts.BatchWindow)
+	for count < this.opts.BatchSize {
+		if len(this.list) == 0 && !this.waitBatch(gen, deadline) {
+			break
+		}
+		next := this.list[0]
+		last := runs[len(runs)-1]
+		if last.shape != nil && next.Key == "" && next.Query == last.items[0].Query && len(next.Params) == len(last.items[0].Params) {
+			last.items = append(last.items, this.popFront())
+		} else if this.opts.BatchTx {
+			runs = append(runs, this.newRun(this.popFront()))
+		} else {
+			break
+		}
+		count++
+	}
+	return runs
+}
+
+// 等待更多的语句入队, 调用时需持有锁, 返回队列中是否有可取出的语句
+// 到达截止时间、队列关闭或执行协程被停止时立即返回
+func (this *queueList) waitBatch(gen int, deadline time.Time) bool {
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return false
+	}
+	timer := time.AfterFunc(wait, func() {
+		this.lock.Lock()
+		this.notEmpty.Broadcast()
+		this.lock.Unlock()
+	})
+	defer timer.Stop()
+	for len(this.list) == 0 && !this.closed && this.gen == gen && time.Now().Before(deadline) {
+		this.notEmpty.Wait()
+	}
+	return len(this.list) > 0 && this.gen == gen
+}
+
+// 创建合并执行的语句
+func (this *queueList) newRun(item *QueueItem) *queueRun {
+	run := &queueRun{items: []*QueueItem{item}}
+	if item.Key == "" {
+		run.shape, _ = parseInsertShape(item.Query, len(item.Params))
+	}
+	return run
+}
+
+// 合并后的语句及参数
+func (run *queueRun) statement() (string, []interface{}) {
+	if len(run.items) == 1 {
+		return run.items[0].Query, run.items[0].Params
+	}
+	s := strings.Builder{}
+	s.WriteString(run.shape.head)
+	s.WriteString(" ")
+	args := make([]interface{}, 0, len(run.items)*len(run.items[0].Params))
+	for i, item := range run.items {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(run.shape.tuple)
+		args = append(args, item.Params...)
+	}
+	s.WriteString(run.shape.tail)
+	return s.String(), args
+}
+
+// 拆分合并执行的结果
+// 普通INSERT按顺序推算每行的自增ID(要求 auto_increment_increment 为1), 受影响的行数为1;
+// 其他语句的自增ID为0, 受影响的行数为整批的行数
+func (run *queueRun) results(lastID, affected int64, err error) []QueueResult {
+	ret := make([]QueueResult, len(run.items))
+	for i := range run.items {
+		ret[i].Err = err
+		ret[i].Batched = len(run.items)
+		if err != nil {
+			continue
+		}
+		switch {
+		case len(run.items) == 1:
+			ret[i].LastID, ret[i].Affected = lastID, affected
+		case run.shape.plain:
+			ret[i].LastID, ret[i].Affected = lastID+int64(i), 1
+		default:
+			ret[i].Affected = affected
+		}
+	}
+	return ret
+}
+
+// 执行一批语句
+func (this *queueList) executeBatch(runs []*queueRun) {
+	if len(runs) == 1 && len(runs[0].items) == 1 {
+		this.execute(runs[0].items[0])
+		return
+	}
+	// 合并执行的语句沿用第一条语句入队时的上下文
+	ctx := withQueued(runs[0].items[0].context(), runs[0].items[0].caller)
+	results := make([][]QueueResult, len(runs))
+
+	if len(runs) == 1 {
+		query, args := runs[0].statement()
+		ret, err := this.db.ExecContext(ctx, query, args...)
+		results[0] = runs[0].results(execResult(ret, err))
+	} else {
+		err := this.db.TransactionContext(ctx, nil, func(tx *Tx) error {
+			for i, run := range runs {
+				query, args := run.statement()
+				ret, err := tx.ExecContext(ctx, query, args...)
+				if err != nil {
+					return err
+				}
+				results[i] = run.results(execResult(ret, nil))
+			}
+			return nil
+		})
+		if err != nil {
+			for i, run := range runs {
+				results[i] = run.results(0, 0, err)
+			}
+		}
+	}
+
+	for i, run := range runs {
+		for k, item := range run.items {
+			this.complete(item, results[i][k])
+		}
+	}
+}
+
+// 获取执行结果中的自增ID及受影响的行数
+func execResult(ret interface {
+	LastInsertId() (int64, error)
+	RowsAffected() (int64, error)
+}, err error) (int64, int64, error) {
+	if err != nil {
+		return 0, 0, err
+	}
+	lastID, _ := ret.LastInsertId()
+	affected, _ := ret.RowsAffected()
+	return lastID, affected, nil
+}
+
+// 按相同的键合并更新语句, 后入队的语句覆盖先入队但尚未执行的语句
+// 被覆盖的语句与覆盖它的语句得到相同的执行结果
+func (this *Database) QueueKeyed(key string, query string, args ...interface{}) *QueueFuture {
+	return this.Enqueue(&QueueItem{Query: query, Params: args, Key: key})
+}
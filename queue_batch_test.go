@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseInsertShape(t *testing.T) {
+	cases := []struct {
+		query string
+		nargs int
+		ok    bool
+		plain bool
+	}{
+		{"INSERT INTO t (a, b) VALUES (?, ?)", 2, true, true},
+		{"  insert into t (a) values(?)", 1, true, true},
+		{"REPLACE INTO t (a) VALUES (?)", 1, true, false},
+		{"INSERT IGNORE INTO t (a) VALUES (?)", 1, true, false},
+		{"INSERT INTO t (a) VALUES (?) ON DUPLICATE KEY UPDATE a = VALUES(a)", 1, true, false},
+		{"INSERT INTO t (a, b) VALUES (?, ?)", 1, false, false},
+		{"INSERT INTO t (a, b) VALUES (?, 1)", 1, false, false},
+		{"INSERT INTO t (a) VALUES (?) ON DUPLICATE KEY UPDATE a = ?", 2, false, false},
+		{"INSERT INTO t (a) VALUES (?); DELETE FROM t", 1, false, false},
+		{"INSERT INTO t (a) SELECT a FROM s WHERE id = ?", 1, false, false},
+		{"UPDATE t SET a = ?", 1, false, false},
+	}
+	for _, c := range cases {
+		shape, ok := parseInsertShape(c.query, c.nargs)
+		if ok != c.ok {
+			t.Errorf("parseInsertShape(%q) ok = %v", c.query, ok)
+			continue
+		}
+		if ok && shape.plain != c.plain {
+			t.Errorf("parseInsertShape(%q) plain = %v", c.query, shape.plain)
+		}
+	}
+}
+
+func TestQueueBatchInsert(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{BatchSize: 10})
+	srv.lastID, srv.affected = 100, 3
+	g := gateQueue(d)
+	defer g.release()
+
+	d.Queue("UPDATE t SET a = 0")
+	g.wait(t)
+	var futures []*QueueFuture
+	for i := 1; i <= 3; i++ {
+		futures = append(futures, d.Queue("INSERT INTO t (a, b) VALUES (?, ?)", i, "x"))
+	}
+	g.release()
+
+	// 普通INSERT按顺序推算每行的自增ID
+	for i, f := range futures {
+		id, affected, err := waitFuture(t, f)
+		if err != nil || id != 100+int64(i) || affected != 1 {
+			t.Fatalf("item %d = %d, %d, %v", i, id, affected, err)
+		}
+	}
+	q := srv.queries()
+	if len(q) != 2 || q[1] != "INSERT INTO t (a, b) VALUES (?, ?),(?, ?),(?, ?)" {
+		t.Fatalf("queries = %q", q)
+	}
+	want := []driver.Value{int64(1), "x", int64(2), "x", int64(3), "x"}
+	if !reflect.DeepEqual(srv.args(1), want) {
+		t.Fatalf("args = %v", srv.args(1))
+	}
+}
+
+func TestQueueBatchUpsert(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{BatchSize: 10})
+	srv.lastID, srv.affected = 100, 4
+	g := gateQueue(d)
+	defer g.release()
+
+	d.Queue("UPDATE t SET a = 0")
+	g.wait(t)
+	query := "INSERT INTO t (a) VALUES (?) ON DUPLICATE KEY UPDATE a = VALUES(a)"
+	f1 := d.Queue(query, 1)
+	f2 := d.Queue(query, 2)
+	g.release()
+
+	// 非普通INSERT不推算自增ID, 受影响的行数为整批的行数
+	for _, f := range []*QueueFuture{f1, f2} {
+		if id, affected, err := waitFuture(t, f); err != nil || id != 0 || affected != 4 {
+			t.Fatalf("result = %d, %d, %v", id, affected, err)
+		}
+	}
+	if q := srv.queries(); len(q) != 2 {
+		t.Fatalf("queries = %q", q)
+	}
+}
+
+func TestQueueBatchParamsMismatch(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{BatchSize: 10})
+	g := gateQueue(d)
+	defer g.release()
+
+	d.Queue("UPDATE t SET a = 0")
+	g.wait(t)
+	query := "INSERT INTO t (a, b) VALUES (?, ?)"
+	f1 := d.Queue(query, 1, 2)
+	f2 := d.Queue(query, 1, 2, 3)
+	g.release()
+	waitFuture(t, f1)
+	waitFuture(t, f2)
+
+	// 参数个数不同的语句不能合并
+	q := srv.queries()
+	if len(q) != 3 || q[1] != query || q[2] != query {
+		t.Fatalf("queries = %q", q)
+	}
+	if len(srv.args(2)) != 3 {
+		t.Fatalf("args = %v", srv.args(2))
+	}
+}
+
+func TestQueueBatchWindow(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{BatchSize: 3, BatchWindow: 50 * time.Millisecond})
+	query := "INSERT INTO t (a) VALUES (?)"
+	f1 := d.Queue(query, 1)
+	time.Sleep(5 * time.Millisecond)
+	f2 := d.Queue(query, 2)
+	waitFuture(t, f1)
+	waitFuture(t, f2)
+	if q := srv.queries(); len(q) != 1 || q[0] != "INSERT INTO t (a) VALUES (?),(?)" {
+		t.Fatalf("queries = %q", q)
+	}
+}
+
+func TestQueueBatchWindowWake(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{BatchSize: 2, BatchWindow: 5 * time.Second})
+	query := "INSERT INTO t (a) VALUES (?)"
+
+	// 达到 BatchSize 时不再等待
+	start := time.Now()
+	f1 := d.Queue(query, 1)
+	time.Sleep(5 * time.Millisecond)
+	f2 := d.Queue(query, 2)
+	waitFuture(t, f1)
+	waitFuture(t, f2)
+	if q := srv.queries(); len(q) != 1 || q[0] != "INSERT INTO t (a) VALUES (?),(?)" {
+		t.Fatalf("queries = %q", q)
+	}
+
+	// 关闭队列时不再等待
+	f3 := d.Queue(query, 3)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := d.QueueShutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := waitFuture(t, f3); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("batch window was waited out: %v", time.Since(start))
+	}
+}
+
+func TestQueueBatchTx(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{BatchSize: 10, BatchTx: true})
+	g := gateQueue(d)
+	defer g.release()
+
+	d.Queue("UPDATE t SET a = 0")
+	g.wait(t)
+	f1 := d.Queue("INSERT INTO t (a) VALUES (?)", 1)
+	f2 := d.Queue("INSERT INTO t (a) VALUES (?)", 2)
+	f3 := d.Queue("DELETE FROM s WHERE id = ?", 3)
+	g.release()
+	for _, f := range []*QueueFuture{f1, f2, f3} {
+		if _, _, err := waitFuture(t, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"UPDATE t SET a = 0", "BEGIN", "INSERT INTO t (a) VALUES (?),(?)", "DELETE FROM s WHERE id = ?", "COMMIT"}
+	if q := srv.queries(); !reflect.DeepEqual(q, want) {
+		t.Fatalf("queries = %q", q)
+	}
+
+	// 事务失败时整批语句以同一错误结束
+	srv.reset()
+	g = gateQueue(d)
+	defer g.release()
+	d.Queue("UPDATE t SET a = 0")
+	g.wait(t)
+	srv.fail(nil, errTestDeadlock)
+	f1 = d.Queue("UPDATE t SET a = 1")
+	f2 = d.Queue("UPDATE t SET a = 2")
+	g.release()
+	for _, f := range []*QueueFuture{f1, f2} {
+		if _, _, err := waitFuture(t, f); !IsDeadlock(err) {
+			t.Fatalf("err = %v", err)
+		}
+	}
+}
+
+func TestQueueKeyed(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{})
+	g := gateQueue(d)
+	defer g.release()
+
+	d.Queue("UPDATE t SET a = 0")
+	g.wait(t)
+	f1 := d.QueueKeyed("user:1", "UPDATE user SET score = ? WHERE id = 1", 1)
+	other := d.Queue("UPDATE t SET a = 9")
+	f2 := d.QueueKeyed("user:1", "UPDATE user SET score = ? WHERE id = 1", 2)
+	g.release()
+
+	for _, f := range []*QueueFuture{f1, f2, other} {
+		if _, _, err := waitFuture(t, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 后入队的语句覆盖先入队的语句, 并保留其在队列中的位置
+	want := []string{"UPDATE t SET a = 0", "UPDATE user SET score = ? WHERE id = 1", "UPDATE t SET a = 9"}
+	if q := srv.queries(); !reflect.DeepEqual(q, want) {
+		t.Fatalf("queries = %q", q)
+	}
+	if !reflect.DeepEqual(srv.args(1), []driver.Value{int64(2)}) {
+		t.Fatalf("args = %v", srv.args(1))
+	}
+
+	// 已开始执行的语句不再被覆盖
+	g = gateQueue(d)
+	defer g.release()
+	d.QueueKeyed("user:1", "UPDATE user SET score = ? WHERE id = 1", 3)
+	g.wait(t)
+	f := d.QueueKeyed("user:1", "UPDATE user SET score = ? WHERE id = 1", 4)
+	g.release()
+	waitFuture(t, f)
+	if n := len(srv.queries()); n != 5 {
+		t.Fatalf("executed %d statements, want 5", n)
+	}
+}
@@ -0,0 +1,267 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// 死信列表的默认最大长度
+const defaultDeadLetters = 1000
+
+// 死信, 即执行失败且不再重试的队列语句
+type DeadLetter struct {
+	Item     *QueueItem // 执行失败的语句
+	Err      error      // 最后一次执行的错误
+	Attempts int        // 已执行的次数
+	Time     time.Time  // 加入死信列表的时间
+}
+
+// 死信列表, 设置了文件时同时保存在文件中
+// 新的死信追加到文件末尾, 文件中的条目超过最大长度的2倍时重写文件
+type deadLetters struct {
+	lock  sync.Mutex
+	list  []*DeadLetter
+	path  string
+	lines int // 文件中的条目数
+}
+
+// 死信列表的最大长度, 小于0表示不保留
+func deadLetterLimit(limit int) int {
+	if limit == 0 {
+		return defaultDeadLetters
+	}
+	return limit
+}
+
+// 死信文件中的条目
+type deadLetterEntry struct {
+	Query    string         `json:"query"`
+	Args     []journalValue `json:"args,omitempty"`
+	Key      string         `json:"key,omitempty"`
+	Priority QueuePriority  `json:"priority,omitempty"`
+	Error    string         `json:"error"`
+	Attempts int            `json:"attempts"`
+	Time     time.Time      `json:"time"`
+}
+
+// 加入死信列表, 超过最大长度时丢弃最早的死信
+func (d *deadLetters) add(limit int, item *QueueItem, err error) {
+	limit = deadLetterLimit(limit)
+	if limit < 0 {
+		return
+	}
+	dl := &DeadLetter{Item: item, Err: err, Attempts: item.attempts, Time: time.Now()}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.list = append(d.list, dl)
+	if len(d.list) > limit {
+		d.list = append([]*DeadLetter(nil), d.list[len(d.list)-limit:]...)
+	}
+	if d.path == "" {
+		return
+	}
+	if d.lines >= 2*limit {
+		d.save()
+		return
+	}
+	f, ferr := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if ferr != nil {
+		logWari("死信写入文件失败: ", ferr)
+		return
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if ferr = writeDeadLetter(w, dl); ferr == nil {
+		ferr = w.Flush()
+	}
+	if ferr != nil {
+		logWari("死信写入文件失败: ", ferr)
+		return
+	}
+	d.lines++
+}
+
+// 死信列表的长度
+func (d *deadLetters) len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return len(d.list)
+}
+
+// 移除符合条件的死信, filter 为nil时移除全部
+func (d *deadLetters) remove(filter func(dl *DeadLetter) bool) []*DeadLetter {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var removed, kept []*DeadLetter
+	for _, dl := range d.list {
+		if filter == nil || filter(dl) {
+			removed = append(removed, dl)
+		} else {
+			kept = append(kept, dl)
+		}
+	}
+	if len(removed) > 0 {
+		d.list = kept
+		d.save()
+	}
+	return removed
+}
+
+// 重写死信文件, 调用时需持有锁
+func (d *deadLetters) save() {
+	if d.path == "" {
+		return
+	}
+	tmp := d.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		logWari("死信写入文件失败: ", err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	for _, dl := range d.list {
+		if err = writeDeadLetter(w, dl); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, d.path)
+	}
+	if err != nil {
+		logWari("死信写入文件失败: ", err)
+		return
+	}
+	d.lines = len(d.list)
+}
+
+func writeDeadLetter(w *bufio.Writer, dl *DeadLetter) error {
+	args, err := encodeJournalArgs(dl.Item.Params)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&deadLetterEntry{
+		Query:    dl.Item.Query,
+		Args:     args,
+		Key:      dl.Item.Key,
+		Priority: dl.Item.Priority,
+		Error:    dl.Err.Error(),
+		Attempts: dl.Attempts,
+		Time:     dl.Time,
+	})
+	if err != nil {
+		return err
+	}
+	w.Write(b)
+	return w.WriteByte('\n')
+}
+
+// 设置死信文件, 文件中已有的死信将被读入死信列表, 之后的死信同时写入文件
+// 读入的死信超过死信列表的最大长度时只保留最新的死信
+func (this *Database) OpenDeadLetterFile(path string) error {
+	q := this.getQueue()
+	q.lock.Lock()
+	limit := deadLetterLimit(q.opts.DeadLetters)
+	q.lock.Unlock()
+	d := &q.dead
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.path != "" {
+		return errors.New("dead letter file is already opened")
+	}
+
+	var loaded []*DeadLetter
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			e := &deadLetterEntry{}
+			if json.Unmarshal(scanner.Bytes(), e) != nil {
+				continue
+			}
+			args, err := decodeJournalArgs(e.Args)
+			if err != nil {
+				logWari("死信参数解析失败: ", err)
+				continue
+			}
+			loaded = append(loaded, &DeadLetter{
+				Item: &QueueItem{
+					DB:       this,
+					Query:    e.Query,
+					Params:   args,
+					Key:      e.Key,
+					Priority: e.Priority,
+					caller:   "deadletter:" + path,
+				},
+				Err:      errors.New(e.Error),
+				Attempts: e.Attempts,
+				Time:     e.Time,
+			})
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	d.list = append(loaded, d.list...)
+	if limit > 0 && len(d.list) > limit {
+		d.list = append([]*DeadLetter(nil), d.list[len(d.list)-limit:]...)
+	}
+	d.path = path
+	d.save()
+	return nil
+}
+
+// 获取死信列表
+func (this *Database) DeadLetters() []*DeadLetter {
+	q := this.currentQueue()
+	if q == nil {
+		return nil
+	}
+	d := &q.dead
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]*DeadLetter(nil), d.list...)
+}
+
+// 将符合条件的死信重新入队并从死信列表中移除, filter 为nil时处理全部死信, 返回重新入队的语句数
+func (this *Database) RequeueDeadLetters(filter func(dl *DeadLetter) bool) int {
+	q := this.currentQueue()
+	if q == nil {
+		return 0
+	}
+	removed := q.dead.remove(filter)
+	for _, dl := range removed {
+		this.Enqueue(&QueueItem{
+			Query:    dl.Item.Query,
+			Params:   dl.Item.Params,
+			Callback: dl.Item.Callback,
+			Key:      dl.Item.Key,
+			Priority: dl.Item.Priority,
+		})
+	}
+	return len(removed)
+}
+
+// 从死信列表中移除符合条件的死信, filter 为nil时移除全部死信, 返回移除的死信数
+func (this *Database) RemoveDeadLetters(filter func(dl *DeadLetter) bool) int {
+	if q := this.currentQueue(); q != nil {
+		return len(q.dead.remove(filter))
+	}
+	return 0
+}
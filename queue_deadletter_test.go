@@ -0,0 +1,254 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueRetry(t *testing.T) {
+	var delays []time.Duration
+	d, srv := newQueueDB(t, QueueOptions{Retry: &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			delays = append(delays, delay)
+		},
+	}})
+	srv.fail(errTestDeadlock, errTestDeadlock)
+	if _, _, err := waitFuture(t, d.Queue("UPDATE t SET a = 1")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.queries()); n != 3 {
+		t.Fatalf("executed %d times, want 3", n)
+	}
+	if !reflect.DeepEqual(delays, []time.Duration{time.Millisecond, 2 * time.Millisecond}) {
+		t.Fatalf("delays = %v", delays)
+	}
+	if s := d.QueueStats(); s.Retried != 2 || s.Succeeded != 1 || s.Failed != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+
+	// 队列中的语句连接丢失时不重试
+	srv.reset()
+	srv.fail(errTestLost)
+	if _, _, err := waitFuture(t, d.Queue("UPDATE t SET a = 1")); !IsConnectionLost(err) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := len(srv.queries()); n != 1 {
+		t.Fatalf("executed %d times, want 1", n)
+	}
+}
+
+func TestQueueRetryKeyed(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{Retry: &RetryPolicy{MaxAttempts: 3, BaseDelay: 50 * time.Millisecond}})
+	g := gateQueue(d)
+	srv.fail(errTestDeadlock)
+
+	// 执行期间入队的相同键的新语句代替重试
+	f1 := d.QueueKeyed("k", "UPDATE t SET a = ?", 1)
+	g.wait(t)
+	f2 := d.QueueKeyed("k", "UPDATE t SET a = ?", 2)
+	g.release()
+	if _, _, err := waitFuture(t, f1); err != nil {
+		t.Fatal(err)
+	}
+	waitFuture(t, f2)
+	if n := len(srv.queries()); n != 2 || !reflect.DeepEqual(srv.args(1), []driver.Value{int64(2)}) {
+		t.Fatalf("executed %d times, last args %v", n, srv.args(n-1))
+	}
+
+	// 等待重试的语句被相同键的新语句覆盖
+	srv.reset()
+	srv.fail(errTestDeadlock)
+	f1 = d.QueueKeyed("k", "UPDATE t SET a = ?", 3)
+	for d.QueueStats().Delayed == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	f2 = d.QueueKeyed("k", "UPDATE t SET a = ?", 4)
+	waitFuture(t, f1)
+	waitFuture(t, f2)
+	if n := len(srv.queries()); n != 2 || !reflect.DeepEqual(srv.args(1), []driver.Value{int64(4)}) {
+		t.Fatalf("executed %d times, last args %v", n, srv.args(n-1))
+	}
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{Retry: &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}})
+	srv.fail(errTestDeadlock, errTestDeadlock, errors.New("Error 1062: Duplicate entry"))
+	waitFuture(t, d.QueuePriority(PriorityHigh, "UPDATE t SET a = ?", 1))
+	waitFuture(t, d.QueueKeyed("k", "INSERT INTO t VALUES (?)", 2))
+
+	dls := d.DeadLetters()
+	if len(dls) != 2 {
+		t.Fatalf("dead letters = %v", dls)
+	}
+	if dls[0].Attempts != 2 || !IsDeadlock(dls[0].Err) || dls[0].Item.Priority != PriorityHigh {
+		t.Fatalf("dead letter = %+v", dls[0])
+	}
+	if dls[1].Attempts != 1 || !IsDuplicate(dls[1].Err) || dls[1].Item.Key != "k" {
+		t.Fatalf("dead letter = %+v", dls[1])
+	}
+	if s := d.QueueStats(); s.Dead != 2 || s.Failed != 2 {
+		t.Fatalf("stats = %+v", s)
+	}
+
+	// 重新入队后从死信列表中移除
+	srv.reset()
+	n := d.RequeueDeadLetters(func(dl *DeadLetter) bool { return IsDeadlock(dl.Err) })
+	if n != 1 || len(d.DeadLetters()) != 1 {
+		t.Fatalf("requeued %d, left %d", n, len(d.DeadLetters()))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.queries()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if q := srv.queries(); len(q) != 1 || q[0] != "UPDATE t SET a = ?" {
+		t.Fatalf("queries = %q", q)
+	}
+	if n = d.RemoveDeadLetters(nil); n != 1 || len(d.DeadLetters()) != 0 {
+		t.Fatalf("removed %d", n)
+	}
+}
+
+func TestQueueDeadLettersLimit(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{DeadLetters: 2})
+	for i := 0; i < 3; i++ {
+		srv.fail(errTestDeadlock)
+		waitFuture(t, d.Queue("UPDATE t SET a = ?", i))
+	}
+	dls := d.DeadLetters()
+	if len(dls) != 2 || dls[0].Item.Params[0] != 1 || dls[1].Item.Params[0] != 2 {
+		t.Fatalf("dead letters = %v", dls)
+	}
+
+	d2, srv2 := newQueueDB(t, QueueOptions{DeadLetters: -1})
+	srv2.fail(errTestDeadlock)
+	waitFuture(t, d2.Queue("UPDATE t SET a = 1"))
+	if len(d2.DeadLetters()) != 0 {
+		t.Fatal("dead letters should not be kept")
+	}
+}
+
+func TestDeadLetterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	d1, srv1 := newQueueDB(t, QueueOptions{})
+	if err := d1.OpenDeadLetterFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := d1.OpenDeadLetterFile(path); err == nil {
+		t.Fatal("second open should fail")
+	}
+	srv1.fail(errTestDeadlock)
+	waitFuture(t, d1.QueueKeyed("k", "UPDATE t SET a = ?", "x"))
+
+	b, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(b), `"query":"UPDATE t SET a = ?"`) {
+		t.Fatalf("file = %q, %v", b, err)
+	}
+
+	// 另一个数据库对象读入文件中的死信
+	d2, srv2 := newQueueDB(t, QueueOptions{})
+	if err = d2.OpenDeadLetterFile(path); err != nil {
+		t.Fatal(err)
+	}
+	dls := d2.DeadLetters()
+	if len(dls) != 1 || dls[0].Item.Key != "k" || dls[0].Item.Params[0] != "x" || dls[0].Attempts != 1 || !IsDeadlock(dls[0].Err) {
+		t.Fatalf("loaded = %+v", dls)
+	}
+	d2.RequeueDeadLetters(nil)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv2.queries()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(srv2.queries()) != 1 {
+		t.Fatal("requeued dead letter should be executed")
+	}
+	if b, _ = os.ReadFile(path); len(b) != 0 {
+		t.Fatalf("file after requeue = %q", b)
+	}
+}
+
+func TestDeadLetterFileCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	lines := func() int {
+		b, _ := os.ReadFile(path)
+		return strings.Count(string(b), "\n")
+	}
+
+	// 新的死信追加到文件, 超过最大长度的2倍时重写
+	d := &deadLetters{path: path}
+	var counts []int
+	for _, v := range []string{"a", "b", "c", "d", "e"} {
+		d.add(2, &QueueItem{Query: "UPDATE t SET a = ?", Params: []interface{}{v}}, errTestDeadlock)
+		counts = append(counts, lines())
+	}
+	if !reflect.DeepEqual(counts, []int{1, 2, 3, 4, 2}) {
+		t.Fatalf("file lines = %v", counts)
+	}
+
+	// 读入时只保留最新的死信
+	d1, _ := newQueueDB(t, QueueOptions{DeadLetters: 1})
+	if err := d1.OpenDeadLetterFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if dls := d1.DeadLetters(); len(dls) != 1 || dls[0].Item.Params[0] != "e" || lines() != 1 {
+		t.Fatalf("loaded = %v, file lines = %d", dls, lines())
+	}
+}
+
+func TestQueueDelayed(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{})
+	start := time.Now()
+	later := d.QueueAfter(40*time.Millisecond, "UPDATE t SET a = 2")
+	sooner := d.QueueAt(start.Add(20*time.Millisecond), "UPDATE t SET a = 1")
+	if s := d.QueueStats(); s.Delayed != 2 {
+		t.Fatalf("stats = %+v", s)
+	}
+	waitFuture(t, later)
+	waitFuture(t, sooner)
+	if time.Since(start) < 40*time.Millisecond {
+		t.Fatal("delayed statement executed too early")
+	}
+	if q := srv.queries(); !reflect.DeepEqual(q, []string{"UPDATE t SET a = 1", "UPDATE t SET a = 2"}) {
+		t.Fatalf("queries = %q", q)
+	}
+	if s := d.QueueStats(); s.Delayed != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{})
+	g := gateQueue(d)
+	defer g.release()
+
+	d.Queue("UPDATE t SET a = 0")
+	g.wait(t)
+	d.QueuePriority(PriorityLow, "UPDATE t SET a = 1")
+	d.Queue("UPDATE t SET a = 2")
+	d.QueuePriority(PriorityHigh, "UPDATE t SET a = 3")
+	d.Queue("UPDATE t SET a = 4")
+	f := d.QueuePriority(PriorityHigh, "UPDATE t SET a = 5")
+	g.release()
+	waitFuture(t, f)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.queries()) < 6 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// 高优先级先执行, 相同优先级按入队顺序执行
+	want := []string{"0", "3", "5", "2", "4", "1"}
+	var got []string
+	for _, q := range srv.queries() {
+		got = append(got, q[len(q)-1:])
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("order = %v", got)
+	}
+}
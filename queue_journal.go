@@ -0,0 +1,528 @@
+package db
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 日志已关闭, 入队的语句不再记录
+var errJournalClosed = errors.New("queue journal is closed")
+
+// 队列日志同步策略
+type JournalSync int
+
+const (
+	SyncAlways   JournalSync = iota // 每次写入后立即同步到磁盘
+	SyncInterval                    // 按固定时间间隔同步到磁盘
+	SyncNone                        // 不主动同步, 由操作系统决定
+)
+
+// 队列日志选项
+type JournalOptions struct {
+	Sync             JournalSync   // 同步策略
+	SyncInterval     time.Duration // SyncInterval 策略的同步间隔, 默认1秒
+	CompactThreshold int           // 已完成的条目数达到该值时压缩日志文件, 默认1000
+	DB               *Database     // 使用 OpenQueueJournal 时指定的数据库对象, 默认为 Default()
+}
+
+// 队列预写日志
+// 每条入队的语句都会先追加到日志文件中, 执行完成后追加完成标记,
+// 启动时重放没有完成标记的语句, 以保证进程崩溃或重启时不丢失队列中的语句
+type queueJournal struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	opts    JournalOptions
+	nextID  uint64
+	pending map[uint64]*journalEntry
+	done    int
+	dirty   bool
+	stop    chan struct{}
+}
+
+// 日志条目
+type journalEntry struct {
+	Op       string         `json:"op"` // push 或 done
+	ID       uint64         `json:"id"`
+	Query    string         `json:"query,omitempty"`
+	Args     []journalValue `json:"args,omitempty"`
+	Key      string         `json:"key,omitempty"` // 按键合并的语句的键
+	Priority QueuePriority  `json:"priority,omitempty"`
+	At       *time.Time     `json:"at,omitempty"`
+}
+
+// 带类型的参数值, 保证重放时参数类型不变
+type journalValue struct {
+	T string `json:"t"`
+	V string `json:"v,omitempty"`
+}
+
+// 为 opts.DB 的SQL队列开启预写日志, 并重放日志中未完成的语句
+func OpenQueueJournal(path string, opts JournalOptions) error {
+	if opts.DB == nil {
+		opts.DB = Default()
+	}
+	if opts.DB == nil {
+		return errors.New("journal database cannot be nil")
+	}
+	return opts.DB.OpenQueueJournal(path, opts)
+}
+
+// 关闭 Default() 的SQL队列的预写日志
+func CloseQueueJournal() error {
+	d := Default()
+	if d == nil {
+		return nil
+	}
+	return d.CloseQueueJournal()
+}
+
+// 已打开的日志文件, 同一文件只能由一个队列使用
+var (
+	journalPaths     = make(map[string]bool)
+	journalPathsLock sync.Mutex
+)
+
+// 为数据库的SQL队列开启预写日志, 并重放日志中未完成的语句
+// 每个数据库的队列应使用不同的日志文件, 文件已被其他队列使用时返回错误
+func (this *Database) OpenQueueJournal(path string, opts JournalOptions) error {
+	opts.DB = this
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Second
+	}
+	if opts.CompactThreshold <= 0 {
+		opts.CompactThreshold = 1000
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	// 读写文件之前先占用队列的日志位置及日志文件
+	q := this.getQueue()
+	q.lock.Lock()
+	if q.journal != nil || q.journalOpening {
+		q.lock.Unlock()
+		return errors.New("queue journal is already opened")
+	}
+	journalPathsLock.Lock()
+	if journalPaths[abs] {
+		journalPathsLock.Unlock()
+		q.lock.Unlock()
+		return fmt.Errorf("queue journal %s is used by another queue", path)
+	}
+	journalPaths[abs] = true
+	journalPathsLock.Unlock()
+	q.journalOpening = true
+	q.lock.Unlock()
+
+	j := &queueJournal{
+		path:    abs,
+		opts:    opts,
+		pending: make(map[uint64]*journalEntry),
+		stop:    make(chan struct{}),
+	}
+	if err = j.load(); err == nil {
+		err = j.rewrite()
+	}
+	q.lock.Lock()
+	q.journalOpening = false
+	if err == nil {
+		q.journal = j
+	}
+	q.lock.Unlock()
+	if err != nil {
+		j.close()
+		releaseJournalPath(abs)
+		return err
+	}
+
+	if opts.Sync == SyncInterval {
+		go j.syncLoop()
+	}
+
+	// 重放未完成的语句
+	ids := make([]uint64, 0, len(j.pending))
+	for id := range j.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	for _, id := range ids {
+		e := j.pending[id]
+		args, err := decodeJournalArgs(e.Args)
+		if err != nil {
+			logWari("队列日志参数解析失败: ", err)
+			continue
+		}
+		var at time.Time
+		if e.At != nil {
+			at = *e.At
+		}
+		q.Push(&QueueItem{
+			DB:        this,
+			Query:     e.Query,
+			Params:    args,
+			Key:       e.Key,
+			Priority:  e.Priority,
+			At:        at,
+			caller:    "journal:" + strconv.FormatUint(id, 10),
+			future:    newQueueFuture(),
+			journalID: id,
+		})
+	}
+	return nil
+}
+
+// 关闭数据库的SQL队列的预写日志
+func (this *Database) CloseQueueJournal() error {
+	if q := this.currentQueue(); q != nil {
+		return q.closeJournal()
+	}
+	return nil
+}
+
+// 关闭队列的预写日志
+func (this *queueList) closeJournal() error {
+	this.lock.Lock()
+	j := this.journal
+	this.journal = nil
+	this.lock.Unlock()
+	if j == nil {
+		return nil
+	}
+	err := j.close()
+	releaseJournalPath(j.path)
+	return err
+}
+
+// 释放日志文件
+func releaseJournalPath(path string) {
+	journalPathsLock.Lock()
+	delete(journalPaths, path)
+	journalPathsLock.Unlock()
+}
+
+// 读取日志文件, 末尾不完整的条目将被忽略
+func (j *queueJournal) load() error {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		e := &journalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			continue
+		}
+		if e.ID >= j.nextID {
+			j.nextID = e.ID
+		}
+		switch e.Op {
+		case "push":
+			j.pending[e.ID] = e
+		case "done":
+			delete(j.pending, e.ID)
+		}
+	}
+	return scanner.Err()
+}
+
+// 只保留未完成的条目重写日志文件
+func (j *queueJournal) rewrite() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	ids := make([]uint64, 0, len(j.pending))
+	for id := range j.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	for _, id := range ids {
+		if err = writeJournalEntry(w, j.pending[id]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	j.writer = bufio.NewWriter(j.file)
+	j.done = 0
+	return nil
+}
+
+func writeJournalEntry(w *bufio.Writer, e *journalEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// 写入条目并按同步策略同步
+func (j *queueJournal) write(e *journalEntry) error {
+	if err := writeJournalEntry(j.writer, e); err != nil {
+		return err
+	}
+	switch j.opts.Sync {
+	case SyncAlways:
+		if err := j.writer.Flush(); err != nil {
+			return err
+		}
+		return j.file.Sync()
+	case SyncNone:
+		return j.writer.Flush()
+	default:
+		j.dirty = true
+		return nil
+	}
+}
+
+// 记录入队的语句
+func (j *queueJournal) append(item *QueueItem) error {
+	args, err := encodeJournalArgs(item.Params)
+	if err != nil {
+		return err
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.file == nil {
+		return errJournalClosed
+	}
+	j.nextID++
+	e := &journalEntry{Op: "push", ID: j.nextID, Query: item.Query, Args: args, Key: item.Key, Priority: item.Priority}
+	if !item.At.IsZero() {
+		at := item.At
+		e.At = &at
+	}
+	if err = j.write(e); err != nil {
+		return err
+	}
+	j.pending[e.ID] = e
+	item.journalID = e.ID
+	return nil
+}
+
+// 记录语句已执行完成, 完成的条目足够多时压缩日志文件
+func (j *queueJournal) markDone(id uint64) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.file == nil {
+		return errJournalClosed
+	}
+	if _, ok := j.pending[id]; !ok {
+		return nil
+	}
+	delete(j.pending, id)
+	if err := j.write(&journalEntry{Op: "done", ID: id}); err != nil {
+		return err
+	}
+	j.done++
+	if j.done >= j.opts.CompactThreshold {
+		if err := j.writer.Flush(); err != nil {
+			return err
+		}
+		return j.rewrite()
+	}
+	return nil
+}
+
+// 定时同步
+func (j *queueJournal) syncLoop() {
+	ticker := time.NewTicker(j.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.lock.Lock()
+			if j.dirty && j.file != nil {
+				if err := j.writer.Flush(); err == nil {
+					j.file.Sync()
+				}
+				j.dirty = false
+			}
+			j.lock.Unlock()
+		}
+	}
+}
+
+// 同步并关闭日志文件
+func (j *queueJournal) close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	close(j.stop)
+	err := j.writer.Flush()
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	j.file = nil
+	return err
+}
+
+// 编码参数
+func encodeJournalArgs(args []interface{}) ([]journalValue, error) {
+	ret := make([]journalValue, len(args))
+	for i, arg := range args {
+		if valuer, ok := arg.(driver.Valuer); ok {
+			v, err := valuer.Value()
+			if err != nil {
+				return nil, err
+			}
+			arg = v
+		}
+		switch v := arg.(type) {
+		case nil:
+			ret[i] = journalValue{T: "nil"}
+		case bool:
+			ret[i] = journalValue{T: "bool", V: strconv.FormatBool(v)}
+		case int:
+			ret[i] = journalValue{T: "int", V: strconv.Itoa(v)}
+		case int8:
+			ret[i] = journalValue{T: "int8", V: I64toA(int64(v))}
+		case int16:
+			ret[i] = journalValue{T: "int16", V: I64toA(int64(v))}
+		case int32:
+			ret[i] = journalValue{T: "int32", V: I64toA(int64(v))}
+		case int64:
+			ret[i] = journalValue{T: "int64", V: I64toA(v)}
+		case uint:
+			ret[i] = journalValue{T: "uint", V: UitoA(v)}
+		case uint8:
+			ret[i] = journalValue{T: "uint8", V: Ui64toA(uint64(v))}
+		case uint16:
+			ret[i] = journalValue{T: "uint16", V: Ui64toA(uint64(v))}
+		case uint32:
+			ret[i] = journalValue{T: "uint32", V: Ui64toA(uint64(v))}
+		case uint64:
+			ret[i] = journalValue{T: "uint64", V: Ui64toA(v)}
+		case float32:
+			ret[i] = journalValue{T: "float32", V: F32toA(v)}
+		case float64:
+			ret[i] = journalValue{T: "float64", V: F64toA(v)}
+		case string:
+			ret[i] = journalValue{T: "string", V: v}
+		case []byte:
+			ret[i] = journalValue{T: "bytes", V: base64.StdEncoding.EncodeToString(v)}
+		case time.Time:
+			ret[i] = journalValue{T: "time", V: v.Format(time.RFC3339Nano)}
+		default:
+			return nil, fmt.Errorf("unsupported journal argument type: %T", v)
+		}
+	}
+	return ret, nil
+}
+
+// 解码参数
+func decodeJournalArgs(vals []journalValue) ([]interface{}, error) {
+	ret := make([]interface{}, len(vals))
+	for i, jv := range vals {
+		var err error
+		switch jv.T {
+		case "nil":
+			ret[i] = nil
+		case "bool":
+			ret[i], err = strconv.ParseBool(jv.V)
+		case "int":
+			var v int64
+			v, err = strconv.ParseInt(jv.V, 10, 64)
+			ret[i] = int(v)
+		case "int8":
+			var v int64
+			v, err = strconv.ParseInt(jv.V, 10, 8)
+			ret[i] = int8(v)
+		case "int16":
+			var v int64
+			v, err = strconv.ParseInt(jv.V, 10, 16)
+			ret[i] = int16(v)
+		case "int32":
+			var v int64
+			v, err = strconv.ParseInt(jv.V, 10, 32)
+			ret[i] = int32(v)
+		case "int64":
+			ret[i], err = strconv.ParseInt(jv.V, 10, 64)
+		case "uint":
+			var v uint64
+			v, err = strconv.ParseUint(jv.V, 10, 64)
+			ret[i] = uint(v)
+		case "uint8":
+			var v uint64
+			v, err = strconv.ParseUint(jv.V, 10, 8)
+			ret[i] = uint8(v)
+		case "uint16":
+			var v uint64
+			v, err = strconv.ParseUint(jv.V, 10, 16)
+			ret[i] = uint16(v)
+		case "uint32":
+			var v uint64
+			v, err = strconv.ParseUint(jv.V, 10, 32)
+			ret[i] = uint32(v)
+		case "uint64":
+			ret[i], err = strconv.ParseUint(jv.V, 10, 64)
+		case "float32":
+			var v float64
+			v, err = strconv.ParseFloat(jv.V, 32)
+			ret[i] = float32(v)
+		case "float64":
+			ret[i], err = strconv.ParseFloat(jv.V, 64)
+		case "string":
+			ret[i] = jv.V
+		case "bytes":
+			ret[i], err = base64.StdEncoding.DecodeString(jv.V)
+		case "time":
+			ret[i], err = time.Parse(time.RFC3339Nano, jv.V)
+		default:
+			err = fmt.Errorf("unsupported journal argument type: %s", jv.T)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,264 @@
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalArgs(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	args := []interface{}{
+		nil, true, -1, int8(-8), int16(-16), int32(-32), int64(-64),
+		uint(1), uint8(8), uint16(16), uint32(32), uint64(64),
+		float32(1.5), 2.25, "s", []byte{0, 1, 2}, at,
+	}
+	vals, err := encodeJournalArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := decodeJournalArgs(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ret[len(ret)-1].(time.Time).Equal(at) {
+		t.Fatalf("time = %v", ret[len(ret)-1])
+	}
+	if !reflect.DeepEqual(ret[:len(ret)-1], args[:len(args)-1]) {
+		t.Fatalf("decoded = %#v", ret)
+	}
+
+	// driver.Valuer 按其值记录
+	vals, err = encodeJournalArgs([]interface{}{NewNullString("x")})
+	if err != nil || vals[0] != (journalValue{T: "string", V: "x"}) {
+		t.Fatalf("valuer = %v, %v", vals, err)
+	}
+	if _, err = encodeJournalArgs([]interface{}{struct{}{}}); err == nil {
+		t.Fatal("unsupported type should fail")
+	}
+	if _, err = decodeJournalArgs([]journalValue{{T: "int8", V: "300"}}); err == nil {
+		t.Fatal("out of range value should fail")
+	}
+	if _, err = decodeJournalArgs([]journalValue{{T: "complex"}}); err == nil {
+		t.Fatal("unknown type should fail")
+	}
+}
+
+// 读取日志文件中的条目
+func readJournal(t *testing.T, path string) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestJournalReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.journal")
+	content := `{"op":"push","id":1,"query":"INSERT INTO t VALUES (?)","args":[{"t":"int","v":"1"}]}
+{"op":"push","id":2,"query":"INSERT INTO t VALUES (?)","args":[{"t":"int","v":"2"}]}
+{"op":"push","id":3,"query":"UPDATE t SET a = ?","args":[{"t":"bytes","v":"AAE="}]}
+{"op":"done","id":1}
+{"op":"push","id":4,"query":"INSERT INTO t VALU`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, srv := newQueueDB(t, QueueOptions{})
+	g := gateQueue(d)
+	if err := d.OpenQueueJournal(path, JournalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer d.CloseQueueJournal()
+
+	// 打开时只保留未完成的条目
+	lines := readJournal(t, path)
+	if len(lines) != 2 || !strings.Contains(lines[0], `"id":2`) || !strings.Contains(lines[1], `"id":3`) {
+		t.Fatalf("journal after open = %q", lines)
+	}
+
+	g.wait(t)
+	g.release()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.queries()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	q := srv.queries()
+	if !reflect.DeepEqual(q, []string{"INSERT INTO t VALUES (?)", "UPDATE t SET a = ?"}) {
+		t.Fatalf("replayed = %q", q)
+	}
+	if !reflect.DeepEqual(srv.args(0), []driver.Value{int64(2)}) || !reflect.DeepEqual(srv.args(1), []driver.Value{[]byte{0, 1}}) {
+		t.Fatalf("replayed args = %v, %v", srv.args(0), srv.args(1))
+	}
+
+	// 新的条目编号接着日志中最大的有效编号
+	f := d.Queue("DELETE FROM t")
+	waitFuture(t, f)
+	lines = readJournal(t, path)
+	if !strings.Contains(strings.Join(lines, "\n"), `{"op":"push","id":4,"query":"DELETE FROM t"}`) {
+		t.Fatalf("journal = %q", lines)
+	}
+}
+
+func TestJournalSurvivesRestart(t *testing.T) {
+	useDefaultLogger(t, &testLogger{level: LevelError})
+	path := filepath.Join(t.TempDir(), "queue.journal")
+
+	d1, _ := newQueueDB(t, QueueOptions{})
+	g := gateQueue(d1)
+	defer g.release()
+	if err := d1.OpenQueueJournal(path, JournalOptions{Sync: SyncNone}); err != nil {
+		t.Fatal(err)
+	}
+	d1.Queue("INSERT INTO t VALUES (?)", "a")
+	g.wait(t)
+	d1.QueueKeyed("k", "UPDATE t SET a = ? WHERE id = 1", 1)
+	d1.QueueAfter(time.Hour, "DELETE FROM t WHERE id = ?", int64(2))
+	if err := d1.CloseQueueJournal(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟进程重启, 未完成的语句在另一个数据库对象上重放
+	d2, srv2 := newQueueDB(t, QueueOptions{})
+	if err := d2.OpenQueueJournal(path, JournalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer d2.CloseQueueJournal()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv2.queries()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	q := srv2.queries()
+	if !reflect.DeepEqual(q, []string{"INSERT INTO t VALUES (?)", "UPDATE t SET a = ? WHERE id = 1"}) {
+		t.Fatalf("replayed = %q", q)
+	}
+	if s := d2.QueueStats(); s.Delayed != 1 {
+		t.Fatalf("delayed statement should stay delayed: %+v", s)
+	}
+	d2.getQueue().lock.Lock()
+	key := d2.getQueue().keyed["k"]
+	d2.getQueue().lock.Unlock()
+	if key != nil {
+		t.Fatal("keyed statement already executed should leave the key index")
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.journal")
+	d, _ := newQueueDB(t, QueueOptions{})
+	if err := d.OpenQueueJournal(path, JournalOptions{CompactThreshold: 3}); err != nil {
+		t.Fatal(err)
+	}
+	defer d.CloseQueueJournal()
+
+	for i := 0; i < 3; i++ {
+		waitFuture(t, d.Queue("INSERT INTO t VALUES (?)", i))
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Fatalf("journal should be empty after compaction: %q", b)
+	}
+}
+
+func TestJournalOpenConflicts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queue.journal")
+	d1, _ := newQueueDB(t, QueueOptions{})
+	d2, _ := newQueueDB(t, QueueOptions{})
+
+	if err := d1.OpenQueueJournal(path, JournalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d1.OpenQueueJournal(filepath.Join(dir, "other.journal"), JournalOptions{}); err == nil || !strings.Contains(err.Error(), "already opened") {
+		t.Fatalf("second open on the same queue = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.journal")); !os.IsNotExist(err) {
+		t.Fatal("rejected open should not create the file")
+	}
+
+	// 相对路径与绝对路径指向同一文件
+	wd, _ := os.Getwd()
+	rel, err := filepath.Rel(wd, path)
+	if err != nil {
+		t.Skip(err)
+	}
+	if err = d2.OpenQueueJournal(rel, JournalOptions{}); err == nil || !strings.Contains(err.Error(), "used by another queue") {
+		t.Fatalf("open a used path = %v", err)
+	}
+
+	if err = d1.CloseQueueJournal(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d2.OpenQueueJournal(path, JournalOptions{}); err != nil {
+		t.Fatalf("open after close = %v", err)
+	}
+	d2.CloseQueueJournal()
+
+	// 打开失败时释放日志文件
+	bad := filepath.Join(dir, "missing", "queue.journal")
+	if err = d1.OpenQueueJournal(bad, JournalOptions{}); err == nil {
+		t.Fatal("open in a missing directory should fail")
+	}
+	os.Mkdir(filepath.Join(dir, "missing"), 0755)
+	if err = d1.OpenQueueJournal(bad, JournalOptions{}); err != nil {
+		t.Fatalf("open after a failed open = %v", err)
+	}
+	d1.CloseQueueJournal()
+}
+
+func TestJournalAppendFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.journal")
+	d, srv := newQueueDB(t, QueueOptions{})
+	if err := d.OpenQueueJournal(path, JournalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer d.CloseQueueJournal()
+
+	_, _, err := waitFuture(t, d.Queue("INSERT INTO t VALUES (?)", struct{}{}))
+	if err == nil || !strings.Contains(err.Error(), "sql queue journal") {
+		t.Fatalf("err = %v", err)
+	}
+	if len(srv.queries()) != 0 {
+		t.Fatal("statement not written to the journal should not be executed")
+	}
+	if s := d.QueueStats(); s.Rejected != 1 {
+		t.Fatalf("stats = %+v", s)
+	}
+
+	// 日志关闭后入队的语句不再记录, 正常执行
+	d.CloseQueueJournal()
+	if _, _, err = waitFuture(t, d.Queue("INSERT INTO t VALUES (?)", 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalRejectedItemIsDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.journal")
+	d, _ := newQueueDB(t, QueueOptions{Capacity: 1, Overflow: OverflowReject})
+	g := gateQueue(d)
+	defer g.release()
+	if err := d.OpenQueueJournal(path, JournalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	defer d.CloseQueueJournal()
+
+	d.Queue("UPDATE t SET a = 1")
+	g.wait(t)
+	d.Queue("UPDATE t SET a = 2")
+	if _, _, err := waitFuture(t, d.Queue("UPDATE t SET a = 3")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("err = %v", err)
+	}
+	// 被拒绝的语句不会在重放时执行
+	lines := readJournal(t, path)
+	if last := lines[len(lines)-1]; last != `{"op":"done","id":3}` {
+		t.Fatalf("journal = %q", lines)
+	}
+}
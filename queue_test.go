@@ -0,0 +1,503 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 创建使用队列的测试数据库, 测试结束时关闭队列
+func newQueueDB(t *testing.T, opts QueueOptions) (*Database, *testServer) {
+	d, srv := newTestDB(t)
+	d.SetQueueOptions(opts)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		d.QueueShutdown(ctx)
+	})
+	return d, srv
+}
+
+// 阻塞队列中语句的执行, 直到调用 release
+type queueGate struct {
+	started chan string
+	open    chan struct{}
+	once    sync.Once
+}
+
+func gateQueue(d *Database) *queueGate {
+	g := &queueGate{started: make(chan string, 100), open: make(chan struct{})}
+	d.AddHook(HookFuncs{BeforeFunc: func(stmt *Statement) error {
+		if stmt.Queued && stmt.Kind == KindExec {
+			g.started <- stmt.Query
+			<-g.open
+		}
+		return nil
+	}})
+	return g
+}
+
+// 等待一条语句开始执行
+func (g *queueGate) wait(t *testing.T) string {
+	select {
+	case q := <-g.started:
+		return q
+	case <-time.After(time.Second):
+		t.Fatal("queued statement did not start")
+	}
+	return ""
+}
+
+func (g *queueGate) release() {
+	g.once.Do(func() { close(g.open) })
+}
+
+// 等待执行结果, 超时则失败
+func waitFuture(t *testing.T, f *QueueFuture) (int64, int64, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	id, affected, err := f.WaitContext(ctx)
+	if err == context.DeadlineExceeded {
+		t.Fatal("queued statement did not finish")
+	}
+	return id, affected, err
+}
+
+func TestQueueResult(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{})
+	srv.lastID, srv.affected = 5, 1
+
+	id, affected, err := waitFuture(t, d.Queue("INSERT INTO t (a) VALUES (?)", 1))
+	if err != nil || id != 5 || affected != 1 {
+		t.Fatalf("Wait = %d, %d, %v", id, affected, err)
+	}
+
+	done := make(chan QueueResult, 1)
+	f := d.QueueFunc(func(res *QueueResult) { done <- *res }, "UPDATE t SET a = 1")
+	waitFuture(t, f)
+	if res := <-done; res.Err != nil || res.Affected != 1 || res.Batched != 1 {
+		t.Fatalf("callback result = %+v", res)
+	}
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("Done should be closed after the statement finished")
+	}
+
+	if q := srv.queries(); len(q) != 2 || q[1] != "UPDATE t SET a = 1" {
+		t.Fatalf("queries = %q", q)
+	}
+	if s := d.QueueStats(); s.Succeeded != 2 || s.Failed != 0 || s.Pending != 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestQueueStatement(t *testing.T) {
+	d, _ := newQueueDB(t, QueueOptions{})
+	stmts := make(chan Statement, 1)
+	d.AddHook(HookFuncs{AfterFunc: func(stmt *Statement) { stmts <- *stmt }})
+
+	waitFuture(t, d.Queue("UPDATE t SET a = 1"))
+	stmt := <-stmts
+	if !stmt.Queued {
+		t.Fatal("queued statement should be marked as Queued")
+	}
+	if !strings.Contains(stmt.Caller, "queue_test.go:") {
+		t.Fatalf("Caller = %q, want the enqueue location", stmt.Caller)
+	}
+}
+
+func TestQueueContext(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{BatchSize: 10})
+	d.SQLComment = true
+	tr := &testTracer{}
+	d.Tracer = tr
+	type requestKey struct{}
+	ctxs := make(chan context.Context, 10)
+	g := gateQueue(d)
+	defer g.release()
+	d.AddHook(HookFuncs{BeforeFunc: func(stmt *Statement) error {
+		ctxs <- stmt.Ctx
+		return nil
+	}})
+
+	d.Queue("UPDATE t SET a = 0")
+	g.wait(t)
+	// 入队时的上下文被取消后语句仍然执行, 并沿用其中的值及SQL注释标签
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), requestKey{}, "req-1"))
+	ctx = WithSQLComment(ctx, "route", "/users")
+	f1 := d.QueueContext(ctx, "INSERT INTO t (a) VALUES (?)", 1)
+	f2 := d.QueueContext(ctx, "INSERT INTO t (a) VALUES (?)", 2)
+	cancel()
+	g.release()
+	for _, f := range []*QueueFuture{f1, f2} {
+		if _, _, err := waitFuture(t, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"UPDATE t SET a = 0", "INSERT INTO t (a) VALUES (?),(?) /*route='%2Fusers'*/"}
+	if q := srv.queries(); !reflect.DeepEqual(q, want) {
+		t.Fatalf("queries = %q", q)
+	}
+	<-ctxs
+	c := <-ctxs
+	if c.Value(requestKey{}) != "req-1" || c.Err() != nil || c.Done() != nil {
+		t.Fatalf("statement context = %v", c)
+	}
+	if len(tr.spans) != 2 || !tr.spans[1].ended {
+		t.Fatalf("spans = %d", len(tr.spans))
+	}
+}
+
+func TestQueueErrorHandler(t *testing.T) {
+	cause := errors.New("Error 1064: syntax error")
+
+	var global []string
+	var lock sync.Mutex
+	SetQueueErrorHandler(func(item *QueueItem, err error) {
+		lock.Lock()
+		global = append(global, item.Query)
+		lock.Unlock()
+	})
+	defer SetQueueErrorHandler(nil)
+
+	d, srv := newQueueDB(t, QueueOptions{})
+	srv.fail(cause)
+	if _, _, err := waitFuture(t, d.Queue("UPDAT t")); !errors.Is(err, cause) {
+		t.Fatalf("err = %v", err)
+	}
+	lock.Lock()
+	if len(global) != 1 || global[0] != "UPDAT t" {
+		t.Fatalf("global handler calls = %v", global)
+	}
+	lock.Unlock()
+
+	// 队列的处理函数优先于全局处理函数
+	var local []error
+	d.SetQueueOptions(QueueOptions{ErrorHandler: func(item *QueueItem, err error) {
+		local = append(local, err)
+	}})
+	srv.fail(cause)
+	waitFuture(t, d.Queue("UPDAT t"))
+	if len(local) != 1 || !IsSyntax(local[0]) {
+		t.Fatalf("queue handler calls = %v", local)
+	}
+	lock.Lock()
+	if len(global) != 1 {
+		t.Fatal("global handler should not be called when the queue has a handler")
+	}
+	lock.Unlock()
+	if s := d.QueueStats(); s.Failed != 2 {
+		t.Fatalf("Failed = %d, want 2", s.Failed)
+	}
+}
+
+func TestQueueWaitContext(t *testing.T) {
+	d, _ := newQueueDB(t, QueueOptions{})
+	g := gateQueue(d)
+	defer g.release()
+
+	f := d.Queue("UPDATE t SET a = 1")
+	g.wait(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := f.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitContext = %v", err)
+	}
+	g.release()
+	if _, _, err := waitFuture(t, f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueShutdownDrains(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{})
+	g := gateQueue(d)
+	defer g.release()
+
+	var futures []*QueueFuture
+	for i := 0; i < 3; i++ {
+		futures = append(futures, d.Queue("INSERT INTO t VALUES (?)", i))
+	}
+	g.wait(t)
+
+	type shutdown struct {
+		left []*QueueItem
+		err  error
+	}
+	done := make(chan shutdown, 1)
+	go func() {
+		left, err := d.QueueShutdown(context.Background())
+		done <- shutdown{left, err}
+	}()
+	q := d.getQueue()
+	for {
+		q.lock.Lock()
+		closed := q.closed
+		q.lock.Unlock()
+		if closed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 关闭后不再接受新的语句
+	if _, _, err := waitFuture(t, d.Queue("DELETE FROM t")); err != ErrQueueClosed {
+		t.Fatalf("push after shutdown = %v", err)
+	}
+	g.release()
+	res := <-done
+	if res.err != nil || len(res.left) != 0 {
+		t.Fatalf("Shutdown = %v, %v", res.left, res.err)
+	}
+	for _, f := range futures {
+		if _, _, err := waitFuture(t, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(srv.queries()); n != 3 {
+		t.Fatalf("executed %d statements, want 3", n)
+	}
+	if s := d.QueueStats(); s.Rejected != 1 || s.Succeeded != 3 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestQueueShutdownTimeout(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{})
+	g := gateQueue(d)
+	defer g.release()
+
+	first := d.Queue("UPDATE t SET a = 1")
+	g.wait(t)
+	second := d.Queue("UPDATE t SET a = 2")
+	later := d.QueueAfter(time.Hour, "UPDATE t SET a = 3")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	left, err := d.QueueShutdown(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown err = %v", err)
+	}
+	if len(left) != 2 || left[0].Query != "UPDATE t SET a = 2" || left[1].Query != "UPDATE t SET a = 3" {
+		t.Fatalf("left = %v", left)
+	}
+	for _, f := range []*QueueFuture{second, later} {
+		if _, _, err := waitFuture(t, f); err != ErrQueueClosed {
+			t.Fatalf("unexecuted statement err = %v", err)
+		}
+	}
+
+	// 正在执行的语句仍会完成
+	g.release()
+	if _, _, err := waitFuture(t, first); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新开启后可以重新入队未执行的语句
+	d.QueueStart()
+	if _, _, err := waitFuture(t, Requeue(left[0])); err != nil {
+		t.Fatal(err)
+	}
+	q := srv.queries()
+	if len(q) != 2 || q[1] != "UPDATE t SET a = 2" {
+		t.Fatalf("queries = %q", q)
+	}
+}
+
+func TestQueueShutdownAll(t *testing.T) {
+	d1, srv1 := newQueueDB(t, QueueOptions{})
+	d2, srv2 := newQueueDB(t, QueueOptions{})
+	f1 := d1.Queue("UPDATE a SET x = 1")
+	f2 := d2.Queue("UPDATE b SET x = 1")
+
+	left, err := QueueShutdown(context.Background())
+	if err != nil || len(left) != 0 {
+		t.Fatalf("QueueShutdown = %v, %v", left, err)
+	}
+	waitFuture(t, f1)
+	waitFuture(t, f2)
+	if len(srv1.queries()) != 1 || len(srv2.queries()) != 1 {
+		t.Fatal("all queues should be drained")
+	}
+	if _, _, err = waitFuture(t, d1.Queue("UPDATE a SET x = 2")); err != ErrQueueClosed {
+		t.Fatalf("push after QueueShutdown = %v", err)
+	}
+
+	QueueStart()
+	if _, _, err = waitFuture(t, d2.Queue("UPDATE b SET x = 2")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueClose(t *testing.T) {
+	d, _ := newTestDB(t)
+	if d.QueueStats() != (QueueStats{}) || d.DeadLetters() != nil || d.currentQueue() != nil {
+		t.Fatal("reading queue state should not create the queue")
+	}
+	if left, err := d.QueueShutdown(context.Background()); left != nil || err != nil || d.currentQueue() != nil {
+		t.Fatal("QueueShutdown should not create the queue")
+	}
+
+	// 关闭数据库时停止队列的执行协程并移除队列
+	queues, goroutines := len(getAllQueues()), runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		d, _ := newTestDB(t)
+		waitFuture(t, d.Queue("UPDATE t SET a = 1"))
+		d.Close()
+		if _, _, err := waitFuture(t, d.Queue("UPDATE t SET a = 2")); err != ErrQueueClosed {
+			t.Fatalf("err = %v", err)
+		}
+	}
+	if n := len(getAllQueues()); n != queues {
+		t.Fatalf("queues = %d, want %d", n, queues)
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines+2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines = %d, want about %d", runtime.NumGoroutine(), goroutines)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueueOverflowReject(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{Capacity: 1, Overflow: OverflowReject})
+	g := gateQueue(d)
+	defer g.release()
+
+	first := d.Queue("UPDATE t SET a = 1")
+	g.wait(t)
+	second := d.Queue("UPDATE t SET a = 2")
+	if _, _, err := waitFuture(t, d.Queue("UPDATE t SET a = 3")); err != ErrQueueFull {
+		t.Fatalf("err = %v", err)
+	}
+	g.release()
+	waitFuture(t, first)
+	waitFuture(t, second)
+	if q := srv.queries(); len(q) != 2 {
+		t.Fatalf("queries = %q", q)
+	}
+	if s := d.QueueStats(); s.Rejected != 1 || s.Succeeded != 2 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestQueueOverflowDropOldest(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{Capacity: 2, Overflow: OverflowDropOldest})
+	g := gateQueue(d)
+	defer g.release()
+
+	d.Queue("UPDATE t SET a = 1")
+	g.wait(t)
+	oldest := d.Queue("UPDATE t SET a = 2")
+	d.Queue("UPDATE t SET a = 3")
+	last := d.Queue("UPDATE t SET a = 4")
+	if _, _, err := waitFuture(t, oldest); err != ErrQueueDropped {
+		t.Fatalf("oldest err = %v", err)
+	}
+	g.release()
+	waitFuture(t, last)
+	want := []string{"UPDATE t SET a = 1", "UPDATE t SET a = 3", "UPDATE t SET a = 4"}
+	if q := srv.queries(); strings.Join(q, ";") != strings.Join(want, ";") {
+		t.Fatalf("queries = %q", q)
+	}
+	if s := d.QueueStats(); s.Dropped != 1 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestQueueOverflowBlock(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{Capacity: 1})
+	g := gateQueue(d)
+	defer g.release()
+
+	d.Queue("UPDATE t SET a = 1")
+	g.wait(t)
+	d.Queue("UPDATE t SET a = 2")
+
+	pushed := make(chan *QueueFuture, 1)
+	go func() { pushed <- d.Queue("UPDATE t SET a = 3") }()
+	select {
+	case <-pushed:
+		t.Fatal("push to a full queue should block")
+	case <-time.After(20 * time.Millisecond):
+	}
+	g.release()
+	select {
+	case f := <-pushed:
+		if _, _, err := waitFuture(t, f); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked push did not return")
+	}
+	if n := len(srv.queries()); n != 3 {
+		t.Fatalf("executed %d statements, want 3", n)
+	}
+}
+
+func TestQueueWorkers(t *testing.T) {
+	d, srv := newQueueDB(t, QueueOptions{Workers: 3})
+	g := gateQueue(d)
+	defer g.release()
+
+	var futures []*QueueFuture
+	for i := 0; i < 4; i++ {
+		futures = append(futures, d.Queue("UPDATE t SET a = ?", i))
+	}
+	// 三个协程同时执行, 第四条语句等待
+	for i := 0; i < 3; i++ {
+		g.wait(t)
+	}
+	if s := d.QueueStats(); s.Active != 3 || s.Pending != 1 {
+		t.Fatalf("stats = %+v", s)
+	}
+	g.release()
+	for _, f := range futures {
+		waitFuture(t, f)
+	}
+	if n := len(srv.queries()); n != 4 {
+		t.Fatalf("executed %d statements, want 4", n)
+	}
+}
+
+func TestSetQueueOptionsRunning(t *testing.T) {
+	d, _ := newQueueDB(t, QueueOptions{})
+	waitFuture(t, d.Queue("UPDATE t SET a = 1"))
+
+	// 运行中的队列以新的选项重新开始
+	d.SetQueueOptions(QueueOptions{Workers: 2, Capacity: 1, Overflow: OverflowReject})
+	g := gateQueue(d)
+	defer g.release()
+	d.Queue("UPDATE t SET a = 2")
+	g.wait(t)
+	d.Queue("UPDATE t SET a = 3")
+	g.wait(t)
+	d.Queue("UPDATE t SET a = 4")
+	if _, _, err := waitFuture(t, d.Queue("UPDATE t SET a = 5")); err != ErrQueueFull {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestGetQueueStats(t *testing.T) {
+	before := GetQueueStats()
+	d1, srv1 := newQueueDB(t, QueueOptions{})
+	d2, _ := newQueueDB(t, QueueOptions{})
+	srv1.fail(errors.New("Error 1064: syntax error"))
+	waitFuture(t, d1.Queue("UPDAT t"))
+	waitFuture(t, d1.Queue("UPDATE t SET a = 1"))
+	waitFuture(t, d2.Queue("UPDATE t SET a = 1"))
+
+	after := GetQueueStats()
+	if after.Succeeded-before.Succeeded != 2 || after.Failed-before.Failed != 1 {
+		t.Fatalf("before = %+v, after = %+v", before, after)
+	}
+}
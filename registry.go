@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	registry     = make(map[string]*Database)
+	defaultName  string
+	registryLock sync.RWMutex
+)
+
+// 注册数据库对象, 第一个注册的数据库成为默认数据库; 同名的数据库将被替换
+func Register(name string, d *Database) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = d
+	if defaultName == "" {
+		defaultName = name
+	}
+}
+
+// 取消注册数据库对象, 不会关闭数据库连接
+func Unregister(name string) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	delete(registry, name)
+	if defaultName == name {
+		defaultName = ""
+	}
+}
+
+// 获取已注册的数据库对象, 不存在时返回nil
+func Use(name string) *Database {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return registry[name]
+}
+
+// 设置默认数据库
+func SetDefault(name string) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[name]; !ok {
+		return fmt.Errorf("database %q is not registered", name)
+	}
+	defaultName = name
+	return nil
+}
+
+// 获取默认数据库, 未注册任何数据库时返回 Obj
+// SQ 构造的语句默认使用该数据库
+func Default() *Database {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	if d, ok := registry[defaultName]; ok {
+		return d
+	}
+	return Obj
+}
+
+// 打开数据库的选项
+type OpenOptions struct {
+	MaxOpenConns    int           // 最大连接数, 0表示不限制
+	MaxIdleConns    int           // 最大空闲连接数, 0表示使用 database/sql 的默认值
+	ConnMaxLifetime time.Duration // 连接的最长使用时间, 0表示不限制
+	ConnMaxIdleTime time.Duration // 连接的最长空闲时间, 0表示不限制
+	Ping            bool          // 打开后是否检查连接
+	Default         bool          // 是否设为默认数据库
+}
+
+// 按驱动名称获取数据库类型
+func driverType(driverName string) string {
+	switch driverName {
+	case "pgx", "postgres", "postgresql":
+		return "postgres"
+	case "sqlserver", "mssql":
+		return "mssql"
+	case "godror", "oci8", "oracle":
+		return "oracle"
+	}
+	return driverName
+}
+
+// 打开数据库并以name注册, opts可为nil
+func Open(name, driverName, dsn string, opts *OpenOptions) (*Database, error) {
+	if opts == nil {
+		opts = &OpenOptions{}
+	}
+	conn, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	conn.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	conn.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	if opts.Ping {
+		if err = conn.Ping(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	d := &Database{Type: driverType(driverName), DB: conn}
+	Register(name, d)
+	if opts.Default {
+		SetDefault(name)
+	}
+	return d, nil
+}
+
+// 使用已注册的数据库对象
+// 数据库未注册时语句执行将返回错误
+func (q *SQ) On(name string) *SQ {
+	q.db = Use(name)
+	if q.db == nil {
+		q.err = fmt.Errorf("database %q is not registered", name)
+	} else {
+		q.err = nil
+	}
+	return q
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// 测试期间使用空的注册表, 结束时恢复
+func isolateRegistry(t *testing.T) {
+	registryLock.Lock()
+	old, oldDefault, oldObj := registry, defaultName, Obj
+	registry, defaultName, Obj = make(map[string]*Database), "", nil
+	registryLock.Unlock()
+	t.Cleanup(func() {
+		registryLock.Lock()
+		registry, defaultName, Obj = old, oldDefault, oldObj
+		registryLock.Unlock()
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	isolateRegistry(t)
+	a, _ := newTestDB(t)
+	b, _ := newTestDB(t)
+
+	if Default() != nil {
+		t.Fatal("Default should be nil without databases")
+	}
+	Register("a", a)
+	Register("b", b)
+	if Use("a") != a || Use("b") != b || Use("c") != nil {
+		t.Fatal("Use should return registered databases")
+	}
+	if Default() != a {
+		t.Fatal("first registered database should be the default")
+	}
+	if err := SetDefault("b"); err != nil || Default() != b {
+		t.Fatalf("SetDefault = %v", err)
+	}
+	if err := SetDefault("c"); err == nil || !strings.Contains(err.Error(), `"c" is not registered`) {
+		t.Fatalf("SetDefault unknown = %v", err)
+	}
+
+	Unregister("b")
+	if Use("b") != nil || Default() != nil {
+		t.Fatal("unregistered default database should not be used")
+	}
+	Obj = a
+	if Default() != a {
+		t.Fatal("Default should fall back to Obj")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	isolateRegistry(t)
+	dsn, srv := newTestServer(t)
+
+	d, err := Open("main", "test", dsn, &OpenOptions{MaxOpenConns: 2, Ping: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if Use("main") != d || Default() != d {
+		t.Fatal("opened database should be registered as default")
+	}
+	if d.Type != "test" || d.DB.Stats().MaxOpenConnections != 2 {
+		t.Fatalf("Type = %q, stats = %+v", d.Type, d.DB.Stats())
+	}
+
+	// 检查连接失败时不注册
+	srv.pingErr = errors.New("Error 1045: Access denied")
+	if _, err = Open("bad", "test", dsn, &OpenOptions{Ping: true}); err == nil || Use("bad") != nil {
+		t.Fatalf("Open with ping error = %v", err)
+	}
+	srv.pingErr = nil
+
+	other, err := Open("other", "test", dsn, &OpenOptions{Default: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if Default() != other {
+		t.Fatal("Default option should set the default database")
+	}
+	if _, err = Open("x", "missing", dsn, nil); err == nil {
+		t.Fatal("unknown driver should fail")
+	}
+
+	for name, want := range map[string]string{"pgx": "postgres", "postgresql": "postgres", "mssql": "mssql", "godror": "oracle", "mysql": "mysql"} {
+		if got := driverType(name); got != want {
+			t.Errorf("driverType(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSQOn(t *testing.T) {
+	isolateRegistry(t)
+	a, as := newTestDB(t)
+	b, bs := newTestDB(t)
+	Register("a", a)
+	Register("b", b)
+
+	Update().Table("t").Value(Values{"a": 1}).Where("id = 1").Exec()
+	Update().On("b").Table("t").Value(Values{"a": 1}).Where("id = 1").Exec()
+	if len(as.queries()) != 1 || len(bs.queries()) != 1 {
+		t.Fatal("statements should use the default or named database")
+	}
+
+	ret := Update().On("c").Table("t").Value(Values{"a": 1}).Where("id = 1").Exec()
+	if ret.Err == nil || !strings.Contains(ret.Err.Error(), `"c" is not registered`) {
+		t.Fatalf("Exec on unknown database = %v", ret.Err)
+	}
+	// 之后指定存在的数据库时清除错误
+	if ret = Update().On("c").On("a").Table("t").Value(Values{"a": 1}).Where("id = 1").Exec(); ret.Err != nil {
+		t.Fatal(ret.Err)
+	}
+}
+
+func TestSQWithoutDatabase(t *testing.T) {
+	isolateRegistry(t)
+
+	// 未设置数据库时可以构建语句, 按MySQL语法生成
+	s, err := Select("id").Table("t").Limit(10).ToSql()
+	if err != nil || s != "SELECT id FROM t LIMIT 10" {
+		t.Fatalf("ToSql = %q, %v", s, err)
+	}
+	if s, err = Delete().Table("t").Where("id = 1").Limit(1).ToSql(); err != nil || s != "DELETE FROM t WHERE id = 1 LIMIT 1" {
+		t.Fatalf("ToSql = %q, %v", s, err)
+	}
+
+	// 执行时返回错误
+	if ret := Delete().Table("t").Where("id = 1").Exec(); ret.Err == nil || !strings.Contains(ret.Err.Error(), "database is not set") {
+		t.Fatalf("Exec = %v", ret.Err)
+	}
+	if _, err = Select("id").Table("t").Query(); err == nil || !strings.Contains(err.Error(), "database is not set") {
+		t.Fatalf("Query = %v", err)
+	}
+	var n int64
+	if err = Select("id").Table("t").QueryRow().Scan(&n); err == nil || !strings.Contains(err.Error(), "database is not set") {
+		t.Fatalf("QueryRow = %v", err)
+	}
+	if _, err = Select().Table("t").Count(); err == nil || !strings.Contains(err.Error(), "database is not set") {
+		t.Fatalf("Count = %v", err)
+	}
+}
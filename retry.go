@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// 重试策略
+// 死锁与锁等待超时时语句已被数据库回滚, 可以安全重试;
+// 连接丢失时无法确认语句是否已经执行, 只有查询或被标记为幂等的语句才会重试
+type RetryPolicy struct {
+	MaxAttempts int                                               // 最大尝试次数(包含首次执行), 小于等于1表示不重试
+	BaseDelay   time.Duration                                     // 首次重试前的等待时间, 之后每次翻倍
+	MaxDelay    time.Duration                                     // 最大等待时间, 0表示不限制
+	Jitter      float64                                           // 随机抖动比例(0~1), 用于避免多个请求同时重试
+	Retryable   []ErrorClass                                      // 可重试的错误分类, 为空时使用 DefaultRetryable
+	OnRetry     func(attempt int, err error, delay time.Duration) // 每次重试前的回调, attempt 为即将进行的第几次尝试
+}
+
+// 默认可重试的错误分类
+var DefaultRetryable = []ErrorClass{ClassDeadlock, ClassLockWaitTimeout, ClassConnectionLost}
+
+// 获取一个默认的重试策略
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   20 * time.Millisecond,
+		MaxDelay:    time.Second,
+		Jitter:      0.2,
+	}
+}
+
+type idempotentKey struct{}
+
+// 将上下文标记为幂等, 连接丢失时也会重试使用该上下文执行的语句
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// 上下文是否被标记为幂等
+func isIdempotent(ctx context.Context) bool {
+	v, _ := ctx.Value(idempotentKey{}).(bool)
+	return v
+}
+
+// 判断错误是否可重试
+func (p *RetryPolicy) retryable(err error, idempotent bool) bool {
+	class := ErrorClassOf(err)
+	if class == ClassConnectionLost && !idempotent {
+		return false
+	}
+	classes := p.Retryable
+	if len(classes) == 0 {
+		classes = DefaultRetryable
+	}
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// 计算第attempt次重试前的等待时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 && d > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// 按重试策略执行函数
+func (p *RetryPolicy) do(ctx context.Context, idempotent bool, fn func() error) error {
+	err := fn()
+	if p == nil {
+		return err
+	}
+	for attempt := 2; err != nil && attempt <= p.MaxAttempts && p.retryable(err, idempotent); attempt++ {
+		delay := p.backoff(attempt - 1)
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		err = fn()
+	}
+	return err
+}
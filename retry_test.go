@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	errTestDeadlock = errors.New("Error 1213: Deadlock found when trying to get lock")
+	errTestLost     = errors.New("Error 2013: Lost connection to MySQL server during query")
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if d := p.backoff(i + 1); d != w*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, d, w*time.Millisecond)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(2); d < 10*time.Millisecond || d > 30*time.Millisecond {
+			t.Fatalf("backoff with jitter = %v", d)
+		}
+	}
+
+	p = &RetryPolicy{BaseDelay: time.Millisecond}
+	if d := p.backoff(11); d != 1024*time.Millisecond {
+		t.Fatalf("backoff without MaxDelay = %v", d)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	p := NewRetryPolicy(3)
+	if !p.retryable(errTestDeadlock, false) {
+		t.Fatal("deadlock should be retryable")
+	}
+	if p.retryable(errTestLost, false) {
+		t.Fatal("connection lost should not be retried for non idempotent statements")
+	}
+	if !p.retryable(errTestLost, true) {
+		t.Fatal("connection lost should be retried for idempotent statements")
+	}
+	if p.retryable(errors.New("Error 1062: Duplicate entry"), true) {
+		t.Fatal("duplicate should not be retryable")
+	}
+	p.Retryable = []ErrorClass{ClassDuplicate}
+	if p.retryable(errTestDeadlock, true) || !p.retryable(errors.New("Error 1062: Duplicate entry"), false) {
+		t.Fatal("Retryable should replace the default classes")
+	}
+}
+
+func TestExecRetry(t *testing.T) {
+	d, srv := newTestDB(t)
+	var attempts []int
+	d.Retry = &RetryPolicy{
+		MaxAttempts: 3,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			if !IsDeadlock(err) {
+				t.Errorf("OnRetry err = %v", err)
+			}
+			attempts = append(attempts, attempt)
+		},
+	}
+
+	srv.fail(errTestDeadlock, errTestDeadlock)
+	if _, err := d.Exec("UPDATE t SET a = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.queries()); n != 3 {
+		t.Fatalf("executed %d times, want 3", n)
+	}
+	if !reflect.DeepEqual(attempts, []int{2, 3}) {
+		t.Fatalf("OnRetry attempts = %v", attempts)
+	}
+
+	// 超过最大尝试次数时返回最后的错误
+	srv.reset()
+	srv.fail(errTestDeadlock, errTestDeadlock, errTestDeadlock)
+	if _, err := d.Exec("UPDATE t SET a = 1"); !IsDeadlock(err) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := len(srv.queries()); n != 3 {
+		t.Fatalf("executed %d times, want 3", n)
+	}
+}
+
+func TestExecRetryConnectionLost(t *testing.T) {
+	d, srv := newTestDB(t)
+	d.Retry = &RetryPolicy{MaxAttempts: 3}
+
+	srv.fail(errTestLost)
+	if _, err := d.Exec("INSERT INTO t VALUES (1)"); !IsConnectionLost(err) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := len(srv.queries()); n != 1 {
+		t.Fatalf("non idempotent statement executed %d times, want 1", n)
+	}
+
+	srv.reset()
+	srv.fail(errTestLost)
+	if _, err := d.ExecIdempotent("UPDATE t SET a = 1 WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.queries()); n != 2 {
+		t.Fatalf("idempotent statement executed %d times, want 2", n)
+	}
+
+	// 查询总是幂等的
+	srv.reset()
+	srv.fail(errTestLost)
+	if _, err := d.Select("SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.queries()); n != 2 {
+		t.Fatalf("query executed %d times, want 2", n)
+	}
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	d, srv := newTestDB(t)
+	d.Retry = &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour}
+	srv.fail(errTestDeadlock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := d.ExecContext(ctx, "UPDATE t SET a = 1"); !IsDeadlock(err) {
+		t.Fatalf("err = %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("retry should stop when the context is done")
+	}
+	if n := len(srv.queries()); n != 1 {
+		t.Fatalf("executed %d times, want 1", n)
+	}
+}
+
+func TestTransactionRetry(t *testing.T) {
+	d, srv := newTestDB(t)
+	d.Retry = &RetryPolicy{MaxAttempts: 2}
+
+	srv.fail(nil, errTestDeadlock)
+	calls := 0
+	err := d.Transaction(func(tx *Tx) error {
+		calls++
+		_, err := tx.Exec("UPDATE t SET a = 1")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("transaction function called %d times, want 2", calls)
+	}
+	want := []string{"BEGIN", "UPDATE t SET a = 1", "ROLLBACK", "BEGIN", "UPDATE t SET a = 1", "COMMIT"}
+	if q := srv.queries(); !reflect.DeepEqual(q, want) {
+		t.Fatalf("queries = %q", q)
+	}
+}
+
+func TestNoRetryPolicy(t *testing.T) {
+	d, srv := newTestDB(t)
+	srv.fail(errTestDeadlock)
+	if _, err := d.Exec("UPDATE t SET a = 1"); !IsDeadlock(err) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := len(srv.queries()); n != 1 {
+		t.Fatalf("executed %d times, want 1", n)
+	}
+}
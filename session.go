@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 会话, 用于在主从集群中读取自己的写入
+// 使用会话上下文执行写入后, 在 Cluster.PinWindow 时间内的查询都发送到主库;
+// 开启了 Cluster.WaitGTID 时, 写入后记录主库已执行的GTID集合, 查询在从库上等待追上该集合后再执行, 超时则发送到主库;
+// 从库追上后记录在会话中, 下一次写入前该从库上的查询不再等待
+type Session struct {
+	lock      sync.Mutex
+	lastWrite time.Time
+	gtid      string
+	caught    map[*replica]bool // 已追上 gtid 的从库
+}
+
+type sessionKey struct{}
+
+// 创建一个会话并放入上下文
+func NewSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, &Session{})
+}
+
+// 获取上下文中的会话, 不存在时返回nil
+func SessionFrom(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// 最后一次写入的时间
+func (s *Session) LastWrite() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastWrite
+}
+
+// 记录写入, 需要时获取主库已执行的GTID集合
+func (s *Session) wrote(ctx context.Context, db *Database) {
+	var gtid string
+	if c := db.cluster; c != nil && c.WaitGTID {
+		row := db.QueryRowContext(ForcePrimary(ctx), "SELECT @@GLOBAL.gtid_executed")
+		if err := row.Scan(&gtid); err != nil {
+			logWari("获取GTID失败: ", err)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastWrite = time.Now()
+	if gtid != "" && gtid != s.gtid {
+		s.gtid = gtid
+		s.caught = nil
+	}
+}
+
+// 从库是否已追上GTID集合
+func (s *Session) caughtUp(r *replica, gtid string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.gtid == gtid && s.caught[r]
+}
+
+// 记录从库已追上GTID集合, 期间有新的写入时忽略
+func (s *Session) markCaughtUp(r *replica, gtid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.gtid != gtid {
+		return
+	}
+	if s.caught == nil {
+		s.caught = make(map[*replica]bool)
+	}
+	s.caught[r] = true
+}
+
+// 获取会话状态
+func (s *Session) state() (time.Time, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastWrite, s.gtid
+}
+
+// 使用会话上下文执行写入后记录写入
+func (this *Database) markWrite(ctx context.Context) {
+	if this.cluster == nil {
+		return
+	}
+	if s := SessionFrom(ctx); s != nil {
+		s.wrote(ctx, this)
+	}
+}
+
+// 为会话中的查询选择从库, 需要使用主库时返回nil
+func (c *Cluster) route(ctx context.Context) *replica {
+	if isForcePrimary(ctx) {
+		return nil
+	}
+	s := SessionFrom(ctx)
+	if s == nil {
+		return c.pick()
+	}
+	lastWrite, gtid := s.state()
+	if lastWrite.IsZero() {
+		return c.pick()
+	}
+	if c.WaitGTID && gtid != "" {
+		r := c.pick()
+		if r == nil {
+			return nil
+		}
+		if s.caughtUp(r, gtid) {
+			return r
+		}
+		if c.waitGTID(ctx, r, gtid) {
+			s.markCaughtUp(r, gtid)
+			return r
+		}
+		return nil
+	}
+	if time.Since(lastWrite) < c.PinWindow {
+		return nil
+	}
+	return c.pick()
+}
+
+// 在从库上等待GTID集合执行完成, 超时或出错时返回false
+func (c *Cluster) waitGTID(ctx context.Context, r *replica, gtid string) bool {
+	timeout := c.GTIDTimeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	var ret int
+	row := r.db.QueryRowContext(ctx, "SELECT WAIT_FOR_EXECUTED_GTID_SET(?, ?)", gtid, strconv.FormatFloat(timeout.Seconds(), 'f', 3, 64))
+	if err := row.Scan(&ret); err != nil {
+		return false
+	}
+	return ret == 0
+}
+
+// 测量所有从库的复制延迟
+// 使用 SHOW REPLICA STATUS (MySQL 8.0.22 之前为 SHOW SLAVE STATUS) 获取落后主库的秒数, 复制未运行时延迟为-1
+func (c *Cluster) MeasureLag(ctx context.Context) {
+	c.lock.RLock()
+	replicas := append([]*replica(nil), c.replicas...)
+	c.lock.RUnlock()
+	for _, r := range replicas {
+		lag := replicaLag(ctx, r.db)
+		c.lock.Lock()
+		r.lag = lag
+		c.lock.Unlock()
+	}
+}
+
+// 获取从库的复制延迟, 未知时为-1
+func replicaLag(ctx context.Context, db *Database) time.Duration {
+	row, err := db.SelectOneContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		row, err = db.SelectOneContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return -1
+	}
+	for _, field := range []string{"Seconds_Behind_Source", "Seconds_Behind_Master"} {
+		if v, ok := row[field]; ok {
+			sec, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return -1
+			}
+			return time.Duration(sec) * time.Second
+		}
+	}
+	return -1
+}
+
+// 定时测量从库的复制延迟, 上下文结束时停止
+func (c *Cluster) StartLagMonitor(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			c.MeasureLag(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
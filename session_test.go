@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionPinWindow(t *testing.T) {
+	p, ps := newTestDB(t)
+	r, rs := newTestDB(t)
+	c := NewCluster(p, r)
+	c.PinWindow = 30 * time.Millisecond
+	ctx := NewSession(context.Background())
+
+	p.SelectContext(ctx, "SELECT 1")
+	if len(rs.queries()) != 1 {
+		t.Fatal("session without writes should read from the replica")
+	}
+	p.ExecContext(ctx, "UPDATE t SET a = 1")
+	if SessionFrom(ctx).LastWrite().IsZero() {
+		t.Fatal("write should be recorded in the session")
+	}
+	p.SelectContext(ctx, "SELECT 2")
+	if q := ps.queries(); len(q) != 2 || q[1] != "SELECT 2" {
+		t.Fatalf("primary queries = %q", q)
+	}
+	// 其他上下文不受影响
+	p.Select("SELECT 3")
+	if len(rs.queries()) != 2 {
+		t.Fatal("queries outside the session should read from the replica")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	p.SelectContext(ctx, "SELECT 4")
+	if len(rs.queries()) != 3 {
+		t.Fatal("session should read from the replica after PinWindow")
+	}
+
+	// 事务提交后同样记录写入
+	p.TransactionContext(ctx, nil, func(tx *Tx) error {
+		_, err := tx.Exec("UPDATE t SET a = 2")
+		return err
+	})
+	p.SelectContext(ctx, "SELECT 5")
+	if q := ps.queries(); q[len(q)-1] != "SELECT 5" {
+		t.Fatalf("primary queries = %q", q)
+	}
+}
+
+func TestSessionWaitGTID(t *testing.T) {
+	p, ps := newTestDB(t)
+	r, rs := newTestDB(t)
+	c := NewCluster(p, r)
+	c.WaitGTID = true
+
+	var gtid int32
+	ps.handle = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if query == "SELECT @@GLOBAL.gtid_executed" {
+			return []string{"gtid"}, [][]driver.Value{{[]byte(fmt.Sprintf("uuid:1-%d", atomic.LoadInt32(&gtid)))}}, nil
+		}
+		return nil, nil, nil
+	}
+	var behind int32
+	rs.handle = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT WAIT_FOR_EXECUTED_GTID_SET") {
+			return []string{"ret"}, [][]driver.Value{{int64(atomic.LoadInt32(&behind))}}, nil
+		}
+		return nil, nil, nil
+	}
+	ctx := NewSession(context.Background())
+
+	atomic.StoreInt32(&gtid, 5)
+	p.ExecContext(ctx, "UPDATE t SET a = 1")
+	p.SelectContext(ctx, "SELECT 1")
+	p.SelectContext(ctx, "SELECT 2")
+
+	// 从库追上后同一会话的查询不再等待
+	want := []string{"SELECT WAIT_FOR_EXECUTED_GTID_SET(?, ?)", "SELECT 1", "SELECT 2"}
+	if q := rs.queries(); !reflect.DeepEqual(q, want) {
+		t.Fatalf("replica queries = %q", q)
+	}
+	if args := rs.args(0); len(args) != 2 || args[0] != "uuid:1-5" || args[1] != "1.000" {
+		t.Fatalf("wait args = %v", args)
+	}
+
+	// 新的写入后重新等待, 超时则发送到主库
+	atomic.StoreInt32(&gtid, 6)
+	atomic.StoreInt32(&behind, 1)
+	p.ExecContext(ctx, "UPDATE t SET a = 2")
+	p.SelectContext(ctx, "SELECT 3")
+	if q := ps.queries(); q[len(q)-1] != "SELECT 3" {
+		t.Fatalf("primary queries = %q", q)
+	}
+	atomic.StoreInt32(&behind, 0)
+	p.SelectContext(ctx, "SELECT 4")
+	if q := rs.queries(); len(q) != 6 || q[5] != "SELECT 4" {
+		t.Fatalf("replica queries = %q", q)
+	}
+}
+
+func TestMeasureLag(t *testing.T) {
+	p, _ := newTestDB(t)
+	r1, rs1 := newTestDB(t)
+	r2, rs2 := newTestDB(t)
+	r3, rs3 := newTestDB(t)
+	c := NewCluster(p, r1, r2, r3)
+
+	rs1.handle = func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if query == "SHOW REPLICA STATUS" {
+			return nil, nil, errors.New("Error 1064: syntax error")
+		}
+		return []string{"Seconds_Behind_Master"}, [][]driver.Value{{[]byte("3")}}, nil
+	}
+	rs2.result([]string{"Seconds_Behind_Source"}, []driver.Value{nil})
+	rs3.result([]string{"Seconds_Behind_Source"}, []driver.Value{[]byte("0")})
+
+	c.MeasureLag(context.Background())
+	var lags []time.Duration
+	for _, st := range c.Replicas() {
+		lags = append(lags, st.Lag)
+	}
+	if !reflect.DeepEqual(lags, []time.Duration{3 * time.Second, -1, 0}) {
+		t.Fatalf("lags = %v", lags)
+	}
+
+	// 延迟超过 MaxLag 的从库不参与查询
+	c.MaxLag = time.Second
+	for i := 0; i < 2; i++ {
+		p.Select("SELECT 1")
+	}
+	if n := len(rs3.queries()); n != 3 {
+		t.Fatalf("replica within MaxLag executed %d statements, want 3", n)
+	}
+}
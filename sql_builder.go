@@ -1,14 +1,15 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -27,7 +28,7 @@ const (
 
 // SQL语句构造结构
 type SQ struct {
-	db                                       *Database // 默认使用Obj数据库对象
+	db                                       *Database // 默认使用 Default() 数据库对象
 	t                                        int
 	field, table, where, group, order, limit string
 	values                                   Values
@@ -36,13 +37,21 @@ type SQ struct {
 	fullsql                                  bool
 	debug                                    bool
 	unsafe                                   bool //是否进行安全检查, 专门针对无限定的UPDATE和DELETE进行二次验证
+	idempotent                               bool //是否为幂等语句, 幂等语句在连接丢失时也会重试
 	args                                     []interface{}
+	ctx                                      context.Context
+	cache                                    bool          //查询结果是否使用缓存
+	cacheTTL                                 time.Duration //查询缓存的过期时间
+	cacheGroup                               string        //查询缓存的分组
+	forcePrimary                             bool          //是否强制使用主库
+	err                                      error         //构造语句时的错误
+	keys                                     []seekKey     //游标分页的排序键
 }
 
 // Exec返回结果
 type result struct {
 	Success  bool   //语句是否执行成功
-	Code     int    //错误代码
+	Code     int    //错误代码(数据库返回的错误码), 错误分类可通过 ErrorClassOf(Err) 获取
 	Err      error  //错误提示信息
 	LastID   int64  //最后产生的ID
 	Affected int64  //受影响的行数
@@ -181,9 +190,29 @@ func FullSql(str string, args ...interface{}) (string, error) {
 	return ret, nil
 }
 
+// 数据库类型, 未设置数据库时按 mysql 构造语句
+func (q *SQ) dbType() string {
+	if q.db == nil {
+		return "mysql"
+	}
+	return q.db.system()
+}
+
+// 构造要执行的语句, 未设置数据库时返回错误
+func (q *SQ) execSql() (string, error) {
+	s, err := q.ToSql()
+	if err == nil && q.db == nil {
+		err = errors.New("database is not set, use Open/Register or SQ.DB")
+	}
+	return s, err
+}
+
 // 构建SQL语句
 // param: returnFullSql 是否返回完整的sql语句(即:绑定参数之后的语句)
 func (q *SQ) ToSql(returnFullSql ...bool) (str string, err error) {
+	if q.err != nil {
+		return "", q.err
+	}
 	q.args = make([]interface{}, 0)
 	s := strings.Builder{}
 	switch q.t {
@@ -230,7 +259,7 @@ func (q *SQ) ToSql(returnFullSql ...bool) (str string, err error) {
 				s.WriteString(" WHERE ")
 				s.WriteString(q.where)
 			}
-			if q.limit != "" && (q.db.Type == "" || q.db.Type == "mysql") {
+			if q.limit != "" && q.dbType() == "mysql" {
 				s.WriteString(" LIMIT ")
 				s.WriteString(q.limit)
 			}
@@ -249,7 +278,7 @@ func (q *SQ) ToSql(returnFullSql ...bool) (str string, err error) {
 				s.WriteString(" WHERE ")
 				s.WriteString(q.where)
 			}
-			if q.limit != "" && (q.db.Type == "" || q.db.Type == "mysql") {
+			if q.limit != "" && q.dbType() == "mysql" {
 				s.WriteString(" LIMIT ")
 				s.WriteString(q.limit)
 			}
@@ -276,7 +305,7 @@ func (q *SQ) ToSql(returnFullSql ...bool) (str string, err error) {
 			placeholder = q.buildUpdateParams(q.values2)
 			s.WriteString(Substr(placeholder, 1))
 
-			if q.limit != "" && (q.db.Type == "" || q.db.Type == "mysql") {
+			if q.limit != "" && q.dbType() == "mysql" {
 				s.WriteString(" LIMIT ")
 				s.WriteString(q.limit)
 			}
@@ -300,7 +329,7 @@ func (q *SQ) ToSql(returnFullSql ...bool) (str string, err error) {
 			s.WriteString(" ORDER BY ")
 			s.WriteString(q.order)
 		}
-		if q.limit != "" && (q.db.Type == "" || q.db.Type == "mysql") {
+		if q.limit != "" && q.dbType() == "mysql" {
 			s.WriteString(" LIMIT ")
 			s.WriteString(q.limit)
 		}
@@ -331,6 +360,7 @@ func (q *SQ) buildUpdateParams(vals Values) string {
 // 设置数据库对象
 func (q *SQ) DB(db *Database) *SQ {
 	q.db = db
+	q.err = nil
 	return q
 }
 
@@ -383,7 +413,7 @@ func (q *SQ) Unsafe(unsefe ...bool) *SQ {
 	return q
 }
 
-// 是否Debug
+// 是否Debug, 开启后语句执行时将以INFO级别输出到数据库的日志中
 func (q *SQ) Debug(debug ...bool) *SQ {
 	if len(debug) == 1 && !debug[0] {
 		q.debug = false
@@ -393,6 +423,40 @@ func (q *SQ) Debug(debug ...bool) *SQ {
 	return q
 }
 
+// 设置执行语句使用的上下文
+func (q *SQ) Context(ctx context.Context) *SQ {
+	q.ctx = ctx
+	return q
+}
+
+// 标记语句为幂等, 连接丢失时也会按重试策略重新执行
+func (q *SQ) Idempotent(yes ...bool) *SQ {
+	if len(yes) == 1 && !yes[0] {
+		q.idempotent = false
+	} else {
+		q.idempotent = true
+	}
+	return q
+}
+
+// 获取执行语句使用的上下文
+func (q *SQ) context() context.Context {
+	ctx := q.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if q.idempotent {
+		ctx = Idempotent(ctx)
+	}
+	if q.debug {
+		ctx = context.WithValue(ctx, debugKey{}, true)
+	}
+	if q.forcePrimary {
+		ctx = ForcePrimary(ctx)
+	}
+	return ctx
+}
+
 // 设置值
 func (q *SQ) Value(m Values) *SQ {
 	q.values = m
@@ -428,22 +492,22 @@ func Insert(ignore ...bool) *SQ {
 	if len(ignore) == 1 && ignore[0] {
 		i = true
 	}
-	return &SQ{t: TypeInsert, db: Obj, ignore: i, values: Values{}, args: make([]interface{}, 0)}
+	return &SQ{t: TypeInsert, db: Default(), ignore: i, values: Values{}, args: make([]interface{}, 0)}
 }
 
 // 构建DELETE语句
 func Delete() *SQ {
-	return &SQ{t: TypeDelete, db: Obj}
+	return &SQ{t: TypeDelete, db: Default()}
 }
 
 // 构建UPDATE语句
 func Update() *SQ {
-	return &SQ{t: TypeUpdate, db: Obj, values: Values{}, args: make([]interface{}, 0)}
+	return &SQ{t: TypeUpdate, db: Default(), values: Values{}, args: make([]interface{}, 0)}
 }
 
 // 构建InsertUpdate语句, 仅针对MySQL有效, 内部使用ON DUPLICATE KEY UPDATE方式实现
 func InsertUpdate() *SQ {
-	return &SQ{t: TypeInsertUpdate, db: Obj, values: Values{}, values2: Values{}, args: make([]interface{}, 0)}
+	return &SQ{t: TypeInsertUpdate, db: Default(), values: Values{}, values2: Values{}, args: make([]interface{}, 0)}
 }
 
 // 构建SELECT语句
@@ -452,7 +516,7 @@ func Select(str ...string) *SQ {
 	if len(str) == 1 {
 		fields = str[0]
 	}
-	return &SQ{t: TypeSelect, db: Obj, field: fields}
+	return &SQ{t: TypeSelect, db: Default(), field: fields}
 }
 
 // 获取构造SQL后的参数
@@ -474,28 +538,28 @@ func (q *SQ) FullSql(yes ...bool) *SQ {
 func (q *SQ) Exec(args ...interface{}) *result {
 	var err error
 	sbRet := &result{}
-	sbRet.Sql, err = q.ToSql()
+	sbRet.Sql, err = q.execSql()
 	if err != nil {
 		sbRet.Err = err
 	} else {
-		if q.debug {
-			log.Println("\n\tSQL prepare statement:\n\t", sbRet.Sql, "\n\tMap args:\n\t", q.args, "\n\tParams:\n\t", args)
-		}
-
 		var ret sql.Result
 		if q.fullsql {
 			var sqlStr string
 			sqlStr, err = FullSql(sbRet.Sql, append(q.args, args...)...)
 			if err == nil {
-				ret, err = q.db.Exec(sqlStr)
+				ret, err = q.db.ExecContext(q.context(), sqlStr)
 			}
 		} else {
-			ret, err = q.db.Exec(sbRet.Sql, append(q.args, args...)...)
+			ret, err = q.db.ExecContext(q.context(), sbRet.Sql, append(q.args, args...)...)
 		}
 		if err != nil {
 			sbRet.Err = err
+			sbRet.Code = ErrorCode(err)
 		} else {
 			sbRet.Success = true
+			if q.t != TypeSelect && queryCacheInUse() {
+				q.db.InvalidateTables(queryTables("FROM " + q.table)...)
+			}
 			switch q.t {
 			case TypeInsert:
 				if DBType == "mysql" {
@@ -521,49 +585,52 @@ func (q *SQ) Exec(args ...interface{}) *result {
 
 // 查询记录集
 func (q *SQ) Query(args ...interface{}) ([]map[string]string, error) {
-	s, e := q.ToSql()
+	s, e := q.execSql()
 	if e != nil {
 		return nil, e
 	}
-	if q.debug {
-		log.Println("\n\tSQL prepare statement:\n\t", s, "\n\tParams:\n\t", args)
+	if q.cache {
+		return q.db.SelectCachedContext(q.context(), q.cacheTTL, q.cacheGroup, s, args...)
 	}
-	return q.db.Select(s, args...)
+	return q.db.SelectContext(q.context(), s, args...)
 }
 
 // 查询单行数据
 func (q *SQ) QueryOne(args ...interface{}) (OneRow, error) {
 	q.Limit(1, 0)
-	s, e := q.ToSql()
+	s, e := q.execSql()
 	if e != nil {
 		return nil, e
 	}
-	if q.debug {
-		log.Println("\n\tSQL prepare statement:\n\t", s, "\n\tParams:\n\t", args)
+	if q.cache {
+		ret, err := q.db.SelectCachedContext(q.context(), q.cacheTTL, q.cacheGroup, s, args...)
+		if err != nil {
+			return nil, err
+		}
+		if len(ret) == 0 {
+			return make(OneRow), nil
+		}
+		return ret[0], nil
 	}
-	return q.db.SelectOne(s, args...)
+	return q.db.SelectOneContext(q.context(), s, args...)
 }
 
 // 查询记录集
 func (q *SQ) QueryAllRow(args ...interface{}) (*sql.Rows, error) {
-	s, e := q.ToSql()
+	s, e := q.execSql()
 	if e != nil {
 		return nil, e
 	}
-	if q.debug {
-		log.Println("\n\tSQL prepare statement:\n\t", s, "\n\tParams:\n\t", args)
-	}
-	return q.db.Query(s, args...)
+	ctx := q.context()
+	return q.db.readQuery(ctx, s, args)
 }
 
 // 查询单行数据
-func (q *SQ) QueryRow(args ...interface{}) *sql.Row {
-	s, e := q.ToSql()
+func (q *SQ) QueryRow(args ...interface{}) *Row {
+	s, e := q.execSql()
 	if e != nil {
-		return nil
-	}
-	if q.debug {
-		log.Println("\n\tSQL prepare statement:\n\t", s, "\n\tParams:\n\t", args)
+		return &Row{err: e}
 	}
-	return q.db.QueryRow(s, args...)
+	ctx := q.context()
+	return q.db.readRow(ctx, s, args)
 }
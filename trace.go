@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// 追踪跨度
+// 语句执行结束时设置 db.rows 或 db.rows_affected 属性后调用 End, err 为语句的执行错误
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End(err error)
+}
+
+// 追踪器, 每条语句创建一个跨度, 可以适配 OpenTelemetry 等实现
+// 跨度名称为 db.语句类型(如 db.select), 设置 db.system 属性及钩子处理后的 db.statement 属性
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// 跨度可以选择实现该接口, 返回 W3C traceparent, 开启 SQLComment 时写入SQL注释
+type TraceParenter interface {
+	TraceParent() string
+}
+
+type commentKey struct{}
+
+// 在上下文中添加SQL注释标签, 开启 SQLComment 时写入使用该上下文执行的语句, 如 route、controller
+func WithSQLComment(ctx context.Context, key, value string) context.Context {
+	old, _ := ctx.Value(commentKey{}).(map[string]string)
+	tags := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		tags[k] = v
+	}
+	tags[key] = value
+	return context.WithValue(ctx, commentKey{}, tags)
+}
+
+// 数据库类型名称, 用作 db.system 属性
+func (this *Database) system() string {
+	if this.Type == "" {
+		return "mysql"
+	}
+	return this.Type
+}
+
+// 开始语句的跨度, 未设置追踪器时返回nil
+func (this *Database) startSpan(stmt *Statement) Span {
+	if this.Tracer == nil {
+		return nil
+	}
+	ctx, span := this.Tracer.Start(stmt.Ctx, "db."+statementType(stmt.Kind, stmt.Query))
+	if span == nil {
+		return nil
+	}
+	stmt.Ctx = ctx
+	span.SetAttribute("db.system", this.system())
+	return span
+}
+
+// 结束语句的跨度
+func endSpan(span Span, stmt *Statement) {
+	if stmt.Rows >= 0 {
+		span.SetAttribute("db.rows", stmt.Rows)
+	}
+	if stmt.RowsAffected >= 0 {
+		span.SetAttribute("db.rows_affected", stmt.RowsAffected)
+	}
+	span.End(stmt.Err)
+}
+
+// 按 sqlcommenter 格式在语句末尾添加注释, 如 SELECT 1 /*route='%2Fusers',traceparent='00-...'*/
+// 已包含注释的语句、事务语句及没有标签的语句不做修改
+func sqlComment(stmt *Statement, span Span) string {
+	if stmt.Kind != KindExec && stmt.Kind != KindQuery && stmt.Kind != KindQueryRow {
+		return stmt.Query
+	}
+	if strings.Contains(stmt.Query, "/*") || strings.Contains(stmt.Query, "--") {
+		return stmt.Query
+	}
+	tags, _ := stmt.Ctx.Value(commentKey{}).(map[string]string)
+	var traceparent string
+	if p, ok := span.(TraceParenter); ok {
+		traceparent = p.TraceParent()
+	}
+	if len(tags) == 0 && traceparent == "" {
+		return stmt.Query
+	}
+
+	keys := make([]string, 0, len(tags)+1)
+	for k := range tags {
+		if k != "traceparent" || traceparent == "" {
+			keys = append(keys, k)
+		}
+	}
+	if traceparent != "" {
+		keys = append(keys, "traceparent")
+	}
+	sort.Strings(keys)
+
+	s := strings.Builder{}
+	s.WriteString(strings.TrimRight(stmt.Query, " \t\r\n;"))
+	s.WriteString(" /*")
+	for i, k := range keys {
+		v := tags[k]
+		if k == "traceparent" && traceparent != "" {
+			v = traceparent
+		}
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(commentEscape(k))
+		s.WriteString("='")
+		s.WriteString(commentEscape(v))
+		s.WriteString("'")
+	}
+	s.WriteString("*/")
+	return s.String()
+}
+
+// 对注释的键与值进行URL编码, 编码后不含引号及注释结束符
+func commentEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
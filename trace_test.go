@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// 记录跨度的测试追踪器
+type testTracer struct {
+	lock  sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name        string
+	attrs       map[string]interface{}
+	ended       bool
+	err         error
+	traceparent string
+}
+
+type spanKey struct{}
+
+func (tr *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &testSpan{name: name, attrs: make(map[string]interface{})}
+	tr.lock.Lock()
+	tr.spans = append(tr.spans, s)
+	tr.lock.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *testSpan) End(err error) {
+	s.ended, s.err = true, err
+}
+
+// 返回 traceparent 的跨度
+type parentSpan struct {
+	*testSpan
+}
+
+func (s parentSpan) TraceParent() string {
+	return s.traceparent
+}
+
+type parentTracer struct {
+	testTracer
+}
+
+func (tr *parentTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx, s := tr.testTracer.Start(ctx, name)
+	ts := s.(*testSpan)
+	ts.traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	return ctx, parentSpan{ts}
+}
+
+func TestTracer(t *testing.T) {
+	d, srv := newTestDB(t)
+	tr := &testTracer{}
+	d.Tracer = tr
+	d.Type = "postgres"
+	var spanInHook *testSpan
+	d.AddHook(HookFuncs{BeforeFunc: func(stmt *Statement) error {
+		spanInHook, _ = stmt.Ctx.Value(spanKey{}).(*testSpan)
+		stmt.Query += " /* rewritten */"
+		return nil
+	}})
+
+	srv.affected = 3
+	srv.result([]string{"id"}, []driver.Value{int64(1)})
+	d.Exec("UPDATE t SET a = 1")
+	d.Select("SELECT id FROM t")
+	srv.fail(errTestDeadlock)
+	_, err := d.Exec("DELETE FROM t")
+
+	if len(tr.spans) != 3 {
+		t.Fatalf("spans = %d", len(tr.spans))
+	}
+	up, sel, del := tr.spans[0], tr.spans[1], tr.spans[2]
+	if up.name != "db.update" || sel.name != "db.select" || del.name != "db.delete" {
+		t.Fatalf("names = %s %s %s", up.name, sel.name, del.name)
+	}
+	want := map[string]interface{}{"db.system": "postgres", "db.statement": "UPDATE t SET a = 1 /* rewritten */", "db.rows_affected": int64(3)}
+	if !reflect.DeepEqual(up.attrs, want) || !up.ended || up.err != nil {
+		t.Fatalf("update span = %+v", up)
+	}
+	if sel.attrs["db.rows"] != int64(1) {
+		t.Fatalf("select span = %+v", sel)
+	}
+	if !del.ended || del.err != err || !IsDeadlock(del.err) {
+		t.Fatalf("delete span = %+v", del)
+	}
+	if spanInHook != del {
+		t.Fatal("hooks should see the span context")
+	}
+}
+
+func TestTracerVeto(t *testing.T) {
+	d, _ := newTestDB(t)
+	tr := &testTracer{}
+	d.Tracer = tr
+	d.AddHook(HookFuncs{BeforeFunc: func(stmt *Statement) error { return errors.New("denied") }})
+
+	d.Exec("UPDATE t SET a = 1")
+	s := tr.spans[0]
+	var veto *VetoError
+	if !errors.As(s.err, &veto) {
+		t.Fatalf("span err = %v", s.err)
+	}
+	if _, ok := s.attrs["db.statement"]; ok {
+		t.Fatal("vetoed statement should not be recorded")
+	}
+}
+
+func TestSQLComment(t *testing.T) {
+	d, srv := newTestDB(t)
+	d.SQLComment = true
+
+	ctx := WithSQLComment(context.Background(), "route", "/users/{id}")
+	ctx = WithSQLComment(ctx, "controller", "user's")
+	d.ExecContext(ctx, "UPDATE t SET a = 1;")
+	d.ExecContext(ctx, "UPDATE t SET a = 1 /* keep */")
+	d.ExecContext(context.Background(), "UPDATE t SET a = 2")
+	d.TransactionContext(ctx, nil, func(tx *Tx) error { return nil })
+
+	want := []string{
+		"UPDATE t SET a = 1 /*controller='user%27s',route='%2Fusers%2F%7Bid%7D'*/",
+		"UPDATE t SET a = 1 /* keep */",
+		"UPDATE t SET a = 2",
+		"BEGIN",
+		"COMMIT",
+	}
+	if q := srv.queries(); !reflect.DeepEqual(q, want) {
+		t.Fatalf("queries = %q", q)
+	}
+
+	// 跨度的 traceparent 优先于同名标签
+	srv.reset()
+	d.Tracer = &parentTracer{}
+	d.ExecContext(WithSQLComment(context.Background(), "traceparent", "x"), "SELECT 1")
+	if q := srv.queries()[0]; q != "SELECT 1 /*traceparent='00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01'*/" {
+		t.Fatalf("query = %q", q)
+	}
+}
+
+func TestWithSQLComment(t *testing.T) {
+	base := WithSQLComment(context.Background(), "a", "1")
+	child := WithSQLComment(base, "b", "2")
+	if tags := base.Value(commentKey{}).(map[string]string); len(tags) != 1 {
+		t.Fatalf("parent tags changed: %v", tags)
+	}
+	if tags := child.Value(commentKey{}).(map[string]string); !reflect.DeepEqual(tags, map[string]string{"a": "1", "b": "2"}) {
+		t.Fatalf("tags = %v", tags)
+	}
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// 事务对象, 通过事务对象执行的语句同样会调用数据库的钩子
+type Tx struct {
+	*sql.Tx
+	db  *Database
+	ctx context.Context
+}
+
+type txKey struct{}
+
+// 在事务中执行函数, 函数返回nil时提交事务, 否则回滚
+// 遇到死锁或锁等待超时时按重试策略重新执行整个函数, 因此函数内不应包含事务之外的副作用
+func (this *Database) Transaction(fn func(tx *Tx) error) error {
+	return this.TransactionContext(context.Background(), nil, fn)
+}
+
+// 在事务中执行函数
+func (this *Database) TransactionContext(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) error {
+	return this.Retry.do(ctx, isIdempotent(ctx), func() error {
+		return this.runTx(ctx, opts, fn)
+	})
+}
+
+// 执行一次事务
+func (this *Database) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) (err error) {
+	ctx = withTx(ctx)
+	var t *sql.Tx
+	err = this.run(ctx, KindBegin, "BEGIN", nil, func(stmt *Statement) (err error) {
+		t, err = this.DB.BeginTx(ctx, opts)
+		return wrapError(err, stmt.Query, nil)
+	})
+	if err != nil {
+		return err
+	}
+	tx := &Tx{Tx: t, db: this, ctx: ctx}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 提交事务
+// 使用会话上下文的事务提交后记录写入, 参见 Session
+func (tx *Tx) Commit() error {
+	err := tx.db.run(tx.ctx, KindCommit, "COMMIT", nil, func(stmt *Statement) error {
+		return wrapError(tx.Tx.Commit(), stmt.Query, nil)
+	})
+	if err == nil {
+		tx.db.markWrite(tx.ctx)
+	}
+	return err
+}
+
+// 回滚事务
+func (tx *Tx) Rollback() error {
+	return tx.db.run(tx.ctx, KindRollback, "ROLLBACK", nil, func(stmt *Statement) error {
+		err := tx.Tx.Rollback()
+		if err == sql.ErrTxDone {
+			return nil
+		}
+		return wrapError(err, stmt.Query, nil)
+	})
+}
+
+// 在事务中执行语句
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(tx.ctx, query, args...)
+}
+
+// 在事务中执行语句
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	var ret sql.Result
+	err := tx.db.run(withTx(ctx), KindExec, query, args, func(stmt *Statement) (err error) {
+		ret, err = tx.Tx.ExecContext(ctx, stmt.Query, stmt.Args...)
+		if err == nil {
+			stmt.RowsAffected, _ = ret.RowsAffected()
+			stmt.LastInsertID, _ = ret.LastInsertId()
+		}
+		return wrapError(err, stmt.Query, stmt.Args)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// 在事务中查询记录集
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.QueryContext(tx.ctx, query, args...)
+}
+
+// 在事务中查询记录集
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := tx.db.run(withTx(ctx), KindQuery, query, args, func(stmt *Statement) (err error) {
+		rows, err = tx.Tx.QueryContext(ctx, stmt.Query, stmt.Args...)
+		return wrapError(err, stmt.Query, stmt.Args)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// 在事务中查询单条记录
+func (tx *Tx) QueryRow(query string, args ...interface{}) *Row {
+	return tx.QueryRowContext(tx.ctx, query, args...)
+}
+
+// 在事务中查询单条记录, 语句被钩子否决时 Scan 返回 *VetoError
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	var row *sql.Row
+	err := tx.db.run(withTx(ctx), KindQueryRow, query, args, func(stmt *Statement) error {
+		row = tx.Tx.QueryRowContext(ctx, stmt.Query, stmt.Args...)
+		return nil
+	})
+	if err != nil {
+		setLastErr(err)
+		return &Row{err: err}
+	}
+	return &Row{row: row, query: query, args: args}
+}
+
+// 标记上下文为事务中执行
+func withTx(ctx context.Context) context.Context {
+	if v, _ := ctx.Value(txKey{}).(bool); v {
+		return ctx
+	}
+	return context.WithValue(ctx, txKey{}, true)
+}
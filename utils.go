@@ -216,6 +216,16 @@ func logWari(war ...interface{}) {
 	// 输出日志
 	pc, _, line, _ := runtime.Caller(1)
 	p := runtime.FuncForPC(pc)
-	t := time.Now().Local().Format("2006/01/02 15:04:05.999999")
-	fmt.Print(fmt.Sprintf("%s%-26s \u001B[%dm[%s]\u001B[0m %s(%d): %s\n", "", t, 33, "WARI", p.Name(), line, fmt.Sprint(war...)))
+	DefaultLogger.Log(LevelWarn, fmt.Sprint(war...), "caller", p.Name()+"("+strconv.Itoa(line)+")")
+}
+
+// 获取列值的字节形式, 开启 parseTime 时时间列的值为 time.Time
+func rawBytes(v interface{}) []byte {
+	switch val := v.(type) {
+	case []byte:
+		return val
+	case time.Time:
+		return []byte(val.Format("2006-01-02 15:04:05.999999999"))
+	}
+	return []byte(fmt.Sprint(v))
 }